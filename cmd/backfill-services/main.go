@@ -0,0 +1,53 @@
+// Command backfill-services links subscriptions created before the service
+// catalog existed to their catalog services. It is safe to run more than
+// once; only unlinked subscriptions are touched.
+//
+//	go run ./cmd/backfill-services [-create] [-actor name]
+package main
+
+import (
+	"flag"
+
+	"testtask/internal/cache"
+	"testtask/internal/config"
+	"testtask/internal/repository"
+	logger "testtask/pkg"
+)
+
+func main() {
+	create := flag.Bool("create", false, "add names missing from the catalog as new services")
+	actor := flag.String("actor", "backfill", "actor recorded in the subscription history")
+	flag.Parse()
+
+	logger.Init()
+	cfg, err := config.LoadFromYAML()
+	if err != nil {
+		logger.Log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := repository.Connect(cfg.Database)
+	if err != nil {
+		logger.Log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	// Linked subscriptions are evicted from the cache so the API does not
+	// serve them without their service.
+	redisClient, err := cache.Connect(cfg.Redis)
+	if err != nil {
+		logger.Log.WithError(err).Warn("Redis not available; cached subscriptions expire within an hour")
+	}
+	if redisClient != nil {
+		defer redisClient.Close()
+	}
+
+	repo := repository.NewSubscriptionRepository(db, logger.Log, redisClient)
+	report, err := repo.BackfillServices(*create, *actor)
+	if err != nil {
+		logger.Log.Fatalf("Backfill failed: %v", err)
+	}
+	logger.Log.Infof("Linked %d subscriptions, created %d services", report.SubscriptionsLinked, report.ServicesCreated)
+	for _, name := range report.Unmatched {
+		logger.Log.Warnf("No catalog service for %q", name)
+	}
+}
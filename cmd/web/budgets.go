@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	gorilla_mux "github.com/gorilla/mux"
+
+	"testtask/internal/models"
+	"testtask/internal/validation"
+	logger "testtask/pkg"
+)
+
+// budgetChecks tracks the budget evaluations running in the background.
+var budgetChecks sync.WaitGroup
+
+// evaluateBudgets checks the months from..to against the budgets of a user
+// in the background, so that the response to the change that triggered it
+// does not wait. Failures are logged only, the change itself has already
+// been stored.
+func evaluateBudgets(userID uuid.UUID, from, to models.MonthDate) {
+	repo := appRepo
+	budgetChecks.Add(1)
+	go func() {
+		defer budgetChecks.Done()
+		alerts, err := repo.EvaluateBudgets(userID, from, to)
+		if err != nil {
+			logger.Log.WithError(err).WithField("user_id", userID).Error("failed to evaluate budgets")
+			return
+		}
+		for _, a := range alerts {
+			logger.Log.WithField("user_id", userID).Warnf("Budget %d reached %d%% in %s: spent %d of %d", a.BudgetID, a.Threshold, a.Month, a.Spend, a.Amount)
+		}
+	}()
+}
+
+// evaluateSubscriptionBudgets checks the budgets of the owners and members
+// of subs, usually a subscription before and after a change, in every
+// month one of them is charged for.
+func evaluateSubscriptionBudgets(subs ...*models.Subscription) {
+	from, to := chargedMonths(time.Now(), subs...)
+	users := map[uuid.UUID]bool{}
+	for _, sub := range subs {
+		users[sub.UserID] = true
+		for _, m := range sub.Members {
+			users[m.UserID] = true
+		}
+	}
+	for userID := range users {
+		evaluateBudgets(userID, from, to)
+	}
+}
+
+// chargedMonths returns the months subs are active in, from the earliest
+// start to the latest end. Open-ended subscriptions count up to the month
+// of now, the way /subscription/total charges them.
+func chargedMonths(now time.Time, subs ...*models.Subscription) (from, to models.MonthDate) {
+	current := models.MonthOf(now)
+	for i, sub := range subs {
+		end := current
+		if sub.EndDate != nil {
+			end = *sub.EndDate
+		}
+		if end.Before(sub.StartDate.Time) {
+			end = sub.StartDate
+		}
+		if i == 0 || sub.StartDate.Before(from.Time) {
+			from = sub.StartDate
+		}
+		if i == 0 || end.After(to.Time) {
+			to = end
+		}
+	}
+	return from, to
+}
+
+// budgetFromRequest validates req and applies it to b.
+func budgetFromRequest(req models.BudgetRequest, b *models.Budget) error {
+	if err := validation.Struct(req); err != nil {
+		return err
+	}
+	if req.ServiceName != nil && *req.ServiceName == "" {
+		req.ServiceName = nil
+	}
+	if req.Category != nil && *req.Category == "" {
+		req.Category = nil
+	}
+	if req.ServiceName != nil && req.Category != nil {
+		return validation.Errors{{Field: "category", Message: "cannot be combined with service_name"}}
+	}
+	b.ServiceName = req.ServiceName
+	b.Category = req.Category
+	b.Amount = req.Amount
+	b.Currency = req.Currency
+	if b.Currency == "" {
+		b.Currency = defaultCurrency
+	}
+	return nil
+}
+
+func budgetUserID(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(gorilla_mux.Vars(r)["id"])
+}
+
+// CreateBudgetHandler godoc
+// @Summary Create budget
+// @Description Sets a monthly budget for the user, overall when service_name and category are omitted, for one service or for the catalog services of one category. Each scope has one budget. An alert is recorded the first time in a month the spend reaches 80% and 100% of the budget. Budgets are checked in the background: for the current month when a budget is set, and for every month a subscription is charged for when it is created, changed, deleted or restored.
+// @Tags budgets
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (UUID)"
+// @Param budget body models.BudgetRequest true "Budget"
+// @Success 201 {object} models.Budget
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /users/{id}/budgets [post]
+func CreateBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := budgetUserID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	var req models.BudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	budget := &models.Budget{UserID: userID}
+	if err := budgetFromRequest(req, budget); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.CreateBudget(budget); err != nil {
+		writeRepoError(w, err, "failed to create budget")
+		return
+	}
+	current := models.MonthOf(time.Now())
+	evaluateBudgets(userID, current, current)
+	writeJSON(w, http.StatusCreated, budget)
+}
+
+// ListBudgetsHandler godoc
+// @Summary List budgets of a user
+// @Tags budgets
+// @Produce json
+// @Param id path string true "User ID (UUID)"
+// @Success 200 {array} models.Budget
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /users/{id}/budgets [get]
+func ListBudgetsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := budgetUserID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	budgets, err := appRepo.ListBudgets(userID)
+	if err != nil {
+		writeRepoError(w, err, "failed to list budgets")
+		return
+	}
+	writeJSON(w, http.StatusOK, budgets)
+}
+
+// UpdateBudgetHandler godoc
+// @Summary Replace budget by id
+// @Tags budgets
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (UUID)"
+// @Param budget_id path int true "Budget ID"
+// @Param budget body models.BudgetRequest true "Budget"
+// @Success 200 {object} models.Budget
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /users/{id}/budgets/{budget_id} [put]
+func UpdateBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := budgetUserID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["budget_id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid budget id")
+		return
+	}
+	var req models.BudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	budget := &models.Budget{ID: id, UserID: userID}
+	if err := budgetFromRequest(req, budget); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.UpdateBudget(budget); err != nil {
+		writeRepoError(w, err, "failed to update budget")
+		return
+	}
+	current := models.MonthOf(time.Now())
+	evaluateBudgets(userID, current, current)
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// DeleteBudgetHandler godoc
+// @Summary Delete budget by id
+// @Description Removes the budget and its alerts.
+// @Tags budgets
+// @Produce json
+// @Param id path string true "User ID (UUID)"
+// @Param budget_id path int true "Budget ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /users/{id}/budgets/{budget_id} [delete]
+func DeleteBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := budgetUserID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["budget_id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid budget id")
+		return
+	}
+	if err := appRepo.DeleteBudget(userID, id); err != nil {
+		writeRepoError(w, err, "failed to delete budget")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// GetBudgetStatusHandler godoc
+// @Summary Budget status of a user
+// @Description Compares the spend of a month, the current one by default, with every budget of the user. Spend is calculated like /subscription/total with renewal allocation, in the currency of each budget. Alerts lists the thresholds reached in that month.
+// @Tags budgets
+// @Produce json
+// @Param id path string true "User ID (UUID)"
+// @Param month query string false "Month (MM-YYYY), defaults to the current month"
+// @Success 200 {object} models.BudgetStatusResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /users/{id}/budget/status [get]
+func GetBudgetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := budgetUserID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	month, err := parseMonthParam(r.URL.Query(), "month")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if month == nil {
+		current := models.MonthOf(time.Now())
+		month = &current
+	}
+	status, err := appRepo.BudgetStatus(userID, *month)
+	if err != nil {
+		writeRepoError(w, err, "failed to get budget status")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
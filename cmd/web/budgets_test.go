@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+func TestChargedMonths(t *testing.T) {
+	month := func(s string) models.MonthDate {
+		m, err := models.ParseMonthDate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	sub := func(start, end string) *models.Subscription {
+		s := &models.Subscription{StartDate: month(start)}
+		if end != "" {
+			e := month(end)
+			s.EndDate = &e
+		}
+		return s
+	}
+	now := time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		subs     []*models.Subscription
+		from, to string
+	}{
+		{"ended", []*models.Subscription{sub("01-2025", "03-2025")}, "01-2025", "03-2025"},
+		{"open-ended counts up to now", []*models.Subscription{sub("01-2025", "")}, "01-2025", "06-2025"},
+		{"ends in the future", []*models.Subscription{sub("01-2025", "12-2025")}, "01-2025", "12-2025"},
+		{"starts in the future", []*models.Subscription{sub("09-2025", "")}, "09-2025", "09-2025"},
+		{"before and after a change", []*models.Subscription{sub("03-2025", "04-2025"), sub("01-2025", "02-2025")}, "01-2025", "04-2025"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := chargedMonths(now, tt.subs...)
+			if from.String() != tt.from || to.String() != tt.to {
+				t.Errorf("chargedMonths = %s..%s, want %s..%s", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+// TestBudgetsOnDelete checks that the months a subscription was charged
+// for are evaluated, also when it is deleted.
+func TestBudgetsOnDelete(t *testing.T) {
+	useTestRepository(t)
+	user := uuid.MustParse(importUser)
+	budget := &models.Budget{UserID: user, Amount: 1000, Currency: "RUB"}
+	if err := appRepo.CreateBudget(budget); err != nil {
+		t.Fatalf("CreateBudget: %v", err)
+	}
+	// A subscription that only ran in the past reaches the budget then.
+	w := serve(t, http.MethodPost, "/subscription", map[string]interface{}{
+		"service_name": "Netflix", "price": 900, "user_id": importUser,
+		"start_date": "01-2024", "end_date": "02-2024",
+	}, nil)
+	expectStatus(t, w, http.StatusCreated)
+	var created models.Subscription
+	decode(t, w, &created)
+	budgetChecks.Wait()
+
+	status, err := appRepo.BudgetStatus(user, created.StartDate)
+	if err != nil {
+		t.Fatalf("BudgetStatus: %v", err)
+	}
+	if alerts := status.Budgets[0].Alerts; len(alerts) != 1 || alerts[0].Threshold != 80 {
+		t.Fatalf("alerts after create = %+v", alerts)
+	}
+
+	// The owner's spend on a deleted subscription drops out; evaluating
+	// it must not fail.
+	expectStatus(t, serve(t, http.MethodDelete, "/subscription/"+strconv.Itoa(created.ID), nil, nil), http.StatusOK)
+	budgetChecks.Wait()
+	status, err = appRepo.BudgetStatus(user, created.StartDate)
+	if err != nil {
+		t.Fatalf("BudgetStatus: %v", err)
+	}
+	if s := status.Budgets[0]; s.Spend != 0 || len(s.Alerts) != 1 {
+		t.Errorf("status after delete = %+v", s)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"testtask/internal/rates"
+	"testtask/internal/repository"
+	logger "testtask/pkg"
+)
+
+// statusFromError maps repository errors to HTTP statuses.
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrValidation), errors.Is(err, rates.ErrNoRate):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeRepoError logs err and answers with the status it maps to. msg is
+// shown to the client for failures that are not self-explanatory.
+func writeRepoError(w http.ResponseWriter, err error, msg string) {
+	status := statusFromError(err)
+	switch status {
+	case http.StatusNotFound:
+		msg = "not found"
+	case http.StatusPreconditionFailed:
+		msg = "subscription was modified, reload it and retry"
+	case http.StatusServiceUnavailable:
+		msg = "service temporarily unavailable"
+	case http.StatusUnprocessableEntity:
+		if errors.Is(err, rates.ErrNoRate) {
+			msg = err.Error()
+		}
+	}
+	entry := logger.Log.WithError(err).WithField("status", status)
+	if status >= http.StatusInternalServerError {
+		entry.Error(msg)
+	} else {
+		entry.Warn(msg)
+	}
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	writeError(w, status, msg)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"testtask/internal/models"
+)
+
+// requireIfMatch makes PATCH and DELETE fail with 428 when the client does
+// not send If-Match.
+var requireIfMatch bool
+
+// checkIfMatch compares the If-Match header with the current version of
+// sub. It writes the error response and returns false when the request
+// must not proceed. If-Match uses the strong comparison (RFC 7232, 3.1),
+// so a weak tag never matches; only strong tags are sent.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, sub *models.Subscription) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if requireIfMatch {
+			writeError(w, http.StatusPreconditionRequired, "If-Match header is required")
+			return false
+		}
+		return true
+	}
+	current := sub.ETag()
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	w.Header().Set("ETag", current)
+	writeError(w, http.StatusPreconditionFailed, "subscription was modified, reload it and retry")
+	return false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"testtask/internal/models"
+)
+
+func TestCheckIfMatch(t *testing.T) {
+	sub := &models.Subscription{ID: 1, Version: 3}
+
+	tests := []struct {
+		name     string
+		ifMatch  string
+		required bool
+		want     int // 0 when the request may proceed
+	}{
+		{"no header", "", false, 0},
+		{"no header when required", "", true, http.StatusPreconditionRequired},
+		{"current version", `"3"`, true, 0},
+		{"any version", "*", true, 0},
+		{"one of a list", `"1", "3"`, false, 0},
+		{"stale version", `"2"`, false, http.StatusPreconditionFailed},
+		{"unquoted", `3`, false, http.StatusPreconditionFailed},
+		{"weak tags never match", `W/"3"`, false, http.StatusPreconditionFailed},
+		{"weak tag in a list", `W/"3", "4"`, false, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old bool) { requireIfMatch = old }(requireIfMatch)
+			requireIfMatch = tt.required
+
+			r := httptest.NewRequest(http.MethodPatch, "/subscription/1", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			ok := checkIfMatch(w, r, sub)
+			if tt.want == 0 {
+				if !ok || w.Body.Len() != 0 {
+					t.Errorf("checkIfMatch = %v, response %d %s", ok, w.Code, w.Body)
+				}
+				return
+			}
+			if ok || w.Code != tt.want {
+				t.Fatalf("checkIfMatch = %v, status %d, want %d", ok, w.Code, tt.want)
+			}
+			if tt.want == http.StatusPreconditionFailed && w.Header().Get("ETag") != `"3"` {
+				t.Errorf("ETag = %q, want the current strong tag", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestIfMatchHandlers(t *testing.T) {
+	useTestRepository(t)
+	req := models.CreateSubscriptionRequest{ServiceName: "Netflix", Price: 400, UserID: importUser}
+	req.StartDate, _ = models.ParseMonthDate("07-2025")
+	created := serve(t, http.MethodPost, "/subscription", req, nil)
+	expectStatus(t, created, http.StatusCreated)
+	var sub models.Subscription
+	decode(t, created, &sub)
+	target := "/subscription/" + strconv.Itoa(sub.ID)
+	etag := created.Header().Get("ETag")
+	if etag != sub.ETag() {
+		t.Fatalf("ETag = %q, want %q", etag, sub.ETag())
+	}
+
+	price := 500
+	update := models.UpdateSubscriptionRequest{Price: &price}
+	w := serve(t, http.MethodPatch, target, update, map[string]string{"If-Match": etag})
+	expectStatus(t, w, http.StatusOK)
+	current := w.Header().Get("ETag")
+	if current == "" || current == etag {
+		t.Fatalf("ETag after update = %q, was %q", current, etag)
+	}
+
+	for _, stale := range []string{etag, "W/" + current} {
+		w = serve(t, http.MethodPatch, target, update, map[string]string{"If-Match": stale})
+		expectStatus(t, w, http.StatusPreconditionFailed)
+		if got := w.Header().Get("ETag"); got != current {
+			t.Errorf("If-Match %s: ETag = %q, want %q", stale, got, current)
+		}
+		expectStatus(t, serve(t, http.MethodDelete, target, nil, map[string]string{"If-Match": stale}), http.StatusPreconditionFailed)
+	}
+
+	w = serve(t, http.MethodGet, target, nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	var stored models.Subscription
+	decode(t, w, &stored)
+	if stored.Price != 500 || w.Header().Get("ETag") != current {
+		t.Errorf("stored price %d with ETag %q after rejected requests", stored.Price, w.Header().Get("ETag"))
+	}
+	expectStatus(t, serve(t, http.MethodDelete, target, nil, map[string]string{"If-Match": current}), http.StatusOK)
+}
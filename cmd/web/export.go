@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+
+	"testtask/internal/models"
+	logger "testtask/pkg"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+	exportFormatXLSX   = "xlsx"
+
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:    "text/csv; charset=utf-8",
+	exportFormatNDJSON: "application/x-ndjson",
+	exportFormatXLSX:   xlsxContentType,
+}
+
+var exportColumns = []string{
+	"id", "service_name", "price", "currency", "user_id", "start_date", "end_date", "billing_period", "interval_count",
+	"service_id", "plan_id", "price_overridden", "trial_end", "discounts",
+}
+
+// exportWriter encodes a stream of subscriptions in one export format.
+type exportWriter interface {
+	Write(sub *models.Subscription) error
+	Close() error
+}
+
+// ExportSubscriptionsHandler godoc
+// @Summary Export subscriptions
+// @Description Exports every subscription matching the list filters. The format is taken from the format parameter or, when absent, from the Accept header; CSV is the default. CSV and NDJSON are streamed, so a failure after the first row truncates the file. XLSX is built completely before it is sent and fails with an error status instead.
+// @Tags subscriptions
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "Export format" Enums(csv, ndjson, xlsx)
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param min_price query int false "Minimal price"
+// @Param max_price query int false "Maximal price"
+// @Param active_from query string false "Active on or after month (MM-YYYY)"
+// @Param active_to query string false "Active on or before month (MM-YYYY)"
+// @Param sort_by query string false "Sort column" Enums(id, service_name, price, user_id, start_date, end_date)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param as_of query string false "Read the data as it was at this moment (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 406 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/export [get]
+func ExportSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params, err := parseListParams(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = exportFormatFromAccept(r.Header.Get("Accept"))
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "unsupported export format")
+		return
+	}
+	if format == exportFormatXLSX {
+		exportXLSX(w, params)
+		return
+	}
+
+	var out exportWriter
+	begin := func() error {
+		startExport(w, contentType, format)
+		var err error
+		out, err = newExportWriter(format, w)
+		return err
+	}
+
+	rows := 0
+	err = appRepo.ExportSubscriptions(params, func(sub *models.Subscription) error {
+		if out == nil {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		rows++
+		return out.Write(sub)
+	})
+	if err != nil {
+		if out == nil {
+			writeRepoError(w, err, "failed to export subscriptions")
+			return
+		}
+		// The status line is already sent, the client sees a truncated file.
+		logger.Log.WithError(err).Error("subscription export aborted")
+		return
+	}
+	if out == nil {
+		if err := begin(); err != nil {
+			logger.Log.WithError(err).Error("failed to start export")
+			return
+		}
+	}
+	if err := out.Close(); err != nil {
+		logger.Log.WithError(err).Error("failed to finish export")
+		return
+	}
+	logger.Log.Infof("Exported %d subscriptions as %s", rows, format)
+}
+
+// startExport sends the status line and headers of a successful export.
+func startExport(w http.ResponseWriter, contentType, format string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subscriptions.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+}
+
+// exportXLSX builds the whole workbook before anything is sent. A zip
+// container cannot be streamed anyway, and this way a failed export is
+// still answered with an error status instead of a corrupt file.
+func exportXLSX(w http.ResponseWriter, params models.ListParams) {
+	out, err := newXLSXExportWriter()
+	if err != nil {
+		logger.Log.WithError(err).Error("failed to start export")
+		writeError(w, http.StatusInternalServerError, "failed to export subscriptions")
+		return
+	}
+	defer out.file.Close()
+
+	rows := 0
+	err = appRepo.ExportSubscriptions(params, func(sub *models.Subscription) error {
+		rows++
+		return out.Write(sub)
+	})
+	if err != nil {
+		writeRepoError(w, err, "failed to export subscriptions")
+		return
+	}
+	if err := out.sheet.Flush(); err != nil {
+		logger.Log.WithError(err).Error("failed to finish export")
+		writeError(w, http.StatusInternalServerError, "failed to export subscriptions")
+		return
+	}
+	startExport(w, xlsxContentType, exportFormatXLSX)
+	if err := out.file.Write(w); err != nil {
+		logger.Log.WithError(err).Error("failed to send export")
+		return
+	}
+	logger.Log.Infof("Exported %d subscriptions as %s", rows, exportFormatXLSX)
+}
+
+// exportFormatFromAccept picks the first supported media type in the
+// Accept header, defaulting to CSV.
+func exportFormatFromAccept(accept string) string {
+	if accept == "" {
+		return exportFormatCSV
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv", "application/csv", "*/*", "text/*":
+			return exportFormatCSV
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			return exportFormatNDJSON
+		case xlsxContentType:
+			return exportFormatXLSX
+		}
+	}
+	return ""
+}
+
+func newExportWriter(format string, w http.ResponseWriter) (exportWriter, error) {
+	switch format {
+	case exportFormatNDJSON:
+		return &ndjsonExportWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		out := &csvExportWriter{w: csv.NewWriter(w)}
+		return out, out.w.Write(exportColumns)
+	}
+}
+
+type csvExportWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func (c *csvExportWriter) Write(sub *models.Subscription) error {
+	end := ""
+	if sub.EndDate != nil {
+		end = sub.EndDate.String()
+	}
+	trialEnd := ""
+	if sub.TrialEnd != nil {
+		trialEnd = sub.TrialEnd.String()
+	}
+	discounts, err := exportDiscounts(sub.Discounts)
+	if err != nil {
+		return err
+	}
+	if err := c.w.Write([]string{
+		strconv.Itoa(sub.ID),
+		sub.ServiceName,
+		strconv.Itoa(sub.Price),
+		sub.Currency,
+		sub.UserID.String(),
+		sub.StartDate.String(),
+		end,
+		sub.BillingPeriod,
+		strconv.Itoa(sub.IntervalCount),
+		optionalID(sub.ServiceID),
+		optionalID(sub.PlanID),
+		strconv.FormatBool(sub.PriceOverridden),
+		trialEnd,
+		discounts,
+	}); err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%500 == 0 {
+		c.w.Flush()
+	}
+	return c.w.Error()
+}
+
+// optionalID formats a nullable reference, empty when it is not set.
+func optionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// exportDiscounts encodes the discounts as a JSON array, the form the CSV
+// import reads back, and leaves the cell empty when there are none.
+func exportDiscounts(discounts models.Discounts) (string, error) {
+	if len(discounts) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(discounts)
+	return string(data), err
+}
+
+func (c *csvExportWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonExportWriter) Write(sub *models.Subscription) error {
+	return n.enc.Encode(sub)
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter writes rows through the excelize stream writer, which
+// spills to a temporary file instead of keeping the sheet in memory. The
+// workbook is sent by exportXLSX once every row is written.
+type xlsxExportWriter struct {
+	file      *excelize.File
+	sheet     *excelize.StreamWriter
+	dateStyle int
+	dayStyle  int
+	numStyle  int
+	row       int
+}
+
+func newXLSXExportWriter() (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	sheet, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	dateFormat := "mm-yyyy"
+	dateStyle, err := file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return nil, err
+	}
+	dayFormat := "yyyy-mm-dd"
+	dayStyle, err := file.NewStyle(&excelize.Style{CustomNumFmt: &dayFormat})
+	if err != nil {
+		return nil, err
+	}
+	numStyle, err := file.NewStyle(&excelize.Style{NumFmt: 3})
+	if err != nil {
+		return nil, err
+	}
+	header := make([]interface{}, len(exportColumns))
+	for i, name := range exportColumns {
+		header[i] = name
+	}
+	if err := sheet.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{file: file, sheet: sheet, dateStyle: dateStyle, dayStyle: dayStyle, numStyle: numStyle, row: 1}, nil
+}
+
+func (x *xlsxExportWriter) Write(sub *models.Subscription) error {
+	x.row++
+	var end interface{}
+	if sub.EndDate != nil {
+		end = excelize.Cell{StyleID: x.dateStyle, Value: sub.EndDate.Time}
+	}
+	var serviceID, planID, trialEnd interface{}
+	if sub.ServiceID != nil {
+		serviceID = *sub.ServiceID
+	}
+	if sub.PlanID != nil {
+		planID = *sub.PlanID
+	}
+	if sub.TrialEnd != nil {
+		trialEnd = excelize.Cell{StyleID: x.dayStyle, Value: sub.TrialEnd.Time}
+	}
+	discounts, err := exportDiscounts(sub.Discounts)
+	if err != nil {
+		return err
+	}
+	cell, err := excelize.CoordinatesToCellName(1, x.row)
+	if err != nil {
+		return err
+	}
+	return x.sheet.SetRow(cell, []interface{}{
+		sub.ID,
+		sub.ServiceName,
+		excelize.Cell{StyleID: x.numStyle, Value: sub.Price},
+		sub.Currency,
+		sub.UserID.String(),
+		excelize.Cell{StyleID: x.dateStyle, Value: sub.StartDate.Time},
+		end,
+		sub.BillingPeriod,
+		sub.IntervalCount,
+		serviceID,
+		planID,
+		sub.PriceOverridden,
+		trialEnd,
+		discounts,
+	})
+}
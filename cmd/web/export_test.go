@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+func exportedSubscriptions(t *testing.T) []*models.Subscription {
+	t.Helper()
+	month := func(s string) models.MonthDate {
+		m, err := models.ParseMonthDate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	end := month("12-2025")
+	trialEnd, err := models.ParseDate("2025-07-14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceID, planID := 3, 5
+	user := uuid.MustParse(importUser)
+
+	plain := &models.Subscription{ID: 1, ServiceName: "Netflix", Price: 400, Currency: "RUB", UserID: user,
+		StartDate: month("07-2025"), BillingPeriod: models.BillingMonth, IntervalCount: 1}
+	full := &models.Subscription{ID: 2, ServiceName: "Spotify, Family", Price: 30, Currency: "USD", UserID: user,
+		StartDate: month("07-2025"), EndDate: &end, BillingPeriod: models.BillingQuarter, IntervalCount: 2,
+		ServiceID: &serviceID, TrialEnd: &trialEnd, Discounts: models.Discounts{
+			{Kind: models.DiscountPercent, Value: 12.5, Months: 3},
+			{Kind: models.DiscountFixed, Value: 100, Months: 1},
+		}}
+	onPlan := &models.Subscription{ID: 3, ServiceName: "Yandex Plus", Price: 299, Currency: "RUB", UserID: user,
+		StartDate: month("07-2025"), BillingPeriod: models.BillingMonth, IntervalCount: 1, ServiceID: &serviceID, PlanID: &planID}
+	overridden := *onPlan
+	overridden.ID = 4
+	overridden.Price = 199
+	overridden.PriceOverridden = true
+	return []*models.Subscription{plain, full, onPlan, &overridden}
+}
+
+// TestCSVExportRoundTrip checks that a CSV export can be imported again.
+func TestCSVExportRoundTrip(t *testing.T) {
+	subs := exportedSubscriptions(t)
+	rec := httptest.NewRecorder()
+	out, err := newExportWriter(exportFormatCSV, rec)
+	if err != nil {
+		t.Fatalf("newExportWriter: %v", err)
+	}
+	for _, sub := range subs {
+		if err := out.Write(sub); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if header, _, _ := strings.Cut(rec.Body.String(), "\n"); header != strings.Join(exportColumns, ",") {
+		t.Errorf("header = %q", header)
+	}
+
+	rows, err := readCSVImport(rec.Body)
+	if err != nil {
+		t.Fatalf("readCSVImport: %v", err)
+	}
+	if len(rows) != len(subs) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(subs))
+	}
+	for i, row := range rows {
+		if row.Err != nil {
+			t.Errorf("row %d: %v", i, row.Err)
+			continue
+		}
+		want := *subs[i]
+		want.ID = 0
+		// The catalog link is resolved again from the name on insert.
+		want.ServiceID = nil
+		if want.PlanID != nil && !want.PriceOverridden {
+			// The plan provides the price again.
+			want.Price = 0
+		}
+		// Like POST /subscription, a given price counts as overridden.
+		want.PriceOverridden = want.Price != 0
+		if got := *row.Sub; !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestNDJSONExportRoundTrip(t *testing.T) {
+	subs := exportedSubscriptions(t)[:2]
+	rec := httptest.NewRecorder()
+	out, err := newExportWriter(exportFormatNDJSON, rec)
+	if err != nil {
+		t.Fatalf("newExportWriter: %v", err)
+	}
+	for _, sub := range subs {
+		if err := out.Write(sub); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	rows, err := readNDJSONImport(rec.Body)
+	if err != nil {
+		t.Fatalf("readNDJSONImport: %v", err)
+	}
+	if len(rows) != len(subs) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(subs))
+	}
+	for i, row := range rows {
+		if row.Err != nil {
+			t.Errorf("row %d: %v", i, row.Err)
+			continue
+		}
+		want := *subs[i]
+		want.ID = 0
+		want.ServiceID = nil
+		want.PriceOverridden = true
+		if got := *row.Sub; !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestExportFormatFromAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", exportFormatCSV},
+		{"*/*", exportFormatCSV},
+		{"application/x-ndjson", exportFormatNDJSON},
+		{"text/html, " + xlsxContentType + ";q=0.9", exportFormatXLSX},
+		{"application/pdf", ""},
+	}
+	for _, tt := range tests {
+		if got := exportFormatFromAccept(tt.accept); got != tt.want {
+			t.Errorf("exportFormatFromAccept(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+const (
+	defaultForecastMonths = 12
+	maxForecastMonths     = 120
+)
+
+func parseForecastParams(q url.Values) (models.TotalFilter, int, error) {
+	var filter models.TotalFilter
+	if v := q.Get("user_id"); v != "" {
+		if _, err := uuid.Parse(v); err != nil {
+			return filter, 0, fmt.Errorf("invalid user_id")
+		}
+		filter.UserID = &v
+	}
+	if v := q.Get("service_name"); v != "" {
+		filter.ServiceName = &v
+	}
+	if v := q.Get("category"); v != "" {
+		filter.Category = &v
+	}
+	if v := q.Get("plan_id"); v != "" {
+		planID, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, fmt.Errorf("invalid plan_id")
+		}
+		filter.PlanID = &planID
+	}
+	months := defaultForecastMonths
+	if v := q.Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastMonths {
+			return filter, 0, fmt.Errorf("invalid months, expected 1 to %d", maxForecastMonths)
+		}
+		months = n
+	}
+	if err := parseCostParams(q, &filter); err != nil {
+		return filter, 0, err
+	}
+	return filter, months, nil
+}
+
+// GetForecastHandler godoc
+// @Summary Forecast subscription spend
+// @Description Projects the spend of the next months, starting with the current one, from the subscriptions active in that window. Costs are calculated like /subscription/total, so end dates, billing periods and currencies are taken into account.
+// @Tags subscriptions
+// @Produce json
+// @Param months query int false "Number of months, 1 to 120 (default 12)"
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param allocation query string false "How to charge non-monthly billing periods" Enums(renewal, amortized)
+// @Param currency query string false "Currency to convert the forecast into, defaults to the configured currency"
+// @Success 200 {object} models.ForecastResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/forecast [get]
+func GetForecastHandler(w http.ResponseWriter, r *http.Request) {
+	filter, months, err := parseForecastParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	forecast, err := appRepo.Forecast(filter, months)
+	if err != nil {
+		writeRepoError(w, err, "failed to build forecast")
+		return
+	}
+	writeJSON(w, http.StatusOK, forecast)
+}
@@ -2,28 +2,380 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	gorilla_mux "github.com/gorilla/mux"
 
 	"testtask/internal/models"
+	"testtask/internal/patch"
 	"testtask/internal/repository"
+	"testtask/internal/validation"
 	logger "testtask/pkg"
 )
 
 var appRepo *repository.SubscriptionRepository
 
+// defaultCurrency is used for subscriptions and totals without a currency.
+var defaultCurrency = "RUB"
+
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+const (
+	problemContentType    = "application/problem+json"
+	problemTypeDefault    = "about:blank"
+	problemTypeValidation = "/problems/validation-error"
+)
+
+func writeProblem(w http.ResponseWriter, problem models.ErrorResponse) {
+	if problem.Type == "" {
+		problem.Type = problemTypeDefault
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	problem.Error = problem.Detail
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
 func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, map[string]string{"error": msg})
+	writeProblem(w, models.ErrorResponse{Status: status, Detail: msg})
+}
+
+// writeRequestError reports a rejected request, listing the offending
+// fields when err comes from the validation layer.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		writeProblem(w, models.ErrorResponse{
+			Type:   problemTypeValidation,
+			Title:  "Request validation failed",
+			Status: http.StatusBadRequest,
+			Detail: "one or more fields are invalid",
+			Errors: fieldErrs,
+		})
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// jsonDecodeError explains a request body decoding error to the client,
+// naming the field when the body is valid JSON of the wrong shape.
+func jsonDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return validation.Errors{{Field: typeErr.Field, Message: "must be of type " + typeErr.Type.String()}}
+	}
+	var dateErr *models.DateParseError
+	if errors.As(err, &dateErr) {
+		return dateErr
+	}
+	// encoding/json has no error type for DisallowUnknownFields.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return validation.Errors{{Field: strings.Trim(field, `"`), Message: "is not a known field"}}
+	}
+	return errors.New("invalid json")
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+func parseListParams(q url.Values) (models.ListParams, error) {
+	filter, err := parseSubscriptionFilter(q)
+	if err != nil {
+		return models.ListParams{}, err
+	}
+	params := models.ListParams{
+		Filter: filter,
+		SortBy: "id",
+		Order:  models.SortAsc,
+		Limit:  defaultPageLimit,
+	}
+	if v := q.Get("sort_by"); v != "" {
+		if !models.IsSortColumn(v) {
+			return params, fmt.Errorf("invalid sort_by")
+		}
+		params.SortBy = v
+	}
+	if v := q.Get("order"); v != "" {
+		if v != models.SortAsc && v != models.SortDesc {
+			return params, fmt.Errorf("invalid order")
+		}
+		params.Order = v
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return params, fmt.Errorf("invalid limit")
+		}
+		params.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return params, fmt.Errorf("invalid offset")
+		}
+		params.Offset = offset
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := models.DecodeCursor(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid cursor")
+		}
+		// A cursor is only meaningful for the ordering it was issued for.
+		params.SortBy = cursor.SortBy
+		params.Order = cursor.Order
+		params.Offset = 0
+		params.Cursor = cursor
+	}
+	return params, nil
+}
+
+// parseMonthParam returns nil when the parameter is absent.
+func parseMonthParam(q url.Values, name string) (*models.MonthDate, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	month, err := models.ParseMonthDate(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &month, nil
+}
+
+// parseAsOf reads the as_of parameter, an RFC 3339 timestamp or a date
+// that stands for midnight UTC.
+func parseAsOf(q url.Values) (*time.Time, error) {
+	v := q.Get("as_of")
+	if v == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid as_of: expected an RFC 3339 timestamp or YYYY-MM-DD")
+}
+
+func parseSubscriptionFilter(q url.Values) (models.SubscriptionFilter, error) {
+	var (
+		filter models.SubscriptionFilter
+		err    error
+	)
+	if v := q.Get("user_id"); v != "" {
+		if _, err := uuid.Parse(v); err != nil {
+			return filter, fmt.Errorf("invalid user_id")
+		}
+		filter.UserID = &v
+	}
+	if v := q.Get("service_name"); v != "" {
+		filter.ServiceName = &v
+	}
+	if v := q.Get("category"); v != "" {
+		filter.Category = &v
+	}
+	if v := q.Get("plan_id"); v != "" {
+		planID, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid plan_id")
+		}
+		filter.PlanID = &planID
+	}
+	if v := q.Get("min_price"); v != "" {
+		price, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_price")
+		}
+		filter.MinPrice = &price
+	}
+	if v := q.Get("max_price"); v != "" {
+		price, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid max_price")
+		}
+		filter.MaxPrice = &price
+	}
+	if filter.ActiveFrom, err = parseMonthParam(q, "active_from"); err != nil {
+		return filter, err
+	}
+	if filter.ActiveTo, err = parseMonthParam(q, "active_to"); err != nil {
+		return filter, err
+	}
+	if filter.AsOf, err = parseAsOf(q); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTotalFilter(q url.Values) (models.TotalFilter, error) {
+	var (
+		filter models.TotalFilter
+		err    error
+	)
+	if v := q.Get("user_id"); v != "" {
+		if _, err := uuid.Parse(v); err != nil {
+			return filter, fmt.Errorf("invalid user_id")
+		}
+		filter.UserID = &v
+	}
+	if v := q.Get("service_name"); v != "" {
+		filter.ServiceName = &v
+	}
+	if v := q.Get("category"); v != "" {
+		filter.Category = &v
+	}
+	if v := q.Get("plan_id"); v != "" {
+		planID, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid plan_id")
+		}
+		filter.PlanID = &planID
+	}
+	if filter.StartDate, err = parseMonthParam(q, "start_date"); err != nil {
+		return filter, err
+	}
+	if filter.EndDate, err = parseMonthParam(q, "end_date"); err != nil {
+		return filter, err
+	}
+	if filter.StartDate != nil && filter.EndDate != nil && filter.EndDate.Before(filter.StartDate.Time) {
+		return filter, fmt.Errorf("end_date is before start_date")
+	}
+	if filter.AsOf, err = parseAsOf(q); err != nil {
+		return filter, err
+	}
+	err = parseCostParams(q, &filter)
+	return filter, err
+}
+
+// parseCostParams reads how costs are allocated and converted.
+func parseCostParams(q url.Values, filter *models.TotalFilter) error {
+	switch v := q.Get("allocation"); v {
+	case "":
+		filter.Allocation = models.AllocationRenewal
+	case models.AllocationRenewal, models.AllocationAmortized:
+		filter.Allocation = v
+	default:
+		return fmt.Errorf("invalid allocation")
+	}
+	filter.Currency = defaultCurrency
+	if v := q.Get("currency"); v != "" {
+		filter.Currency = strings.ToUpper(v)
+		if !validation.IsCurrencyCode(filter.Currency) {
+			return fmt.Errorf("invalid currency")
+		}
+	}
+	return nil
+}
+
+// parseGroupBy accepts both repeated and comma separated group_by values.
+func parseGroupBy(values []string) ([]string, error) {
+	groupBy := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, g := range strings.Split(v, ",") {
+			g = strings.TrimSpace(g)
+			switch g {
+			case "":
+				continue
+			case models.GroupByService, models.GroupByPlan, models.GroupByUser, models.GroupByMonth:
+			default:
+				return nil, fmt.Errorf("invalid group_by %q", g)
+			}
+			if !seen[g] {
+				seen[g] = true
+				groupBy = append(groupBy, g)
+			}
+		}
+	}
+	if len(groupBy) == 0 {
+		return nil, fmt.Errorf("missing group_by")
+	}
+	return groupBy, nil
+}
+
+// newSubscriptionFromRequest validates a create request and converts it
+// into a subscription ready to be inserted.
+func newSubscriptionFromRequest(req models.CreateSubscriptionRequest) (*models.Subscription, error) {
+	if err := validation.Struct(req); err != nil {
+		return nil, err
+	}
+	if req.ServiceName == "" && req.PlanID == nil {
+		return nil, validation.Errors{{Field: "service_name", Message: "is required"}}
+	}
+	sub := &models.Subscription{
+		ServiceName:   req.ServiceName,
+		PlanID:        req.PlanID,
+		Price:         req.Price,
+		UserID:        uuid.MustParse(req.UserID),
+		StartDate:     req.StartDate,
+		EndDate:       req.EndDate,
+		BillingPeriod: req.BillingPeriod,
+		IntervalCount: req.IntervalCount,
+		Currency:      req.Currency,
+		TrialEnd:      req.TrialEnd,
+		Discounts:     req.Discounts,
+	}
+	// A price sent with a plan overrides its list price.
+	sub.PriceOverridden = req.Price != 0
+	if err := checkPromotions(sub); err != nil {
+		return nil, err
+	}
+	if sub.Currency == "" {
+		sub.Currency = defaultCurrency
+	}
+	if sub.BillingPeriod == "" {
+		sub.BillingPeriod = models.BillingMonth
+	}
+	if sub.IntervalCount == 0 {
+		sub.IntervalCount = 1
+	}
+	return sub, nil
+}
+
+// checkPromotions validates the trial and discounts of sub, which the
+// binding tags of Subscription do not reach.
+func checkPromotions(sub *models.Subscription) error {
+	var errs validation.Errors
+	if sub.TrialEnd != nil && sub.TrialEnd.Before(sub.StartDate.Time) {
+		errs = append(errs, models.FieldError{Field: "trial_end", Message: "must not be before start_date"})
+	}
+	for i, d := range sub.Discounts {
+		field := fmt.Sprintf("discounts[%d]", i)
+		var derrs validation.Errors
+		if err := validation.Struct(d); err != nil && !errors.As(err, &derrs) {
+			return err
+		}
+		for _, fe := range derrs {
+			errs = append(errs, models.FieldError{Field: field + "." + fe.Field, Message: fe.Message})
+		}
+		switch {
+		case d.Value <= 0:
+			errs = append(errs, models.FieldError{Field: field + ".value", Message: "must be positive"})
+		case d.Kind == models.DiscountPercent && d.Value > 100:
+			errs = append(errs, models.FieldError{Field: field + ".value", Message: "must be at most 100"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 // CreateSubscriptionHandler godoc
@@ -31,72 +383,63 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 // @Tags subscriptions
 // @Accept json
 // @Produce json
+// @Description A service_name matching a catalog service or one of its aliases links the subscription to the service and is stored as its canonical name; price then defaults to the service default price. Send an Idempotency-Key header to make retries safe: a repeated request with the same key and body replays the original response and its headers. While the first request is still being processed a retry gets 409; a request that does not answer within the configured lease is processed again on the next retry.
 // @Param subscription body models.CreateSubscriptionRequest true "Create Subscription"
+// @Param Idempotency-Key header string false "Client generated key, at most 255 characters"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
 // @Success 201 {object} models.Subscription
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription [post]
 func CreateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateSubscriptionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Log.WithError(err).Warn("invalid json body")
-		writeError(w, http.StatusBadRequest, "invalid json")
-		return
-	}
-	if req.ServiceName == "" || req.Price <= 0 || req.UserID == "" || req.StartDate == "" {
-		writeError(w, http.StatusBadRequest, "missing required fields")
+		writeRequestError(w, jsonDecodeError(err))
 		return
 	}
-	userID, err := uuid.Parse(req.UserID)
+	sub, err := newSubscriptionFromRequest(req)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid user_id")
+		writeRequestError(w, err)
 		return
 	}
-	start, err := time.Parse("01-2006", req.StartDate)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid start_date")
+	idem, ok := beginIdempotent(w, r, req)
+	if !ok {
 		return
 	}
-	var endPtr *time.Time
-	if req.EndDate != "" {
-		end, err := time.Parse(time.RFC3339, req.EndDate)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid end_date")
-			return
-		}
-		endPtr = &end
-	}
-	sub := &models.Subscription{
-		ServiceName: req.ServiceName,
-		Price:       req.Price,
-		UserID:      userID,
-		StartDate:   start,
-		EndDate:     endPtr,
-	}
-	id, err := appRepo.InsertSubscription(sub)
+	id, err := appRepo.InsertSubscription(sub, actorFromRequest(r))
 	if err != nil {
-		logger.Log.WithError(err).Error("failed to create subscription")
-		writeError(w, http.StatusInternalServerError, "failed to create subscription")
+		idem.release()
+		writeRepoError(w, err, "failed to create subscription")
 		return
 	}
 	logger.Log.Infof("Subscription created successfully with id: %d", id)
+	evaluateSubscriptionBudgets(sub)
 	created, err := appRepo.GetSubscriptionByID(id)
 	if err != nil {
 		sub.ID = id
-		writeJSON(w, http.StatusCreated, sub)
-		return
+		created = sub
 	}
+	w.Header().Set("ETag", created.ETag())
+	idem.complete(w.Header(), http.StatusCreated, created)
 	writeJSON(w, http.StatusCreated, created)
 }
 
 // GetSubscriptionByIdHandler godoc
 // @Summary Get subscription by id
+// @Description price is the base price; current_price is the price charged this month and price_schedule lists the scheduled changes.
 // @Tags subscriptions
 // @Produce json
 // @Param id path int true "Subscription ID"
+// @Param as_of query string false "Return the subscription as it was at this moment (RFC 3339 or YYYY-MM-DD)"
 // @Success 200 {object} models.Subscription
+// @Header 200 {string} ETag "Version of the subscription, not sent with as_of"
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription/{id} [get]
 func GetSubscriptionByIdHandler(w http.ResponseWriter, r *http.Request) {
 	vars := gorilla_mux.Vars(r)
@@ -110,26 +453,102 @@ func GetSubscriptionByIdHandler(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
+	asOf, err := parseAsOf(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if asOf != nil {
+		sub, err := appRepo.GetSubscriptionAsOf(id, *asOf)
+		if err != nil {
+			writeRepoError(w, err, "failed to get subscription")
+			return
+		}
+		// No ETag: a past version must not be used as a precondition.
+		writeJSON(w, http.StatusOK, sub)
+		return
+	}
 	sub, err := appRepo.GetSubscriptionByID(id)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "not found")
+		writeRepoError(w, err, "failed to get subscription")
 		return
 	}
 	logger.Log.Infof("Subscription get with id: %d", sub.ID)
+	w.Header().Set("ETag", sub.ETag())
 	writeJSON(w, http.StatusOK, sub)
 }
 
+// ReplaceSubscriptionHandler godoc
+// @Summary Replace subscription by id
+// @Description Replaces every writable field; omitted optional fields such as end_date are cleared.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param subscription body models.CreateSubscriptionRequest true "New Subscription"
+// @Param If-Match header string false "ETag from a previous GET"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.Subscription
+// @Header 200 {string} ETag "New version of the subscription"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 412 {object} models.ErrorResponse
+// @Failure 428 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/{id} [put]
+func ReplaceSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req models.CreateSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	sub, err := newSubscriptionFromRequest(req)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	existing, err := appRepo.GetSubscriptionByID(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	if !checkIfMatch(w, r, existing) {
+		return
+	}
+	sub.ID = existing.ID
+	sub.Version = existing.Version
+	saveSubscription(w, r, existing, sub)
+}
+
 // UpdateSubscriptionHandler godoc
 // @Summary Update subscription by id
+// @Description With application/json only the fields present are changed and none can be cleared. application/merge-patch+json (RFC 7396) clears fields set to null, application/json-patch+json (RFC 6902) applies a list of operations. Both patch formats apply to the subscription as returned by GET. On a plan, price set to null or price_overridden set to false goes back to the list price of the plan.
 // @Tags subscriptions
 // @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
 // @Produce json
 // @Param id path int true "Subscription ID"
 // @Param subscription body models.UpdateSubscriptionRequest true "Update Subscription"
+// @Param If-Match header string false "ETag from a previous GET"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
 // @Success 200 {object} models.Subscription
+// @Header 200 {string} ETag "New version of the subscription"
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 412 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 428 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription/{id} [patch]
 func UpdateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := gorilla_mux.Vars(r)
@@ -143,57 +562,95 @@ func UpdateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case patch.MergePatchContentType, patch.JSONPatchContentType:
+		patchSubscription(w, r, id, mediaType)
+		return
+	case "", "application/json":
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
 	var req models.UpdateSubscriptionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid json")
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		writeRequestError(w, err)
 		return
 	}
 	existing, err := appRepo.GetSubscriptionByID(id)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "not found")
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	if !checkIfMatch(w, r, existing) {
 		return
 	}
+	before := *existing
 	if req.ServiceName != "" {
 		existing.ServiceName = req.ServiceName
 	}
+	if req.PlanID != nil {
+		existing.PlanID = req.PlanID
+	}
 	if req.Price != nil {
 		existing.Price = *req.Price
+		existing.PriceOverridden = true
 	}
 	if req.UserID != "" {
-		userID, err := uuid.Parse(req.UserID)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid user_id")
-			return
-		}
-		existing.UserID = userID
+		existing.UserID = uuid.MustParse(req.UserID)
 	}
-	if req.StartDate != "" {
-		t, err := time.Parse("01-2006", req.StartDate)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid start_date")
-			return
-		}
-		existing.StartDate = t
+	if req.StartDate != nil {
+		existing.StartDate = *req.StartDate
 	}
-	if req.EndDate != "" {
-		t, err := time.Parse("01-2006", req.EndDate)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid end_date")
-			return
-		}
-		existing.EndDate = &t
+	if req.EndDate != nil {
+		existing.EndDate = req.EndDate
+	}
+	if req.Currency != "" {
+		existing.Currency = req.Currency
+	}
+	if req.BillingPeriod != "" {
+		existing.BillingPeriod = req.BillingPeriod
 	}
-	if err := appRepo.UpdateSubscription(existing); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to update")
+	if req.IntervalCount != nil {
+		existing.IntervalCount = *req.IntervalCount
+	}
+	if req.TrialEnd != nil {
+		existing.TrialEnd = req.TrialEnd
+	}
+	if req.Discounts != nil {
+		existing.Discounts = *req.Discounts
+	}
+	saveSubscription(w, r, &before, existing)
+}
+
+// saveSubscription validates and stores sub, a changed copy of the live
+// subscription before, and responds with the stored version.
+func saveSubscription(w http.ResponseWriter, r *http.Request, before, sub *models.Subscription) {
+	if err := validation.Struct(sub); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := checkPromotions(sub); err != nil {
+		writeRequestError(w, err)
 		return
 	}
-	logger.Log.Infof("Subscription update with id: %d", id)
+	if err := appRepo.UpdateSubscription(sub, actorFromRequest(r)); err != nil {
+		writeRepoError(w, err, "failed to update")
+		return
+	}
+	logger.Log.Infof("Subscription update with id: %d", sub.ID)
+	evaluateSubscriptionBudgets(before, sub)
 
-	updated, err := appRepo.GetSubscriptionByID(existing.ID)
+	updated, err := appRepo.GetSubscriptionByID(sub.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to load updated object")
+		writeRepoError(w, err, "failed to load updated object")
 		return
 	}
+	w.Header().Set("ETag", updated.ETag())
 	writeJSON(w, http.StatusOK, updated)
 }
 
@@ -202,9 +659,14 @@ func UpdateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags subscriptions
 // @Produce json
 // @Param id path int true "Subscription ID"
+// @Param If-Match header string false "ETag from a previous GET"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 412 {object} models.ErrorResponse
+// @Failure 428 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription/{id} [delete]
 func DeleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := gorilla_mux.Vars(r)
@@ -218,68 +680,221 @@ func DeleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
-	if err := appRepo.DeleteSubscription(id); err != nil {
-		writeError(w, http.StatusNotFound, "not found")
+	// The subscription is loaded anyway to check the budgets it was
+	// charged to once it is gone.
+	existing, err := appRepo.GetSubscriptionByID(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	if !checkIfMatch(w, r, existing) {
+		return
+	}
+	var version *int
+	if r.Header.Get("If-Match") != "" || requireIfMatch {
+		version = &existing.Version
+	}
+	if err := appRepo.DeleteSubscription(id, version, actorFromRequest(r)); err != nil {
+		writeRepoError(w, err, "failed to delete subscription")
 		return
 	}
 	logger.Log.Infof("Subscription deleted with id: %d", id)
-	w.WriteHeader(http.StatusOK)
+	evaluateSubscriptionBudgets(existing)
 	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
 // GetAllSubscriptionHandler godoc
 // @Summary List subscriptions
+// @Description Supports limit/offset and keyset pagination. Pass next_cursor from the previous page as cursor to continue a keyset scan.
 // @Tags subscriptions
 // @Produce json
-// @Success 200 {array} models.Subscription
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param min_price query int false "Minimal price"
+// @Param max_price query int false "Maximal price"
+// @Param active_from query string false "Active on or after month (MM-YYYY)"
+// @Param active_to query string false "Active on or before month (MM-YYYY)"
+// @Param sort_by query string false "Sort column" Enums(id, service_name, price, user_id, start_date, end_date)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param limit query int false "Page size (default 50, max 1000)"
+// @Param offset query int false "Rows to skip, ignored with cursor"
+// @Param cursor query string false "Keyset cursor from next_cursor"
+// @Param as_of query string false "Read the data as it was at this moment (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {object} models.SubscriptionListResponse
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription [get]
 func GetAllSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
-	subs, err := appRepo.GetAllSubscription()
+	params, err := parseListParams(r.URL.Query())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to list")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	list, err := appRepo.GetAllSubscription(params)
+	if err != nil {
+		writeRepoError(w, err, "failed to list")
 		return
 	}
 	logger.Log.Info("Get All Subscription")
-	writeJSON(w, http.StatusOK, subs)
+	resp := models.SubscriptionListResponse{
+		Items:  list.Items,
+		Total:  list.Total,
+		Limit:  params.Limit,
+		Offset: params.Offset,
+	}
+	if list.Next != nil {
+		resp.NextCursor = list.Next.Encode()
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // GetSubscriptionsTotalHandler godoc
-// @Summary Sum total price of subscriptions
+// @Summary Sum total cost of subscriptions
+// @Description Every subscription is charged for each month it is active inside the period. Subscriptions overlapping the period edges are clipped; open-ended ones count up to end_date, which defaults to the current month. Subscriptions not billed monthly are charged in full in each month they renew (allocation=renewal, the default) or spread evenly over the months they cover (allocation=amortized). Months that end within a trial are free and billing cycles start on the first paid day; the discounts running when a subscription renews are taken off that charge before it is spread. With user_id only the share of that user in the subscriptions they own or are a member of is charged, see /subscription/{id}/members. Each charge is converted into currency at the latest rate published on or before the first day of the month it falls in; the rates used are listed in the response.
 // @Tags subscriptions
 // @Produce json
-// @Param start_date query string false "Start date (YYYY-MM-DD)"
-// @Param end_date query string false "End date (YYYY-MM-DD)"
+// @Param start_date query string false "First month of the period (MM-YYYY)"
+// @Param end_date query string false "Last month of the period (MM-YYYY)"
 // @Param user_id query string false "User ID (UUID)"
-// @Param service_name query string false "Service name"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param allocation query string false "How to charge non-monthly billing periods" Enums(renewal, amortized)
+// @Param currency query string false "Currency to convert the total into, defaults to the configured currency"
+// @Param as_of query string false "Read the data as it was at this moment (RFC 3339 or YYYY-MM-DD)"
 // @Success 200 {object} models.TotalResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /subscription/total [get]
 func GetSubscriptionsTotalHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTotalFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := appRepo.SumTotalSubscriptions(filter)
+	if err != nil {
+		writeRepoError(w, err, "failed to calculate total")
+		return
+	}
+	writeJSON(w, http.StatusOK, total)
+}
+
+// GetSubscriptionsBreakdownHandler godoc
+// @Summary Spend breakdown of subscriptions
+// @Description Splits the /subscription/total cost into groups. group_by takes a comma separated list, e.g. service_name,month. Grouped by user_id, shared subscriptions count for the owner and every member with their share.
+// @Tags subscriptions
+// @Produce json
+// @Param group_by query string true "Grouping: service_name, plan_id, user_id, month"
+// @Param start_date query string false "First month of the period (MM-YYYY)"
+// @Param end_date query string false "Last month of the period (MM-YYYY)"
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param allocation query string false "How to charge non-monthly billing periods" Enums(renewal, amortized)
+// @Param currency query string false "Currency to convert the total into, defaults to the configured currency"
+// @Param as_of query string false "Read the data as it was at this moment (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {object} models.BreakdownResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/total/breakdown [get]
+func GetSubscriptionsBreakdownHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
-	start := q.Get("start_date")
-	end := q.Get("end_date")
-	user := q.Get("user_id")
-	service := q.Get("service_name")
+	filter, err := parseTotalFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	groupBy, err := parseGroupBy(q["group_by"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	var startPtr, endPtr, userPtr, servicePtr *string
-	if start != "" {
-		startPtr = &start
+	breakdown, err := appRepo.SpendBreakdown(filter, groupBy)
+	if err != nil {
+		writeRepoError(w, err, "failed to calculate breakdown")
+		return
 	}
-	if end != "" {
-		endPtr = &end
+	writeJSON(w, http.StatusOK, breakdown)
+}
+
+// GetTrashHandler godoc
+// @Summary List deleted subscriptions
+// @Description Lists soft-deleted subscriptions with the same filters and paging as GET /subscription.
+// @Tags subscriptions
+// @Produce json
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param sort_by query string false "Sort column" Enums(id, service_name, price, user_id, start_date, end_date)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param limit query int false "Page size (default 50, max 1000)"
+// @Param offset query int false "Rows to skip, ignored with cursor"
+// @Param cursor query string false "Keyset cursor from next_cursor"
+// @Success 200 {object} models.SubscriptionListResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/trash [get]
+func GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parseListParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if user != "" {
-		userPtr = &user
+	params.Filter.Deleted = true
+	list, err := appRepo.GetAllSubscription(params)
+	if err != nil {
+		writeRepoError(w, err, "failed to list trash")
+		return
+	}
+	resp := models.SubscriptionListResponse{
+		Items:  list.Items,
+		Total:  list.Total,
+		Limit:  params.Limit,
+		Offset: params.Offset,
 	}
-	if service != "" {
-		servicePtr = &service
+	if list.Next != nil {
+		resp.NextCursor = list.Next.Encode()
 	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	total, err := appRepo.SumTotalSubscriptions(startPtr, endPtr, userPtr, servicePtr)
+// RestoreSubscriptionHandler godoc
+// @Summary Restore deleted subscription
+// @Tags subscriptions
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.Subscription
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/{id}/restore [post]
+func RestoreSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["id"])
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to calculate total")
+		writeError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
-	writeJSON(w, http.StatusOK, models.TotalResponse{Total: total})
+	sub, err := appRepo.RestoreSubscription(id, actorFromRequest(r))
+	if err != nil {
+		writeRepoError(w, err, "failed to restore subscription")
+		return
+	}
+	logger.Log.Infof("Subscription restored with id: %d", id)
+	evaluateSubscriptionBudgets(sub)
+	w.Header().Set("ETag", sub.ETag())
+	writeJSON(w, http.StatusOK, sub)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	gorilla_mux "github.com/gorilla/mux"
+)
+
+// actorHeader names the caller on whose behalf a change is made. It is
+// recorded in the subscription history as is; authentication is left to
+// the gateway in front of the service.
+const actorHeader = "X-Actor"
+
+const anonymousActor = "anonymous"
+
+const maxActorLength = 255
+
+func actorFromRequest(r *http.Request) string {
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		return anonymousActor
+	}
+	if len(actor) > maxActorLength {
+		actor = actor[:maxActorLength]
+	}
+	return actor
+}
+
+// GetSubscriptionHistoryHandler godoc
+// @Summary Subscription change history
+// @Description Lists every change of a subscription, oldest first, with the full row before and after the change. Deleted and purged subscriptions keep their history.
+// @Tags subscriptions
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Success 200 {array} models.HistoryEntry
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/{id}/history [get]
+func GetSubscriptionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	entries, err := appRepo.GetSubscriptionHistory(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription history")
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"testtask/internal/models"
+	logger "testtask/pkg"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyTTL is how long a key and its response are remembered.
+var idempotencyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a key stays claimed by a request that has
+// not answered yet, for example because the server crashed. A retry after
+// that is processed again.
+var idempotencyLease = time.Minute
+
+// idempotentRequest tracks a request carrying an Idempotency-Key header.
+type idempotentRequest struct {
+	key  string
+	hash string
+}
+
+func requestHash(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// beginIdempotent reserves the Idempotency-Key of r for a request whose
+// decoded body is body. It returns nil, true when the header is absent.
+// When the key was used before it replays or rejects the request itself
+// and returns false.
+func beginIdempotent(w http.ResponseWriter, r *http.Request, body interface{}) (*idempotentRequest, bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return nil, false
+	}
+	req := &idempotentRequest{key: key, hash: requestHash(body)}
+	stored, reserved, err := appRepo.ReserveIdempotencyKey(&models.IdempotencyRecord{Key: key, RequestHash: req.hash}, idempotencyLease, idempotencyTTL)
+	if err != nil {
+		writeRepoError(w, err, "failed to check Idempotency-Key")
+		return nil, false
+	}
+	if reserved {
+		return req, true
+	}
+
+	switch {
+	case stored.RequestHash != req.hash:
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body")
+	case !stored.Completed():
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+	default:
+		logger.Log.WithField("idempotency_key", key).Info("Replaying idempotent response")
+		for name, values := range stored.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.StatusCode)
+		_, _ = w.Write(stored.Response)
+	}
+	return nil, false
+}
+
+// complete stores the response, sent with header, so that retries replay
+// it.
+func (req *idempotentRequest) complete(header http.Header, status int, body interface{}) {
+	if req == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		logger.Log.WithError(err).Error("failed to encode idempotent response")
+		return
+	}
+	rec := &models.IdempotencyRecord{Key: req.key, RequestHash: req.hash, StatusCode: status, Response: buf.Bytes(), Header: header.Clone()}
+	if err := appRepo.CompleteIdempotencyKey(rec, idempotencyTTL); err != nil {
+		logger.Log.WithError(err).WithField("idempotency_key", req.key).Error("failed to store idempotent response")
+	}
+}
+
+// release frees the key after a failed request so that it can be retried.
+func (req *idempotentRequest) release() {
+	if req == nil {
+		return
+	}
+	if err := appRepo.ReleaseIdempotencyKey(req.key); err != nil {
+		logger.Log.WithError(err).WithField("idempotency_key", req.key).Warn("failed to release Idempotency-Key")
+	}
+}
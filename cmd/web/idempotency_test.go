@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	useTestRepository(t)
+	req := models.CreateSubscriptionRequest{ServiceName: "Netflix", Price: 400, UserID: importUser}
+	req.StartDate, _ = models.ParseMonthDate("07-2025")
+	key := map[string]string{"Idempotency-Key": "create-1"}
+
+	first := serve(t, http.MethodPost, "/subscription", req, key)
+	expectStatus(t, first, http.StatusCreated)
+	var created models.Subscription
+	decode(t, first, &created)
+
+	replay := serve(t, http.MethodPost, "/subscription", req, key)
+	expectStatus(t, replay, http.StatusCreated)
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is not marked as replayed")
+	}
+	if got, want := replay.Header().Get("ETag"), first.Header().Get("ETag"); got == "" || got != want {
+		t.Errorf("replayed ETag = %q, want %q", got, want)
+	}
+	var replayed models.Subscription
+	decode(t, replay, &replayed)
+	if !reflect.DeepEqual(replayed, created) {
+		t.Errorf("replayed %+v, want %+v", replayed, created)
+	}
+
+	other := req
+	other.Price = 500
+	expectStatus(t, serve(t, http.MethodPost, "/subscription", other, key), http.StatusUnprocessableEntity)
+
+	var list models.SubscriptionListResponse
+	decode(t, serve(t, http.MethodGet, "/subscription", nil, nil), &list)
+	if len(list.Items) != 1 {
+		t.Errorf("got %d subscriptions, want 1", len(list.Items))
+	}
+}
+
+func TestIdempotentCreateTakesOverAfterLease(t *testing.T) {
+	useTestRepository(t)
+	defer func(old time.Duration) { idempotencyLease = old }(idempotencyLease)
+	idempotencyLease = 50 * time.Millisecond
+
+	req := models.CreateSubscriptionRequest{ServiceName: "Netflix", Price: 400, UserID: importUser}
+	req.StartDate, _ = models.ParseMonthDate("07-2025")
+	key := map[string]string{"Idempotency-Key": "create-1"}
+
+	// A request that claimed the key and never answered.
+	rec := &models.IdempotencyRecord{Key: "create-1", RequestHash: requestHash(req)}
+	if _, _, err := appRepo.ReserveIdempotencyKey(rec, idempotencyLease, idempotencyTTL); err != nil {
+		t.Fatalf("ReserveIdempotencyKey: %v", err)
+	}
+	w := serve(t, http.MethodPost, "/subscription", req, key)
+	expectStatus(t, w, http.StatusConflict)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("409 without Retry-After")
+	}
+
+	time.Sleep(2 * idempotencyLease)
+	expectStatus(t, serve(t, http.MethodPost, "/subscription", req, key), http.StatusCreated)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+	"testtask/internal/repository"
+	logger "testtask/pkg"
+)
+
+const (
+	importModeAtomic     = "atomic"
+	importModeBestEffort = "best_effort"
+
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+
+	maxImportRows = 10000
+)
+
+// importRow is one parsed input record. Sub is nil when the record failed
+// validation, in which case Err explains why.
+type importRow struct {
+	Line int
+	Sub  *models.Subscription
+	Err  error
+}
+
+// ImportSubscriptionsHandler godoc
+// @Summary Bulk import subscriptions
+// @Description Accepts CSV with a header row (service_name,user_id,start_date and optionally plan_id,price,end_date,currency,billing_period,interval_count,trial_end and discounts as a JSON array; price may be left out when the plan or the catalog provides it) or NDJSON with one create request per line. Files written by the CSV export are accepted as they are. Rows are validated like POST /subscription. In atomic mode nothing is inserted unless every row is valid; best_effort inserts every valid row on its own and stops with 503 when the database becomes unavailable.
+// @Tags subscriptions
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param format query string false "Input format, defaults to the Content-Type" Enums(csv, ndjson)
+// @Param mode query string false "Insert mode" Enums(atomic, best_effort)
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.ImportResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ImportResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/import [post]
+func ImportSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = importModeAtomic
+	}
+	if mode != importModeAtomic && mode != importModeBestEffort {
+		writeError(w, http.StatusBadRequest, "invalid mode")
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = importFormatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	var rows []importRow
+	var err error
+	switch format {
+	case importFormatCSV:
+		rows, err = readCSVImport(r.Body)
+	case importFormatNDJSON:
+		rows, err = readNDJSONImport(r.Body)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported import format")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := models.ImportResponse{Mode: mode, Rows: make([]models.ImportRowResult, len(rows))}
+	for i, row := range rows {
+		resp.Rows[i].Line = row.Line
+		if row.Err != nil {
+			resp.Rows[i].Error = row.Err.Error()
+		}
+	}
+
+	if mode == importModeAtomic {
+		status, err := importAtomic(rows, actorFromRequest(r), &resp)
+		if err != nil {
+			writeRepoError(w, err, "failed to import subscriptions")
+			return
+		}
+		resp.Count()
+		writeJSON(w, status, resp)
+		return
+	}
+
+	for i, row := range rows {
+		if row.Sub == nil {
+			continue
+		}
+		id, err := appRepo.InsertSubscription(row.Sub, actorFromRequest(r))
+		if errors.Is(err, repository.ErrUnavailable) {
+			// Rows inserted so far stay; the client has to retry the rest.
+			evaluateImportedBudgets(rows, &resp)
+			writeRepoError(w, err, "failed to import subscriptions")
+			return
+		}
+		if err != nil {
+			resp.Rows[i].Error = insertErrorMessage(err)
+			continue
+		}
+		resp.Rows[i].ID = id
+	}
+	resp.Count()
+	evaluateImportedBudgets(rows, &resp)
+	logger.Log.Infof("Imported %d subscriptions, %d failed", resp.Created, resp.Failed)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// importAtomic inserts every row in one transaction, or none of them when
+// a row is invalid or the insert fails.
+func importAtomic(rows []importRow, actor string, resp *models.ImportResponse) (int, error) {
+	subs := make([]*models.Subscription, 0, len(rows))
+	index := make([]int, 0, len(rows))
+	for i, row := range rows {
+		if row.Sub != nil {
+			subs = append(subs, row.Sub)
+			index = append(index, i)
+		}
+	}
+	if len(subs) != len(rows) {
+		markSkipped(resp)
+		return http.StatusUnprocessableEntity, nil
+	}
+
+	ids, err := appRepo.InsertSubscriptions(subs, actor)
+	var batchErr *repository.BatchError
+	if errors.As(err, &batchErr) && !errors.Is(err, repository.ErrUnavailable) {
+		resp.Rows[index[batchErr.Index]].Error = insertErrorMessage(batchErr.Err)
+		markSkipped(resp)
+		return http.StatusUnprocessableEntity, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		resp.Rows[index[i]].ID = id
+	}
+	evaluateImportedBudgets(rows, resp)
+	logger.Log.Infof("Imported %d subscriptions", len(ids))
+	return http.StatusOK, nil
+}
+
+// insertErrorMessage explains why the insert of a row failed. Rejected
+// data is reported as is, anything else stays generic.
+func insertErrorMessage(err error) string {
+	if errors.Is(err, repository.ErrValidation) || errors.Is(err, repository.ErrConflict) {
+		return err.Error()
+	}
+	return "failed to create subscription"
+}
+
+// evaluateImportedBudgets checks the budgets of every user that received
+// a subscription, once per user for the months of all their new ones.
+func evaluateImportedBudgets(rows []importRow, resp *models.ImportResponse) {
+	imported := map[uuid.UUID][]*models.Subscription{}
+	for i, row := range rows {
+		if resp.Rows[i].ID != 0 {
+			imported[row.Sub.UserID] = append(imported[row.Sub.UserID], row.Sub)
+		}
+	}
+	for _, subs := range imported {
+		evaluateSubscriptionBudgets(subs...)
+	}
+}
+
+func markSkipped(resp *models.ImportResponse) {
+	for i := range resp.Rows {
+		if resp.Rows[i].Error == "" {
+			resp.Rows[i].Error = "not imported: batch rejected"
+		}
+	}
+}
+
+func importFormatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return importFormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importFormatNDJSON
+	}
+	return ""
+}
+
+var requiredImportColumns = []string{"service_name", "user_id", "start_date"}
+
+func readCSVImport(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing %s", name)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		// Malformed rows count towards the limit like any other.
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("too many rows, at most %d are allowed", maxImportRows)
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("failed to read csv")
+			}
+			rows = append(rows, importRow{Line: parseErr.Line, Err: fmt.Errorf("malformed csv row")})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		req, err := csvCreateRequest(field)
+		if err != nil {
+			rows = append(rows, importRow{Line: line, Err: err})
+			continue
+		}
+		sub, err := newSubscriptionFromRequest(req)
+		rows = append(rows, importRow{Line: line, Sub: sub, Err: err})
+	}
+	return rows, nil
+}
+
+func csvCreateRequest(field func(name string) string) (models.CreateSubscriptionRequest, error) {
+	req := models.CreateSubscriptionRequest{
+		ServiceName: field("service_name"),
+		UserID:      field("user_id"),
+	}
+	var err error
+	if plan := field("plan_id"); plan != "" {
+		id, err := strconv.Atoi(plan)
+		if err != nil {
+			return req, fmt.Errorf("invalid plan_id")
+		}
+		req.PlanID = &id
+	}
+	// An export lists the plan price too; it is only kept when it was
+	// overridden, so the subscription keeps following the plan.
+	price := field("price")
+	if req.PlanID != nil && strings.EqualFold(field("price_overridden"), "false") {
+		price = ""
+	}
+	if price != "" {
+		if req.Price, err = strconv.Atoi(price); err != nil {
+			return req, fmt.Errorf("invalid price")
+		}
+	}
+	if start := field("start_date"); start != "" {
+		if req.StartDate, err = models.ParseMonthDate(start); err != nil {
+			return req, fmt.Errorf("invalid start_date: %w", err)
+		}
+	}
+	if end := field("end_date"); end != "" {
+		month, err := models.ParseMonthDate(end)
+		if err != nil {
+			return req, fmt.Errorf("invalid end_date: %w", err)
+		}
+		req.EndDate = &month
+	}
+	req.Currency = field("currency")
+	req.BillingPeriod = field("billing_period")
+	if count := field("interval_count"); count != "" {
+		if req.IntervalCount, err = strconv.Atoi(count); err != nil {
+			return req, fmt.Errorf("invalid interval_count")
+		}
+	}
+	if trialEnd := field("trial_end"); trialEnd != "" {
+		day, err := models.ParseDate(trialEnd)
+		if err != nil {
+			return req, fmt.Errorf("invalid trial_end: %w", err)
+		}
+		req.TrialEnd = &day
+	}
+	// Discounts are a JSON array in one cell, as the export writes them.
+	if discounts := field("discounts"); discounts != "" {
+		if err := json.Unmarshal([]byte(discounts), &req.Discounts); err != nil {
+			return req, fmt.Errorf("invalid discounts, expected a JSON array")
+		}
+	}
+	return req, nil
+}
+
+func readNDJSONImport(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("too many rows, at most %d are allowed", maxImportRows)
+		}
+		var req models.CreateSubscriptionRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			rows = append(rows, importRow{Line: line, Err: jsonDecodeError(err)})
+			continue
+		}
+		sub, err := newSubscriptionFromRequest(req)
+		rows = append(rows, importRow{Line: line, Sub: sub, Err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ndjson")
+	}
+	return rows, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const importUser = "60601fee-2bf1-4721-ae6f-7636e79a0cba"
+
+func TestReadCSVImport(t *testing.T) {
+	body := "service_name,user_id,start_date,price\n" +
+		"Netflix," + importUser + ",07-2025,400\n" +
+		"Spotify," + importUser + ",07-2025,abc\n" +
+		"Yandex \"Plus\"," + importUser + ",07-2025,300\n"
+
+	rows, err := readCSVImport(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("readCSVImport: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3: %+v", len(rows), rows)
+	}
+	if rows[0].Err != nil || rows[0].Line != 2 || rows[0].Sub.ServiceName != "Netflix" || rows[0].Sub.Price != 400 ||
+		rows[0].Sub.Currency != defaultCurrency {
+		t.Errorf("row 0 = %+v", rows[0])
+	}
+	if rows[1].Sub != nil || rows[1].Err == nil || rows[1].Err.Error() != "invalid price" || rows[1].Line != 3 {
+		t.Errorf("row 1 = %+v, want invalid price on line 3", rows[1])
+	}
+	if rows[2].Sub != nil || rows[2].Err == nil || rows[2].Err.Error() != "malformed csv row" || rows[2].Line != 4 {
+		t.Errorf("row 2 = %+v, want malformed row on line 4", rows[2])
+	}
+}
+
+func TestReadCSVImportHeader(t *testing.T) {
+	if _, err := readCSVImport(strings.NewReader("service_name,price\n")); err == nil ||
+		err.Error() != "csv header is missing user_id" {
+		t.Errorf("err = %v, want missing user_id", err)
+	}
+	rows, err := readCSVImport(strings.NewReader("\ufeffService_Name, USER_ID ,start_date\nNetflix," + importUser + ",07-2025\n"))
+	if err != nil || len(rows) != 1 || rows[0].Err != nil {
+		t.Errorf("BOM and case in the header: rows = %+v, err = %v", rows, err)
+	}
+}
+
+func TestImportRowLimit(t *testing.T) {
+	valid := "Netflix," + importUser + ",07-2025,400\n"
+	malformed := "Netflix," + importUser + ",07-2025,\"4\"00\n"
+	header := "service_name,user_id,start_date,price\n"
+
+	tests := []struct {
+		name    string
+		body    string
+		read    func(string) ([]importRow, error)
+		wantErr bool
+	}{
+		{"csv at the limit", header + strings.Repeat(valid, maxImportRows), readCSV, false},
+		{"csv over the limit", header + strings.Repeat(valid, maxImportRows+1), readCSV, true},
+		{"malformed csv rows count", header + strings.Repeat(valid, maxImportRows) + malformed, readCSV, true},
+		{"only malformed csv rows", header + strings.Repeat(malformed, maxImportRows+1), readCSV, true},
+		{"ndjson over the limit", strings.Repeat("{}\n", maxImportRows+1), readNDJSON, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := tt.read(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("read %d rows, want an error", len(rows))
+				}
+				return
+			}
+			if err != nil || len(rows) != maxImportRows {
+				t.Fatalf("got %d rows, err %v", len(rows), err)
+			}
+		})
+	}
+}
+
+func readCSV(body string) ([]importRow, error) {
+	return readCSVImport(strings.NewReader(body))
+}
+
+func readNDJSON(body string) ([]importRow, error) {
+	return readNDJSONImport(strings.NewReader(body))
+}
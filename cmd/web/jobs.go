@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"testtask/internal/config"
+	"testtask/internal/webhook"
+	logger "testtask/pkg"
+)
+
+// startPurgeJob periodically hard-deletes subscriptions that have been in
+// the trash for longer than the configured retention. A retention of zero
+// keeps deleted subscriptions forever.
+func startPurgeJob(cfg config.PurgeConfig) {
+	if cfg.RetentionDays <= 0 {
+		logger.Log.Info("Trash purge disabled")
+		return
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retention := time.Duration(cfg.RetentionDays) * 24 * time.Hour
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purged, err := appRepo.PurgeDeletedSubscriptions(retention)
+			if err != nil {
+				logger.Log.WithError(err).Error("failed to purge deleted subscriptions")
+			} else if purged > 0 {
+				logger.Log.Infof("Purged %d deleted subscriptions", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// startWebhookDispatcher sends the events queued in the outbox to the
+// registered webhooks.
+func startWebhookDispatcher(cfg config.WebhookConfig) {
+	if !cfg.Enabled {
+		logger.Log.Info("Webhook dispatcher disabled")
+		return
+	}
+	go webhook.NewDispatcher(appRepo, cfg, logger.Log).Run(context.Background())
+}
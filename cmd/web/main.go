@@ -2,10 +2,13 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	_ "testtask/docs"
 	"testtask/internal/cache"
 	"testtask/internal/config"
+	"testtask/internal/models"
+	"testtask/internal/rates"
 	"testtask/internal/repository"
 	logger "testtask/pkg"
 )
@@ -23,6 +26,18 @@ func main() {
 		logger.Log.Fatalf("Failed to load config: %v", err)
 	}
 
+	models.SetAcceptISODates(cfg.API.AcceptISODates)
+	requireIfMatch = cfg.API.RequireIfMatch
+	if cfg.API.IdempotencyTTL > 0 {
+		idempotencyTTL = cfg.API.IdempotencyTTL
+	}
+	if cfg.API.IdempotencyLease > 0 {
+		idempotencyLease = cfg.API.IdempotencyLease
+	}
+	if cfg.Currency.Default != "" {
+		defaultCurrency = strings.ToUpper(cfg.Currency.Default)
+	}
+
 	db, err := repository.Connect(cfg.Database)
 	logger.Log.Info("Connected to database")
 	if err != nil {
@@ -40,6 +55,16 @@ func main() {
 	}
 
 	appRepo = repository.NewSubscriptionRepository(db, logger.Log, redisClient)
+	if cfg.Currency.RatesFile != "" {
+		table, err := rates.Load(cfg.Currency.RatesFile, cfg.Currency.RatesBase)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load exchange rates: %v", err)
+		}
+		appRepo.SetExchangeRates(table)
+		logger.Log.Infof("Loaded exchange rates from %s", cfg.Currency.RatesFile)
+	}
+	startPurgeJob(cfg.Purge)
+	startWebhookDispatcher(cfg.Webhooks)
 
 	logger.Log.Infof("Starting web server at %s", cfg.Server.Port)
 	if err := http.ListenAndServe(cfg.Server.Port, routes()); err != nil {
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	gorilla_mux "github.com/gorilla/mux"
+
+	"testtask/internal/models"
+	"testtask/internal/validation"
+)
+
+const maxMembers = 100
+
+// membersFromRequest validates req and converts it into members.
+func membersFromRequest(req models.MembersRequest) ([]models.Member, error) {
+	var errs validation.Errors
+	if len(req.Members) > maxMembers {
+		errs = append(errs, models.FieldError{Field: "members", Message: fmt.Sprintf("must have at most %d items", maxMembers)})
+	}
+	members := make([]models.Member, 0, len(req.Members))
+	seen := map[string]bool{}
+	for i, m := range req.Members {
+		field := fmt.Sprintf("members[%d]", i)
+		var merrs validation.Errors
+		if err := validation.Struct(m); err != nil && !errors.As(err, &merrs) {
+			return nil, err
+		}
+		for _, fe := range merrs {
+			errs = append(errs, models.FieldError{Field: field + "." + fe.Field, Message: fe.Message})
+		}
+		if (m.Weight == 0) == (m.Amount == 0) {
+			errs = append(errs, models.FieldError{Field: field + ".weight", Message: "exactly one of weight and amount is required"})
+		}
+		if len(merrs) > 0 {
+			continue
+		}
+		userID := uuid.MustParse(m.UserID)
+		if seen[userID.String()] {
+			errs = append(errs, models.FieldError{Field: field + ".user_id", Message: "is listed twice"})
+			continue
+		}
+		seen[userID.String()] = true
+		members = append(members, models.Member{UserID: userID, Weight: m.Weight, Amount: m.Amount})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return members, nil
+}
+
+// GetMembersHandler godoc
+// @Summary List subscription members
+// @Description Lists the users sharing the cost of the subscription with its owner, the user_id of the subscription.
+// @Tags subscriptions
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Success 200 {array} models.Member
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/{id}/members [get]
+func GetMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	sub, err := appRepo.GetSubscriptionByID(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	members := sub.Members
+	if members == nil {
+		members = []models.Member{}
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// ReplaceMembersHandler godoc
+// @Summary Replace subscription members
+// @Description Shares the cost of the subscription. The owner, the user_id of the subscription, pays every charge; each member owes them either a fixed amount of every charge at the full price, scaled like the charge when it is amortized or discounted, or a part of what is left after fixed amounts in proportion to its weight. The owner keeps weight 1 for the rest unless listed as a member. Per-user totals, breakdowns, forecasts and budgets count only the share of the user. An empty list removes every member. The subscription gets a new version, returned in the ETag header, and a history entry.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param members body models.MembersRequest true "Members"
+// @Param If-Match header string false "ETag from a previous GET"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {array} models.Member
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 412 {object} models.ErrorResponse
+// @Failure 428 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/{id}/members [put]
+func ReplaceMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(gorilla_mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req models.MembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	members, err := membersFromRequest(req)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	before, err := appRepo.GetSubscriptionByID(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	if !checkIfMatch(w, r, before) {
+		return
+	}
+	var version *int
+	if r.Header.Get("If-Match") != "" || requireIfMatch {
+		version = &before.Version
+	}
+	updated, err := appRepo.ReplaceMembers(id, version, members, actorFromRequest(r))
+	if err != nil {
+		writeRepoError(w, err, "failed to replace members")
+		return
+	}
+	stored := updated.Members
+	if stored == nil {
+		stored = []models.Member{}
+	}
+
+	// Budgets of everyone whose share changed.
+	evaluateSubscriptionBudgets(before, updated)
+	w.Header().Set("ETag", updated.ETag())
+	writeJSON(w, http.StatusOK, stored)
+}
+
+// GetSettlementHandler godoc
+// @Summary Settle shared subscriptions
+// @Description Works out who owes whom for the subscriptions shared with members in the period: every member owes the owner their share of each charge, calculated like /subscription/total. What two users owe each other is netted into a single debt. With user_id only the debts that user owes or is owed are listed.
+// @Tags subscriptions
+// @Produce json
+// @Param start_date query string false "First month of the period (MM-YYYY)"
+// @Param end_date query string false "Last month of the period (MM-YYYY)"
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Param allocation query string false "How to charge non-monthly billing periods" Enums(renewal, amortized)
+// @Param currency query string false "Currency to convert the debts into, defaults to the configured currency"
+// @Param as_of query string false "Read the data as it was at this moment (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {object} models.SettlementResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/settlement [get]
+func GetSettlementHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTotalFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := appRepo.Settlement(filter)
+	if err != nil {
+		writeRepoError(w, err, "failed to settle subscriptions")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
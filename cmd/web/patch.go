@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"testtask/internal/models"
+	"testtask/internal/patch"
+	"testtask/internal/validation"
+)
+
+// patchSubscription applies a merge patch or JSON patch body to the
+// subscription as the API returns it and stores the result.
+func patchSubscription(w http.ResponseWriter, r *http.Request, id int, mediaType string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body")
+		return
+	}
+	existing, err := appRepo.GetSubscriptionByID(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get subscription")
+		return
+	}
+	if !checkIfMatch(w, r, existing) {
+		return
+	}
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode subscription")
+		return
+	}
+	var patched []byte
+	if mediaType == patch.MergePatchContentType {
+		patched, err = patch.MergePatch(doc, body)
+	} else {
+		patched, err = patch.JSONPatch(doc, body)
+	}
+	if err != nil {
+		writePatchError(w, err)
+		return
+	}
+
+	var sub models.Subscription
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sub); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	if err := checkReadOnlyFields(existing, &sub); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	// On a plan a removed price goes back to the list price and a changed
+	// one overrides it.
+	if sub.PlanID != nil {
+		switch sub.Price {
+		case 0:
+			sub.Price = existing.Price
+			sub.PriceOverridden = false
+		case existing.Price:
+		default:
+			sub.PriceOverridden = true
+		}
+	}
+	saveSubscription(w, r, existing, &sub)
+}
+
+// checkReadOnlyFields rejects patches that change fields managed by the
+// service.
+func checkReadOnlyFields(existing, patched *models.Subscription) error {
+	var errs validation.Errors
+	if patched.ID != existing.ID {
+		errs = append(errs, models.FieldError{Field: "id", Message: "is read-only"})
+	}
+	if patched.Version != existing.Version {
+		errs = append(errs, models.FieldError{Field: "version", Message: "is read-only"})
+	}
+	if !patched.UpdatedAt.Equal(existing.UpdatedAt) {
+		errs = append(errs, models.FieldError{Field: "updated_at", Message: "is read-only"})
+	}
+	if (patched.ServiceID == nil) != (existing.ServiceID == nil) ||
+		(patched.ServiceID != nil && *patched.ServiceID != *existing.ServiceID) {
+		errs = append(errs, models.FieldError{Field: "service_id", Message: "is read-only"})
+	}
+	if patched.DeletedAt != nil {
+		errs = append(errs, models.FieldError{Field: "deleted_at", Message: "is read-only"})
+	}
+	if !samePriceSchedule(patched.PriceSchedule, existing.PriceSchedule) {
+		errs = append(errs, models.FieldError{Field: "price_schedule", Message: "is read-only"})
+	}
+	if !sameMembers(patched.Members, existing.Members) {
+		errs = append(errs, models.FieldError{Field: "members", Message: "is read-only"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func sameMembers(a, b []models.Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func samePriceSchedule(a, b []models.PriceChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Price != b[i].Price || !a[i].EffectiveFrom.Equal(b[i].EffectiveFrom.Time) ||
+			(a[i].PlanID == nil) != (b[i].PlanID == nil) || (a[i].PlanID != nil && *a[i].PlanID != *b[i].PlanID) {
+			return false
+		}
+	}
+	return true
+}
+
+func writePatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, patch.ErrTestFailed):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, patch.ErrPath):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
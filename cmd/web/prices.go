@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	gorilla_mux "github.com/gorilla/mux"
+
+	"testtask/internal/models"
+	"testtask/internal/validation"
+)
+
+const maxScheduleIDs = 1000
+
+// checkPriceSchedule validates what validation.Struct cannot express.
+func checkPriceSchedule(req models.PriceScheduleRequest) error {
+	var errs validation.Errors
+	switch {
+	case req.Price == nil && req.ChangePercent == nil:
+		errs = append(errs, models.FieldError{Field: "price", Message: "price or change_percent is required"})
+	case req.Price != nil && req.ChangePercent != nil:
+		errs = append(errs, models.FieldError{Field: "change_percent", Message: "cannot be combined with price"})
+	case req.ChangePercent != nil && (*req.ChangePercent <= -100 || *req.ChangePercent > 1000):
+		errs = append(errs, models.FieldError{Field: "change_percent", Message: "must be greater than -100 and at most 1000"})
+	}
+	if req.EffectiveFrom.Before(models.MonthOf(time.Now()).Time) {
+		errs = append(errs, models.FieldError{Field: "effective_from", Message: "must not be before the current month"})
+	}
+	if len(req.SubscriptionIDs) == 0 && req.ServiceName == nil && req.PlanID == nil {
+		errs = append(errs, models.FieldError{Field: "subscription_ids", Message: "subscription_ids, service_name or plan_id is required"})
+	}
+	if len(req.SubscriptionIDs) > maxScheduleIDs {
+		errs = append(errs, models.FieldError{Field: "subscription_ids", Message: fmt.Sprintf("must have at most %d items", maxScheduleIDs)})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// SchedulePricesHandler godoc
+// @Summary Schedule a price change
+// @Description Sets a new price from effective_from on, without touching earlier months. The price is given directly or as change_percent of the price in effect at that month. With only plan_id the change is scheduled for the plan and applies to every subscription on it that does not override the plan price, including later ones; otherwise it is scheduled for each live subscription matching subscription_ids, service_name and plan_id. A change for the same month replaces the earlier one. Every subscription whose price_schedule changes gets a new version and a history entry.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param schedule body models.PriceScheduleRequest true "Price change"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.PriceScheduleResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/prices [post]
+func SchedulePricesHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.PriceScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	if err := validation.Struct(req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := checkPriceSchedule(req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	resp, err := appRepo.SchedulePrices(req, actorFromRequest(r))
+	if err != nil {
+		writeRepoError(w, err, "failed to schedule prices")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// DeleteScheduledPriceHandler godoc
+// @Summary Cancel a scheduled price
+// @Description Removes a price change by its id from price_schedule. Changes that took effect before the current month cannot be removed. The affected subscriptions get a new version and a history entry.
+// @Tags subscriptions
+// @Produce json
+// @Param id path int true "Price change ID"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/prices/{id} [delete]
+func DeleteScheduledPriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(gorilla_mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := appRepo.DeleteScheduledPrice(id, actorFromRequest(r)); err != nil {
+		writeRepoError(w, err, "failed to cancel scheduled price")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"testtask/internal/repository"
+	"testtask/internal/testdb"
+)
+
+// useTestRepository points the handlers at a fresh database for the rest
+// of the test, skipping it when there is none.
+func useTestRepository(t *testing.T) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	old := appRepo
+	appRepo = repository.NewSubscriptionRepository(testdb.Open(t), logger, nil)
+	t.Cleanup(func() {
+		budgetChecks.Wait()
+		appRepo = old
+	})
+}
+
+// serve sends a request with an optional JSON body and headers through the
+// routes of the API.
+func serve(t *testing.T, method, target string, body interface{}, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var data io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode body: %v", err)
+		}
+		data = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, target, data)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	routes().ServeHTTP(w, r)
+	return w
+}
+
+// decode reads the JSON body of w into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid response body %q: %v", w.Body, err)
+	}
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body)
+	}
+}
@@ -9,11 +9,47 @@ func routes() *gorilla_mux.Router {
 	mux := gorilla_mux.NewRouter()
 
 	mux.HandleFunc("/subscription", CreateSubscriptionHandler).Methods("POST")
+	mux.HandleFunc("/subscription/import", ImportSubscriptionsHandler).Methods("POST")
+	mux.HandleFunc("/subscription/export", ExportSubscriptionsHandler).Methods("GET")
 	mux.HandleFunc("/subscription/total", GetSubscriptionsTotalHandler).Methods("GET")
+	mux.HandleFunc("/subscription/total/breakdown", GetSubscriptionsBreakdownHandler).Methods("GET")
+	mux.HandleFunc("/subscription/forecast", GetForecastHandler).Methods("GET")
+	mux.HandleFunc("/subscription/upcoming", GetUpcomingHandler).Methods("GET")
+	mux.HandleFunc("/subscription/settlement", GetSettlementHandler).Methods("GET")
+	mux.HandleFunc("/subscription/trash", GetTrashHandler).Methods("GET")
+	mux.HandleFunc("/subscription/prices", SchedulePricesHandler).Methods("POST")
+	mux.HandleFunc("/subscription/prices/{id}", DeleteScheduledPriceHandler).Methods("DELETE")
 	mux.HandleFunc("/subscription/{id}", GetSubscriptionByIdHandler).Methods("GET")
+	mux.HandleFunc("/subscription/{id}", ReplaceSubscriptionHandler).Methods("PUT")
 	mux.HandleFunc("/subscription/{id}", UpdateSubscriptionHandler).Methods("PATCH")
 	mux.HandleFunc("/subscription/{id}", DeleteSubscriptionHandler).Methods("DELETE")
+	mux.HandleFunc("/subscription/{id}/history", GetSubscriptionHistoryHandler).Methods("GET")
+	mux.HandleFunc("/subscription/{id}/restore", RestoreSubscriptionHandler).Methods("POST")
+	mux.HandleFunc("/subscription/{id}/members", GetMembersHandler).Methods("GET")
+	mux.HandleFunc("/subscription/{id}/members", ReplaceMembersHandler).Methods("PUT")
 	mux.HandleFunc("/subscription", GetAllSubscriptionHandler).Methods("GET")
+	mux.HandleFunc("/webhooks", CreateWebhookHandler).Methods("POST")
+	mux.HandleFunc("/webhooks", ListWebhooksHandler).Methods("GET")
+	mux.HandleFunc("/webhooks/dead-letters", ListDeadLettersHandler).Methods("GET")
+	mux.HandleFunc("/webhooks/deliveries/{id}/redeliver", RedeliverHandler).Methods("POST")
+	mux.HandleFunc("/webhooks/{id}", GetWebhookHandler).Methods("GET")
+	mux.HandleFunc("/webhooks/{id}", UpdateWebhookHandler).Methods("PUT")
+	mux.HandleFunc("/webhooks/{id}", DeleteWebhookHandler).Methods("DELETE")
+	mux.HandleFunc("/services", CreateServiceHandler).Methods("POST")
+	mux.HandleFunc("/services", ListServicesHandler).Methods("GET")
+	mux.HandleFunc("/services/{id}", GetServiceHandler).Methods("GET")
+	mux.HandleFunc("/services/{id}", UpdateServiceHandler).Methods("PUT")
+	mux.HandleFunc("/services/{id}", DeleteServiceHandler).Methods("DELETE")
+	mux.HandleFunc("/services/{id}/plans", CreatePlanHandler).Methods("POST")
+	mux.HandleFunc("/services/{id}/plans", ListPlansHandler).Methods("GET")
+	mux.HandleFunc("/services/{id}/plans/{plan_id}", GetPlanHandler).Methods("GET")
+	mux.HandleFunc("/services/{id}/plans/{plan_id}", UpdatePlanHandler).Methods("PUT")
+	mux.HandleFunc("/services/{id}/plans/{plan_id}", DeletePlanHandler).Methods("DELETE")
+	mux.HandleFunc("/users/{id}/budgets", CreateBudgetHandler).Methods("POST")
+	mux.HandleFunc("/users/{id}/budgets", ListBudgetsHandler).Methods("GET")
+	mux.HandleFunc("/users/{id}/budgets/{budget_id}", UpdateBudgetHandler).Methods("PUT")
+	mux.HandleFunc("/users/{id}/budgets/{budget_id}", DeleteBudgetHandler).Methods("DELETE")
+	mux.HandleFunc("/users/{id}/budget/status", GetBudgetStatusHandler).Methods("GET")
 	mux.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 	return mux
 }
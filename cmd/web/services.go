@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	gorilla_mux "github.com/gorilla/mux"
+
+	"testtask/internal/models"
+	"testtask/internal/validation"
+	logger "testtask/pkg"
+)
+
+const maxServiceAliases = 50
+
+// serviceFromRequest validates req and applies it to s.
+func serviceFromRequest(req models.ServiceRequest, s *models.Service) error {
+	if err := validation.Struct(req); err != nil {
+		return err
+	}
+	if models.ServiceKey(req.Name) == "" {
+		return validation.Errors{{Field: "name", Message: "is required"}}
+	}
+	if len(req.Aliases) > maxServiceAliases {
+		return validation.Errors{{Field: "aliases", Message: fmt.Sprintf("must have at most %d items", maxServiceAliases)}}
+	}
+	seen := map[string]bool{models.ServiceKey(req.Name): true}
+	aliases := []string{}
+	for _, alias := range req.Aliases {
+		key := models.ServiceKey(alias)
+		if key == "" || utf8.RuneCountInString(alias) > 100 {
+			return validation.Errors{{Field: "aliases", Message: "must be non-empty and at most 100 characters"}}
+		}
+		// Aliases that only differ in case or spacing match anyway.
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		aliases = append(aliases, alias)
+	}
+	if req.Category != nil && *req.Category == "" {
+		req.Category = nil
+	}
+
+	s.Name = req.Name
+	s.Aliases = aliases
+	s.Category = req.Category
+	s.DefaultPrice = req.DefaultPrice
+	return nil
+}
+
+func serviceID(r *http.Request) (int, error) {
+	return strconv.Atoi(gorilla_mux.Vars(r)["id"])
+}
+
+// CreateServiceHandler godoc
+// @Summary Add service to the catalog
+// @Description Names and aliases are matched ignoring case and repeated spaces, and must be unique across the catalog. New and updated subscriptions with a matching service_name are linked to the service; run the backfill-services command to link existing ones.
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param service body models.ServiceRequest true "Service"
+// @Success 201 {object} models.Service
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services [post]
+func CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	service := &models.Service{}
+	if err := serviceFromRequest(req, service); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.CreateService(service); err != nil {
+		writeRepoError(w, err, "failed to create service")
+		return
+	}
+	logger.Log.Infof("Service created with id: %d", service.ID)
+	writeJSON(w, http.StatusCreated, service)
+}
+
+// ListServicesHandler godoc
+// @Summary List catalog services
+// @Tags services
+// @Produce json
+// @Param category query string false "Category"
+// @Success 200 {array} models.Service
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services [get]
+func ListServicesHandler(w http.ResponseWriter, r *http.Request) {
+	var category *string
+	if v := r.URL.Query().Get("category"); v != "" {
+		category = &v
+	}
+	services, err := appRepo.ListServices(category)
+	if err != nil {
+		writeRepoError(w, err, "failed to list services")
+		return
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+// GetServiceHandler godoc
+// @Summary Get catalog service by id
+// @Tags services
+// @Produce json
+// @Param id path int true "Service ID"
+// @Success 200 {object} models.Service
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id} [get]
+func GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	service, err := appRepo.GetService(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get service")
+		return
+	}
+	writeJSON(w, http.StatusOK, service)
+}
+
+// UpdateServiceHandler godoc
+// @Summary Replace catalog service by id
+// @Description Replaces the service and its aliases. Renaming the service renames the linked subscriptions. Every linked subscription whose name, category or aliases change gets a new version and a history entry, so that as_of reads filter by the catalog as it was.
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param service body models.ServiceRequest true "Service"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.Service
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id} [put]
+func UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req models.ServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	service := &models.Service{ID: id}
+	if err := serviceFromRequest(req, service); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.UpdateService(service, actorFromRequest(r)); err != nil {
+		writeRepoError(w, err, "failed to update service")
+		return
+	}
+	writeJSON(w, http.StatusOK, service)
+}
+
+// DeleteServiceHandler godoc
+// @Summary Delete catalog service by id
+// @Description Fails with 409 while subscriptions, including those in the trash, are linked to the service.
+// @Tags services
+// @Produce json
+// @Param id path int true "Service ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id} [delete]
+func DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := appRepo.DeleteService(id); err != nil {
+		writeRepoError(w, err, "failed to delete service")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// planFromRequest validates req and applies it to p.
+func planFromRequest(req models.PlanRequest, p *models.Plan) error {
+	if err := validation.Struct(req); err != nil {
+		return err
+	}
+	p.Name = req.Name
+	p.Price = req.Price
+	p.Currency = req.Currency
+	if p.Currency == "" {
+		p.Currency = defaultCurrency
+	}
+	p.BillingPeriod = req.BillingPeriod
+	if p.BillingPeriod == "" {
+		p.BillingPeriod = models.BillingMonth
+	}
+	p.IntervalCount = req.IntervalCount
+	if p.IntervalCount == 0 {
+		p.IntervalCount = 1
+	}
+	return nil
+}
+
+func planID(r *http.Request) (int, error) {
+	return strconv.Atoi(gorilla_mux.Vars(r)["plan_id"])
+}
+
+// CreatePlanHandler godoc
+// @Summary Add plan to a service
+// @Description Subscriptions created with plan_id take the service, currency and billing period of the plan, and its price unless they send one.
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param plan body models.PlanRequest true "Plan"
+// @Success 201 {object} models.Plan
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id}/plans [post]
+func CreatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req models.PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	plan := &models.Plan{ServiceID: id}
+	if err := planFromRequest(req, plan); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := appRepo.GetService(id); err != nil {
+		writeRepoError(w, err, "failed to get service")
+		return
+	}
+	if err := appRepo.CreatePlan(plan); err != nil {
+		writeRepoError(w, err, "failed to create plan")
+		return
+	}
+	logger.Log.Infof("Plan created with id: %d", plan.ID)
+	writeJSON(w, http.StatusCreated, plan)
+}
+
+// ListPlansHandler godoc
+// @Summary List plans of a service
+// @Tags services
+// @Produce json
+// @Param id path int true "Service ID"
+// @Success 200 {array} models.Plan
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id}/plans [get]
+func ListPlansHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	plans, err := appRepo.ListPlans(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to list plans")
+		return
+	}
+	writeJSON(w, http.StatusOK, plans)
+}
+
+// GetPlanHandler godoc
+// @Summary Get plan by id
+// @Tags services
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param plan_id path int true "Plan ID"
+// @Success 200 {object} models.Plan
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id}/plans/{plan_id} [get]
+func GetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	pid, err := planID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid plan id")
+		return
+	}
+	plan, err := appRepo.GetPlan(id, pid)
+	if err != nil {
+		writeRepoError(w, err, "failed to get plan")
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// UpdatePlanHandler godoc
+// @Summary Replace plan by id
+// @Description Subscriptions on the plan take the new currency and billing period, and the new price unless they override it. Each changed subscription gets a history entry.
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param plan_id path int true "Plan ID"
+// @Param plan body models.PlanRequest true "Plan"
+// @Param X-Actor header string false "Who makes the change, recorded in the history"
+// @Success 200 {object} models.Plan
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id}/plans/{plan_id} [put]
+func UpdatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	pid, err := planID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid plan id")
+		return
+	}
+	var req models.PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	plan := &models.Plan{ID: pid, ServiceID: id}
+	if err := planFromRequest(req, plan); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.UpdatePlan(plan, actorFromRequest(r)); err != nil {
+		writeRepoError(w, err, "failed to update plan")
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// DeletePlanHandler godoc
+// @Summary Delete plan by id
+// @Description Fails with 409 while subscriptions, including those in the trash, are on the plan.
+// @Tags services
+// @Produce json
+// @Param id path int true "Service ID"
+// @Param plan_id path int true "Plan ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /services/{id}/plans/{plan_id} [delete]
+func DeletePlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := serviceID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	pid, err := planID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid plan id")
+		return
+	}
+	if err := appRepo.DeletePlan(id, pid); err != nil {
+		writeRepoError(w, err, "failed to delete plan")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
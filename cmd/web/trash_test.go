@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"testtask/internal/models"
+)
+
+func TestDeleteAndRestoreHandlers(t *testing.T) {
+	useTestRepository(t)
+	req := models.CreateSubscriptionRequest{ServiceName: "Netflix", Price: 400, UserID: importUser}
+	req.StartDate, _ = models.ParseMonthDate("07-2025")
+	w := serve(t, http.MethodPost, "/subscription", req, nil)
+	expectStatus(t, w, http.StatusCreated)
+	var sub models.Subscription
+	decode(t, w, &sub)
+	target := "/subscription/" + strconv.Itoa(sub.ID)
+
+	expectStatus(t, serve(t, http.MethodDelete, target, nil, nil), http.StatusOK)
+	expectStatus(t, serve(t, http.MethodGet, target, nil, nil), http.StatusNotFound)
+	expectStatus(t, serve(t, http.MethodDelete, target, nil, nil), http.StatusNotFound)
+
+	var trash models.SubscriptionListResponse
+	w = serve(t, http.MethodGet, "/subscription/trash", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	decode(t, w, &trash)
+	if len(trash.Items) != 1 || trash.Items[0].ID != sub.ID || trash.Items[0].DeletedAt == nil {
+		t.Fatalf("trash = %+v", trash.Items)
+	}
+	var live models.SubscriptionListResponse
+	decode(t, serve(t, http.MethodGet, "/subscription", nil, nil), &live)
+	if len(live.Items) != 0 {
+		t.Errorf("deleted subscription is listed: %+v", live.Items)
+	}
+
+	w = serve(t, http.MethodPost, target+"/restore", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	var restored models.Subscription
+	decode(t, w, &restored)
+	if restored.DeletedAt != nil || restored.Version != sub.Version+2 || w.Header().Get("ETag") != restored.ETag() {
+		t.Errorf("restored = %+v with ETag %q", restored, w.Header().Get("ETag"))
+	}
+	expectStatus(t, serve(t, http.MethodGet, target, nil, nil), http.StatusOK)
+	expectStatus(t, serve(t, http.MethodPost, target+"/restore", nil, nil), http.StatusNotFound)
+}
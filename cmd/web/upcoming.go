@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUpcomingWindow = 30 * 24 * time.Hour
+	maxUpcomingWindow     = 366 * 24 * time.Hour
+)
+
+// parseWindow reads a window length such as 30d, 2w or 36h.
+func parseWindow(v string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(v, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(v, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit == 0 {
+		return time.ParseDuration(v)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(v, "d"), "w"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// GetUpcomingHandler godoc
+// @Summary Upcoming renewals and expirations
+// @Description Lists the next billing date of every subscription that renews inside the window and the last day of every subscription that ends inside it. Billing dates count from the first billing date, the first day of start_date or, after a trial, its first paid day: monthly, quarterly and yearly subscriptions renew on the same day of the month, or the last day of shorter months, and weekly ones every 7 days. The first paid day after a trial is listed as a trial_conversion event instead of a renewal. Amounts are after discounts. The window is set by within; active_from and active_to are rejected.
+// @Tags subscriptions
+// @Produce json
+// @Param within query string false "Window from today, e.g. 30d, 2w or 36h (default 30d, at most 366d)"
+// @Param user_id query string false "User ID (UUID)"
+// @Param service_name query string false "Service name or catalog alias"
+// @Param category query string false "Catalog category"
+// @Param plan_id query int false "Plan ID"
+// @Success 200 {object} models.UpcomingResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /subscription/upcoming [get]
+func GetUpcomingHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// The window replaces the activity filters of the list.
+	for _, name := range []string{"active_from", "active_to"} {
+		if q.Has(name) {
+			writeError(w, http.StatusBadRequest, name+" is not supported here, use within")
+			return
+		}
+	}
+	filter, err := parseSubscriptionFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	within := defaultUpcomingWindow
+	if v := q.Get("within"); v != "" {
+		within, err = parseWindow(v)
+		if err != nil || within < 0 || within > maxUpcomingWindow {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid within: expected a window such as 30d, at most %dd", int(maxUpcomingWindow.Hours()/24)))
+			return
+		}
+	}
+	now := time.Now()
+	upcoming, err := appRepo.Upcoming(filter, now, now.Add(within))
+	if err != nil {
+		writeRepoError(w, err, "failed to list upcoming events")
+		return
+	}
+	writeJSON(w, http.StatusOK, upcoming)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	gorilla_mux "github.com/gorilla/mux"
+
+	"testtask/internal/models"
+	"testtask/internal/validation"
+	logger "testtask/pkg"
+)
+
+const maxDeadLetters = 1000
+
+// webhookFromRequest validates req and applies it to w.
+func webhookFromRequest(req models.WebhookRequest, w *models.Webhook) error {
+	var errs validation.Errors
+	if err := validation.Struct(req); err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	if req.URL != "" {
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, models.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+		}
+	}
+	for _, event := range req.Events {
+		if !models.IsWebhookEvent(event) {
+			errs = append(errs, models.FieldError{Field: "events", Message: fmt.Sprintf("unknown event %q", event)})
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	w.URL = req.URL
+	if req.Secret != "" {
+		w.Secret = req.Secret
+	}
+	w.Events = req.Events
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	w.Active = req.Active == nil || *req.Active
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func webhookID(r *http.Request) (int, error) {
+	return strconv.Atoi(gorilla_mux.Vars(r)["id"])
+}
+
+// CreateWebhookHandler godoc
+// @Summary Register webhook
+// @Description Registers a URL that receives subscription events as signed POST requests. The X-Webhook-Signature header carries sha256=HMAC-SHA256(secret, timestamp + "." + body) with the timestamp from X-Webhook-Timestamp. The secret is generated when omitted and only returned here. An empty events list subscribes to every event.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body models.WebhookRequest true "Webhook"
+// @Success 201 {object} models.Webhook
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks [post]
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	hook := &models.Webhook{}
+	if err := webhookFromRequest(req, hook); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate secret")
+			return
+		}
+		hook.Secret = secret
+	}
+	if err := appRepo.CreateWebhook(hook); err != nil {
+		writeRepoError(w, err, "failed to create webhook")
+		return
+	}
+	logger.Log.Infof("Webhook created with id: %d", hook.ID)
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// ListWebhooksHandler godoc
+// @Summary List webhooks
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} models.Webhook
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks [get]
+func ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := appRepo.ListWebhooks()
+	if err != nil {
+		writeRepoError(w, err, "failed to list webhooks")
+		return
+	}
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// GetWebhookHandler godoc
+// @Summary Get webhook by id
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks/{id} [get]
+func GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	hook, err := appRepo.GetWebhook(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get webhook")
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// UpdateWebhookHandler godoc
+// @Summary Replace webhook by id
+// @Description Replaces the webhook. The secret is kept when omitted.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param webhook body models.WebhookRequest true "Webhook"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks/{id} [put]
+func UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeRequestError(w, jsonDecodeError(err))
+		return
+	}
+	hook, err := appRepo.GetWebhook(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to get webhook")
+		return
+	}
+	if err := webhookFromRequest(req, hook); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := appRepo.UpdateWebhook(hook); err != nil {
+		writeRepoError(w, err, "failed to update webhook")
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// DeleteWebhookHandler godoc
+// @Summary Delete webhook by id
+// @Description Removes the webhook and its pending and dead deliveries.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks/{id} [delete]
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := webhookID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := appRepo.DeleteWebhook(id); err != nil {
+		writeRepoError(w, err, "failed to delete webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// ListDeadLettersHandler godoc
+// @Summary List dead webhook deliveries
+// @Description Deliveries that failed on every attempt, newest first.
+// @Tags webhooks
+// @Produce json
+// @Param webhook_id query int false "Only deliveries of this webhook"
+// @Param limit query int false "At most this many deliveries (default 100, max 1000)"
+// @Success 200 {array} models.WebhookDelivery
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks/dead-letters [get]
+func ListDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	hookID, limit := 0, 100
+	var err error
+	if v := q.Get("webhook_id"); v != "" {
+		if hookID, err = strconv.Atoi(v); err != nil || hookID < 1 {
+			writeError(w, http.StatusBadRequest, "invalid webhook_id")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDeadLetters {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	deliveries, err := appRepo.ListDeadDeliveries(hookID, limit)
+	if err != nil {
+		writeRepoError(w, err, "failed to list dead deliveries")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// RedeliverHandler godoc
+// @Summary Redeliver webhook delivery
+// @Description Queues a delivery, typically a dead one, to be sent again with a fresh attempt count.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /webhooks/deliveries/{id}/redeliver [post]
+func RedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(gorilla_mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	delivery, err := appRepo.RedeliverWebhookDelivery(id)
+	if err != nil {
+		writeRepoError(w, err, "failed to redeliver")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,179 @@
+package billing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+type groupKey struct {
+	serviceName string
+	planID      int
+	userID      uuid.UUID
+	month       models.MonthDate
+}
+
+type group struct {
+	key           groupKey
+	total         float64
+	subscriptions int
+	months        int
+}
+
+// Converter converts an amount in currency to the currency of a report at
+// the rate effective on a date.
+type Converter interface {
+	Convert(amount float64, currency string, on time.Time) (float64, error)
+}
+
+// Breakdown accumulates subscription costs over a period grouped by any
+// combination of service, plan, user and calendar month. Grouped by user,
+// shared subscriptions count for every member with their share.
+type Breakdown struct {
+	period     Period
+	allocation string
+	conv       Converter
+	byService  bool
+	byPlan     bool
+	byUser     bool
+	byMonth    bool
+	groups     map[groupKey]*group
+	total      float64
+
+	// member restricts the costs to the shares of one user.
+	member *uuid.UUID
+}
+
+// NewBreakdown starts an empty breakdown. Amounts are converted with conv
+// unless it is nil.
+func NewBreakdown(period Period, allocation string, conv Converter, groupBy []string) *Breakdown {
+	b := &Breakdown{period: period, allocation: allocation, conv: conv, groups: map[groupKey]*group{}}
+	for _, g := range groupBy {
+		switch g {
+		case models.GroupByService:
+			b.byService = true
+		case models.GroupByPlan:
+			b.byPlan = true
+		case models.GroupByUser:
+			b.byUser = true
+		case models.GroupByMonth:
+			b.byMonth = true
+		}
+	}
+	return b
+}
+
+// OnlyShareOf counts only what userID pays of each subscription.
+func (b *Breakdown) OnlyShareOf(userID uuid.UUID) {
+	b.member = &userID
+}
+
+// Add charges sub for each of its active months in the period. Amounts
+// are kept unrounded until Result, so amortized charges add up.
+func (b *Breakdown) Add(sub *models.Subscription) error {
+	seen := map[groupKey]bool{}
+	var err error
+	EachCharge(sub, b.period, b.allocation, func(month models.MonthDate, amount float64) {
+		if err != nil {
+			return
+		}
+		if b.conv != nil {
+			if amount, err = b.conv.Convert(amount, sub.Currency, month.Time); err != nil {
+				return
+			}
+		}
+		shares := map[uuid.UUID]float64{sub.UserID: 1}
+		if b.byUser || b.member != nil {
+			shares = Shares(sub, month)
+		}
+		for userID, share := range shares {
+			if share == 0 || (b.member != nil && userID != *b.member) {
+				continue
+			}
+			key := groupKey{}
+			if b.byService {
+				key.serviceName = sub.ServiceName
+			}
+			if b.byPlan && sub.PlanID != nil {
+				key.planID = *sub.PlanID
+			}
+			if b.byUser {
+				key.userID = userID
+			}
+			if b.byMonth {
+				key.month = month
+			}
+			g, ok := b.groups[key]
+			if !ok {
+				g = &group{key: key}
+				b.groups[key] = g
+			}
+			if !seen[key] {
+				seen[key] = true
+				g.subscriptions++
+			}
+			g.total += amount * share
+			g.months++
+			b.total += amount * share
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscription %d: %w", sub.ID, err)
+	}
+	return nil
+}
+
+// Result returns the groups ordered by month, service name, plan and user.
+func (b *Breakdown) Result() *models.BreakdownResponse {
+	groups := make([]*group, 0, len(b.groups))
+	for _, g := range b.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, c := groups[i].key, groups[j].key
+		if a.month.Index() != c.month.Index() {
+			return a.month.Index() < c.month.Index()
+		}
+		if a.serviceName != c.serviceName {
+			return a.serviceName < c.serviceName
+		}
+		if a.planID != c.planID {
+			return a.planID < c.planID
+		}
+		return a.userID.String() < c.userID.String()
+	})
+
+	resp := &models.BreakdownResponse{
+		Allocation: b.allocation,
+		Total:      Round(b.total),
+		Groups:     make([]models.BreakdownGroup, 0, len(groups)),
+	}
+	for _, g := range groups {
+		row := models.BreakdownGroup{
+			Total:         Round(g.total),
+			Subscriptions: g.subscriptions,
+			Months:        g.months,
+		}
+		if b.byService {
+			row.ServiceName = g.key.serviceName
+		}
+		if b.byPlan && g.key.planID != 0 {
+			planID := g.key.planID
+			row.PlanID = &planID
+		}
+		if b.byUser {
+			userID := g.key.userID
+			row.UserID = &userID
+		}
+		if b.byMonth {
+			month := g.key.month
+			row.Month = &month
+		}
+		resp.Groups = append(resp.Groups, row)
+	}
+	return resp
+}
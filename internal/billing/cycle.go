@@ -0,0 +1,130 @@
+package billing
+
+import (
+	"math"
+	"time"
+
+	"testtask/internal/models"
+)
+
+// weeksPerMonth is the average number of weeks in a Gregorian month.
+const weeksPerMonth = 365.2425 / 7 / 12
+
+var periodMonths = map[string]int{
+	models.BillingMonth:   1,
+	models.BillingQuarter: 3,
+	models.BillingYear:    12,
+}
+
+// interval returns the billing period and interval count of sub. Rows
+// written before billing periods existed are monthly.
+func interval(sub *models.Subscription) (string, int) {
+	period, count := sub.BillingPeriod, sub.IntervalCount
+	if period == "" {
+		period = models.BillingMonth
+	}
+	if count < 1 {
+		count = 1
+	}
+	return period, count
+}
+
+// MonthlyCharge returns what sub costs in month, which must be one of its
+// active months. Trial months are free. Each charge is the price scheduled
+// for the month it renews in, see Subscription.PriceOn, less the discounts
+// running in that month. With AllocationRenewal that charge is counted for
+// every billing date in month, see billingDate. With AllocationAmortized
+// the charge of the renewal month is spread evenly over the months it
+// covers, so that both allocations add up to the same over a billing
+// period; weekly charges are spread at the average number of weeks in a
+// month.
+func MonthlyCharge(sub *models.Subscription, month models.MonthDate, allocation string) float64 {
+	first := FirstPaidMonth(sub)
+	if month.Before(first.Time) {
+		return 0
+	}
+	period, count := interval(sub)
+
+	if period == models.BillingWeek {
+		charge := renewalCharge(sub, month)
+		if allocation == models.AllocationAmortized {
+			return charge * weeksPerMonth / float64(count)
+		}
+		return charge * float64(weeklyRenewals(anchor(sub), month, count))
+	}
+
+	months := periodMonths[period] * count
+	elapsed := month.Index() - first.Index()
+	if allocation == models.AllocationAmortized {
+		return renewalCharge(sub, month.AddMonths(-(elapsed%months))) / float64(months)
+	}
+	if elapsed%months == 0 {
+		return renewalCharge(sub, month)
+	}
+	return 0
+}
+
+// renewalCharge returns what sub charges when it renews in month.
+func renewalCharge(sub *models.Subscription, month models.MonthDate) float64 {
+	return Discounted(sub, month, float64(sub.PriceOn(month)))
+}
+
+// anchor returns the first billing date of sub: the first day of
+// StartDate, or with a trial the day it converts to paid.
+func anchor(sub *models.Subscription) time.Time {
+	start := sub.StartDate.Time
+	if day, ok := TrialConversion(sub); ok && day.After(start) {
+		start = day
+	}
+	return start
+}
+
+// billingDate returns the k-th renewal of sub after its first billing date,
+// which is the 0th. Renewals fall on the day of the month of the first
+// billing date, or the last day of shorter months, and weekly ones every
+// 7 days from it.
+func billingDate(sub *models.Subscription, k int) time.Time {
+	first := anchor(sub)
+	period, count := interval(sub)
+	if period == models.BillingWeek {
+		return first.AddDate(0, 0, 7*count*k)
+	}
+	y, m, d := first.Date()
+	month := time.Date(y, m+time.Month(periodMonths[period]*count*k), 1, 0, 0, 0, 0, time.UTC)
+	if last := month.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return month.AddDate(0, 0, d-1)
+}
+
+// weeklyRenewals counts the renewals in month of a subscription that
+// renews every count weeks from first.
+func weeklyRenewals(first time.Time, month models.MonthDate, count int) int {
+	step := 7 * count
+	start := daysBetween(first, month.Time)
+	last := start + daysBetween(month.Time, month.AddMonths(1).Time) - 1
+	if last < 0 {
+		return 0
+	}
+	if start < 0 {
+		start = 0
+	}
+	return last/step - (start+step-1)/step + 1
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(math.Round(b.Sub(a).Hours() / 24))
+}
+
+// EachCharge calls fn with every month of p during which sub is active
+// and what sub costs in that month.
+func EachCharge(sub *models.Subscription, p Period, allocation string, fn func(month models.MonthDate, amount float64)) {
+	EachActiveMonth(sub, p, func(month models.MonthDate) {
+		fn(month, MonthlyCharge(sub, month, allocation))
+	})
+}
+
+// Round converts an accumulated amount to whole currency units.
+func Round(amount float64) int64 {
+	return int64(math.Round(amount))
+}
@@ -0,0 +1,233 @@
+package billing
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func month(t *testing.T, s string) models.MonthDate {
+	t.Helper()
+	m, err := models.ParseMonthDate(s)
+	if err != nil {
+		t.Fatalf("ParseMonthDate(%q): %v", s, err)
+	}
+	return m
+}
+
+func monthPtr(t *testing.T, s string) *models.MonthDate {
+	t.Helper()
+	m := month(t, s)
+	return &m
+}
+
+func subscription(start models.MonthDate, end *models.MonthDate, period string, count, price int) *models.Subscription {
+	return &models.Subscription{
+		ID:            1,
+		Price:         price,
+		Currency:      "RUB",
+		StartDate:     start,
+		EndDate:       end,
+		BillingPeriod: period,
+		IntervalCount: count,
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMonthlyCharge(t *testing.T) {
+	// A start given as the last day of a month is stored as that month.
+	monthEnd := models.MonthOf(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name       string
+		sub        *models.Subscription
+		month      string
+		allocation string
+		want       float64
+	}{
+		{"monthly renewal", subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100), "03-2025", models.AllocationRenewal, 100},
+		{"monthly amortized", subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100), "03-2025", models.AllocationAmortized, 100},
+		{"legacy row without period", subscription(month(t, "01-2025"), nil, "", 0, 100), "02-2025", models.AllocationRenewal, 100},
+		{"quarterly renews in first month", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300), "01-2025", models.AllocationRenewal, 300},
+		{"quarterly between renewals", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300), "02-2025", models.AllocationRenewal, 0},
+		{"quarterly renews again", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300), "04-2025", models.AllocationRenewal, 300},
+		{"quarterly amortized", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300), "02-2025", models.AllocationAmortized, 100},
+		{"yearly renews on anniversary", subscription(month(t, "03-2024"), nil, models.BillingYear, 1, 1200), "03-2025", models.AllocationRenewal, 1200},
+		{"yearly before anniversary", subscription(month(t, "03-2024"), nil, models.BillingYear, 1, 1200), "02-2025", models.AllocationRenewal, 0},
+		{"yearly amortized", subscription(month(t, "03-2024"), nil, models.BillingYear, 1, 1200), "02-2025", models.AllocationAmortized, 100},
+		{"every 2 months on", subscription(month(t, "01-2025"), nil, models.BillingMonth, 2, 100), "03-2025", models.AllocationRenewal, 100},
+		{"every 2 months off", subscription(month(t, "01-2025"), nil, models.BillingMonth, 2, 100), "02-2025", models.AllocationRenewal, 0},
+		{"every 2 months amortized", subscription(month(t, "01-2025"), nil, models.BillingMonth, 2, 100), "02-2025", models.AllocationAmortized, 50},
+		{"every 2 quarters on", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 2, 600), "07-2025", models.AllocationRenewal, 600},
+		{"every 2 quarters off", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 2, 600), "04-2025", models.AllocationRenewal, 0},
+		{"every 2 quarters amortized", subscription(month(t, "01-2025"), nil, models.BillingQuarter, 2, 600), "04-2025", models.AllocationAmortized, 100},
+		{"every 2 years skips a year", subscription(month(t, "01-2024"), nil, models.BillingYear, 2, 2400), "01-2025", models.AllocationRenewal, 0},
+		{"every 2 years renews", subscription(month(t, "01-2024"), nil, models.BillingYear, 2, 2400), "01-2026", models.AllocationRenewal, 2400},
+		{"month-end start renews quarterly", subscription(monthEnd, nil, models.BillingQuarter, 1, 300), "04-2025", models.AllocationRenewal, 300},
+		{"month-end start between renewals", subscription(monthEnd, nil, models.BillingQuarter, 1, 300), "03-2025", models.AllocationRenewal, 0},
+		{"month-end start yearly", subscription(monthEnd, nil, models.BillingYear, 1, 1200), "01-2026", models.AllocationRenewal, 1200},
+		// Weekly subscriptions renew on days 0, 7, 14, ... counted from
+		// the first day of the start month.
+		{"weekly five renewals in January", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10), "01-2025", models.AllocationRenewal, 50},
+		{"weekly four renewals in February", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10), "02-2025", models.AllocationRenewal, 40},
+		{"weekly four renewals in March", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10), "03-2025", models.AllocationRenewal, 40},
+		{"weekly five renewals in April", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10), "04-2025", models.AllocationRenewal, 50},
+		{"weekly leap February", subscription(month(t, "02-2024"), nil, models.BillingWeek, 1, 10), "02-2024", models.AllocationRenewal, 50},
+		{"weekly common February", subscription(month(t, "02-2025"), nil, models.BillingWeek, 1, 10), "02-2025", models.AllocationRenewal, 40},
+		{"every 2 weeks in January", subscription(month(t, "01-2025"), nil, models.BillingWeek, 2, 10), "01-2025", models.AllocationRenewal, 30},
+		{"every 2 weeks in February", subscription(month(t, "01-2025"), nil, models.BillingWeek, 2, 10), "02-2025", models.AllocationRenewal, 20},
+		{"weekly amortized", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 12), "02-2025", models.AllocationAmortized, 12 * weeksPerMonth},
+		{"every 2 weeks amortized", subscription(month(t, "01-2025"), nil, models.BillingWeek, 2, 12), "02-2025", models.AllocationAmortized, 6 * weeksPerMonth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MonthlyCharge(tt.sub, month(t, tt.month), tt.allocation)
+			if !approxEqual(got, tt.want) {
+				t.Errorf("MonthlyCharge(%s) = %v, want %v", tt.month, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveMonths(t *testing.T) {
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		sub    *models.Subscription
+		period Period
+		want   int
+		first  string
+	}{
+		{
+			name:   "period inside subscription",
+			sub:    subscription(month(t, "01-2025"), monthPtr(t, "12-2025"), models.BillingMonth, 1, 100),
+			period: NewPeriod(monthPtr(t, "03-2025"), monthPtr(t, "05-2025"), now),
+			want:   3,
+			first:  "03-2025",
+		},
+		{
+			name:   "period starts before subscription",
+			sub:    subscription(month(t, "06-2025"), nil, models.BillingMonth, 1, 100),
+			period: NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "08-2025"), now),
+			want:   3,
+			first:  "06-2025",
+		},
+		{
+			name:   "period without start",
+			sub:    subscription(month(t, "01-2025"), monthPtr(t, "03-2025"), models.BillingMonth, 1, 100),
+			period: NewPeriod(nil, monthPtr(t, "12-2025"), now),
+			want:   3,
+			first:  "01-2025",
+		},
+		{
+			name:   "open-ended counts up to the end of the period",
+			sub:    subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100),
+			period: NewPeriod(nil, monthPtr(t, "06-2025"), now),
+			want:   6,
+			first:  "01-2025",
+		},
+		{
+			name:   "open-ended counts up to the current month",
+			sub:    subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100),
+			period: NewPeriod(nil, nil, now),
+			want:   7,
+			first:  "01-2025",
+		},
+		{
+			name:   "single month",
+			sub:    subscription(month(t, "05-2025"), monthPtr(t, "05-2025"), models.BillingMonth, 1, 100),
+			period: NewPeriod(nil, nil, now),
+			want:   1,
+			first:  "05-2025",
+		},
+		{
+			name:   "ended before the period",
+			sub:    subscription(month(t, "01-2024"), monthPtr(t, "12-2024"), models.BillingMonth, 1, 100),
+			period: NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "12-2025"), now),
+		},
+		{
+			name:   "starts after the period",
+			sub:    subscription(month(t, "01-2026"), nil, models.BillingMonth, 1, 100),
+			period: NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "12-2025"), now),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ActiveMonths(tt.sub, tt.period); got != tt.want {
+				t.Errorf("ActiveMonths = %d, want %d", got, tt.want)
+			}
+			var months []models.MonthDate
+			EachActiveMonth(tt.sub, tt.period, func(m models.MonthDate) {
+				months = append(months, m)
+			})
+			if len(months) != tt.want {
+				t.Fatalf("EachActiveMonth visited %d months, want %d", len(months), tt.want)
+			}
+			if tt.want > 0 && months[0].String() != tt.first {
+				t.Errorf("first month = %s, want %s", months[0], tt.first)
+			}
+			for i := 1; i < len(months); i++ {
+				if months[i].Index() != months[i-1].Index()+1 {
+					t.Errorf("months %s and %s are not consecutive", months[i-1], months[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEachChargeTotals(t *testing.T) {
+	now := time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)
+	year2025 := NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "12-2025"), now)
+	tests := []struct {
+		name       string
+		sub        *models.Subscription
+		period     Period
+		allocation string
+		want       float64
+		months     int
+	}{
+		{"quarterly starting inside the period", subscription(month(t, "02-2025"), nil, models.BillingQuarter, 1, 300), year2025, models.AllocationRenewal, 1200, 11},
+		{"quarterly amortized starting inside the period", subscription(month(t, "02-2025"), nil, models.BillingQuarter, 1, 300), year2025, models.AllocationAmortized, 1100, 11},
+		{"yearly started before the period", subscription(month(t, "07-2024"), nil, models.BillingYear, 1, 1200), year2025, models.AllocationRenewal, 1200, 12},
+		{"yearly amortized started before the period", subscription(month(t, "07-2024"), nil, models.BillingYear, 1, 1200), year2025, models.AllocationAmortized, 1200, 12},
+		{"yearly ended before its renewal", subscription(month(t, "07-2024"), monthPtr(t, "06-2025"), models.BillingYear, 1, 1200), year2025, models.AllocationRenewal, 0, 6},
+		{"weekly open-ended", subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10), NewPeriod(monthPtr(t, "03-2025"), monthPtr(t, "04-2025"), now), models.AllocationRenewal, 90, 2},
+		{"monthly open-ended up to now", subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100), NewPeriod(nil, nil, now), models.AllocationRenewal, 700, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total float64
+			months := 0
+			EachCharge(tt.sub, tt.period, tt.allocation, func(_ models.MonthDate, amount float64) {
+				total += amount
+				months++
+			})
+			if !approxEqual(total, tt.want) || months != tt.months {
+				t.Errorf("got %v over %d months, want %v over %d", total, months, tt.want, tt.months)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want int64
+	}{
+		{0, 0},
+		{99.4999, 99},
+		{99.5, 100},
+		{100.0 / 3 * 3, 100},
+		{-0.5, -1},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.in); got != tt.want {
+			t.Errorf("Round(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package billing
+
+import (
+	"time"
+
+	"testtask/internal/models"
+)
+
+// Period is an inclusive range of calendar months. A nil From means the
+// period starts together with each subscription.
+type Period struct {
+	From *models.MonthDate
+	To   models.MonthDate
+}
+
+// NewPeriod builds a period from optional bounds. A missing upper bound
+// defaults to the month of now, so open-ended subscriptions are counted
+// up to the current month.
+func NewPeriod(from, to *models.MonthDate, now time.Time) Period {
+	p := Period{From: from, To: models.MonthOf(now)}
+	if to != nil {
+		p.To = *to
+	}
+	return p
+}
+
+// ActiveMonths counts the months of p during which sub is active.
+// Both the subscription and the period are clipped to each other.
+func ActiveMonths(sub *models.Subscription, p Period) int {
+	first := sub.StartDate.Index()
+	if p.From != nil && p.From.Index() > first {
+		first = p.From.Index()
+	}
+	last := p.To.Index()
+	if sub.EndDate != nil && sub.EndDate.Index() < last {
+		last = sub.EndDate.Index()
+	}
+	if last < first {
+		return 0
+	}
+	return last - first + 1
+}
+
+// EachActiveMonth calls fn with every month of p during which sub is
+// active.
+func EachActiveMonth(sub *models.Subscription, p Period, fn func(month models.MonthDate)) {
+	first := sub.StartDate
+	if p.From != nil && p.From.After(first.Time) {
+		first = *p.From
+	}
+	for i, n := 0, ActiveMonths(sub, p); i < n; i++ {
+		fn(first.AddMonths(i))
+	}
+}
@@ -0,0 +1,58 @@
+package billing
+
+import (
+	"time"
+
+	"testtask/internal/models"
+)
+
+// TrialConversion returns the first paid day of sub, the day after its
+// trial ends. ok is false without a trial.
+func TrialConversion(sub *models.Subscription) (day time.Time, ok bool) {
+	if sub.TrialEnd == nil {
+		return time.Time{}, false
+	}
+	return sub.TrialEnd.AddDate(0, 0, 1), true
+}
+
+// FirstPaidMonth returns the first month sub is charged for: StartDate, or
+// with a trial the month its first paid day falls in. Months that end
+// within the trial are free; billing cycles count from this month.
+func FirstPaidMonth(sub *models.Subscription) models.MonthDate {
+	first := sub.StartDate
+	if day, ok := TrialConversion(sub); ok {
+		if paid := models.MonthOf(day); paid.After(first.Time) {
+			first = paid
+		}
+	}
+	return first
+}
+
+// Discounted applies the discounts of sub running in month to amount.
+// Percentages are taken off first, then fixed amounts; the result is
+// never negative.
+func Discounted(sub *models.Subscription, month models.MonthDate, amount float64) float64 {
+	if amount == 0 || len(sub.Discounts) == 0 {
+		return amount
+	}
+	var fixed float64
+	for _, d := range sub.Discounts {
+		from := FirstPaidMonth(sub)
+		if d.From != nil {
+			from = *d.From
+		}
+		if month.Index() < from.Index() || month.Index() >= from.Index()+d.Months {
+			continue
+		}
+		switch d.Kind {
+		case models.DiscountPercent:
+			amount -= amount * d.Value / 100
+		case models.DiscountFixed:
+			fixed += d.Value
+		}
+	}
+	if amount -= fixed; amount < 0 {
+		return 0
+	}
+	return amount
+}
@@ -0,0 +1,239 @@
+package billing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func withTrial(sub *models.Subscription, end string) *models.Subscription {
+	d := models.DateOf(at(end))
+	sub.TrialEnd = &d
+	return sub
+}
+
+func TestFirstPaidMonth(t *testing.T) {
+	tests := []struct {
+		name     string
+		trialEnd string // empty without a trial
+		want     string
+	}{
+		{"no trial", "", "01-2025"},
+		{"trial ends in the start month", "2025-01-10", "01-2025"},
+		{"trial converts mid month", "2025-03-14", "03-2025"},
+		{"trial ends on the last day of a month", "2025-03-31", "04-2025"},
+		{"trial ends the day before the start", "2024-12-31", "01-2025"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100)
+			if tt.trialEnd != "" {
+				withTrial(sub, tt.trialEnd)
+			}
+			if got := FirstPaidMonth(sub); got.String() != tt.want {
+				t.Errorf("FirstPaidMonth = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrialConversion(t *testing.T) {
+	sub := subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100)
+	if _, ok := TrialConversion(sub); ok {
+		t.Error("TrialConversion without a trial is set")
+	}
+	withTrial(sub, "2025-02-28")
+	if day, ok := TrialConversion(sub); !ok || day.Format(models.DayLayout) != "2025-03-01" {
+		t.Errorf("TrialConversion = %s, %v, want 2025-03-01", day.Format(models.DayLayout), ok)
+	}
+}
+
+func TestDiscounted(t *testing.T) {
+	percent := func(value float64, months int) models.Discount {
+		return models.Discount{Kind: models.DiscountPercent, Value: value, Months: months}
+	}
+	fixed := func(value float64, months int) models.Discount {
+		return models.Discount{Kind: models.DiscountFixed, Value: value, Months: months}
+	}
+	from := func(d models.Discount, m string) models.Discount {
+		d.From = monthPtr(t, m)
+		return d
+	}
+
+	tests := []struct {
+		name      string
+		trialEnd  string
+		discounts []models.Discount
+		month     string
+		amount    float64
+		want      float64
+	}{
+		{"no discounts", "", nil, "01-2025", 100, 100},
+		{"percent in the first month", "", []models.Discount{percent(20, 3)}, "01-2025", 100, 80},
+		{"percent in the last month", "", []models.Discount{percent(20, 3)}, "03-2025", 100, 80},
+		{"percent has run out", "", []models.Discount{percent(20, 3)}, "04-2025", 100, 100},
+		{"fixed", "", []models.Discount{fixed(30, 1)}, "01-2025", 100, 70},
+		{"fixed larger than the amount", "", []models.Discount{fixed(150, 1)}, "01-2025", 100, 0},
+		{"percent is taken off before fixed", "", []models.Discount{fixed(10, 1), percent(50, 1)}, "01-2025", 100, 40},
+		{"percents compound", "", []models.Discount{percent(50, 1), percent(50, 1)}, "01-2025", 100, 25},
+		{"full percent", "", []models.Discount{percent(100, 1)}, "01-2025", 100, 0},
+		{"fractional percent", "", []models.Discount{percent(12.5, 1)}, "01-2025", 99, 86.625},
+		{"explicit start not reached", "", []models.Discount{from(percent(20, 2), "03-2025")}, "02-2025", 100, 100},
+		{"explicit start", "", []models.Discount{from(percent(20, 2), "03-2025")}, "04-2025", 100, 80},
+		{"explicit start has run out", "", []models.Discount{from(percent(20, 2), "03-2025")}, "05-2025", 100, 100},
+		{"runs from the first paid month", "2025-03-14", []models.Discount{percent(20, 1)}, "01-2025", 100, 100},
+		{"first paid month", "2025-03-14", []models.Discount{percent(20, 1)}, "03-2025", 100, 80},
+		{"nothing to discount", "", []models.Discount{fixed(30, 1)}, "01-2025", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100)
+			if tt.trialEnd != "" {
+				withTrial(sub, tt.trialEnd)
+			}
+			sub.Discounts = tt.discounts
+			if got := Discounted(sub, month(t, tt.month), tt.amount); !approxEqual(got, tt.want) {
+				t.Errorf("Discounted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrialCharges(t *testing.T) {
+	sub := withTrial(subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100), "2025-03-14")
+	sub.Discounts = []models.Discount{
+		{Kind: models.DiscountPercent, Value: 50, Months: 2},
+		{Kind: models.DiscountFixed, Value: 10, Months: 1},
+	}
+	want := map[string]float64{"01-2025": 0, "02-2025": 0, "03-2025": 40, "04-2025": 50, "05-2025": 100}
+	for m, charge := range want {
+		if got := MonthlyCharge(sub, month(t, m), models.AllocationRenewal); !approxEqual(got, charge) {
+			t.Errorf("MonthlyCharge(%s) = %v, want %v", m, got, charge)
+		}
+	}
+
+	quarterly := withTrial(subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300), "2025-02-09")
+	renewals := map[string]string{
+		"2024-12-01": "2025-02-10", // the trial converts on its own day
+		"2025-02-10": "2025-02-10",
+		"2025-02-11": "2025-05-10", // and later renewals on the same day
+		"2025-05-11": "2025-08-10",
+	}
+	for from, next := range renewals {
+		if got, ok := NextRenewal(quarterly, at(from)); !ok || got.Format(models.DayLayout) != next {
+			t.Errorf("NextRenewal(%s) = %s, %v, want %s", from, got.Format(models.DayLayout), ok, next)
+		}
+	}
+
+	events := UpcomingEvents(sub, at("2025-03-01"), at("2025-04-30"))
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1: %+v", len(events), events)
+	}
+	if e := events[0]; e.Event != models.EventTrialConversion || e.Date != "2025-03-15" || e.Amount != 40 ||
+		e.Note != "trial converts to paid on 2025-03-15" {
+		t.Errorf("conversion event = %+v", e)
+	}
+	events = UpcomingEvents(sub, at("2025-03-16"), at("2025-04-30"))
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1: %+v", len(events), events)
+	}
+	if e := events[0]; e.Event != models.EventRenewal || e.Date != "2025-04-15" || e.Amount != 50 || e.Note != "" {
+		t.Errorf("renewal event = %+v", e)
+	}
+}
+
+// TestMidMonthConversion checks that the renewals listed as upcoming are
+// the ones charged for when a trial converts in the middle of a month.
+func TestMidMonthConversion(t *testing.T) {
+	tests := []struct {
+		name     string
+		period   string
+		count    int
+		trialEnd string
+		dates    []string
+	}{
+		{"monthly", models.BillingMonth, 1, "2025-03-14", []string{"2025-03-15", "2025-04-15", "2025-05-15"}},
+		{"monthly from the 31st", models.BillingMonth, 1, "2025-01-30", []string{"2025-01-31", "2025-02-28", "2025-03-31"}},
+		{"quarterly", models.BillingQuarter, 1, "2025-03-14", []string{"2025-03-15", "2025-06-15"}},
+		{"weekly", models.BillingWeek, 1, "2025-03-14", []string{"2025-03-15", "2025-03-22", "2025-03-29", "2025-04-05", "2025-04-12", "2025-04-19", "2025-04-26", "2025-05-03"}},
+		{"fortnightly", models.BillingWeek, 2, "2025-03-14", []string{"2025-03-15", "2025-03-29", "2025-04-12", "2025-04-26"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := month(t, "06-2025")
+			sub := withTrial(subscription(month(t, "01-2025"), &end, tt.period, tt.count, 100), tt.trialEnd)
+
+			var dates []string
+			renewals := map[string]int{}
+			for from := at("2025-01-01"); ; {
+				date, ok := NextRenewal(sub, from)
+				if !ok {
+					break
+				}
+				dates = append(dates, date.Format(models.DayLayout))
+				renewals[models.MonthOf(date).String()]++
+				from = date.AddDate(0, 0, 1)
+			}
+			if len(dates) < len(tt.dates) || !reflect.DeepEqual(dates[:len(tt.dates)], tt.dates) {
+				t.Errorf("renewals = %v, want %v first", dates, tt.dates)
+			}
+			EachActiveMonth(sub, NewPeriod(monthPtr(t, "01-2025"), &end, time.Now()), func(m models.MonthDate) {
+				want := float64(100 * renewals[m.String()])
+				if got := MonthlyCharge(sub, m, models.AllocationRenewal); !approxEqual(got, want) {
+					t.Errorf("MonthlyCharge(%s) = %v, want %v for %d renewals", m, got, want, renewals[m.String()])
+				}
+			})
+		})
+	}
+}
+
+// TestDiscountedAllocations checks that discounts come off each charge
+// before it is spread, so that both allocations charge the same over a
+// billing period.
+func TestDiscountedAllocations(t *testing.T) {
+	fixed := func(value float64, months int) models.Discount {
+		return models.Discount{Kind: models.DiscountFixed, Value: value, Months: months}
+	}
+	percent := func(value float64, months int) models.Discount {
+		return models.Discount{Kind: models.DiscountPercent, Value: value, Months: months}
+	}
+	tests := []struct {
+		name      string
+		period    string
+		price     int
+		discounts []models.Discount
+		from, to  string
+		want      float64
+	}{
+		{"yearly fixed", models.BillingYear, 1200, []models.Discount{fixed(100, 1)}, "01-2025", "12-2025", 1100},
+		{"yearly percent for a year", models.BillingYear, 1200, []models.Discount{percent(25, 12)}, "01-2025", "12-2025", 900},
+		{"quarterly fixed for two cycles", models.BillingQuarter, 300, []models.Discount{fixed(50, 4)}, "01-2025", "06-2025", 500},
+		{"quarterly discount ends mid cycle", models.BillingQuarter, 300, []models.Discount{percent(50, 2)}, "01-2025", "06-2025", 450},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := subscription(month(t, "01-2025"), nil, tt.period, 1, tt.price)
+			sub.Discounts = tt.discounts
+			p := NewPeriod(monthPtr(t, tt.from), monthPtr(t, tt.to), time.Now())
+			for _, allocation := range []string{models.AllocationRenewal, models.AllocationAmortized} {
+				var total float64
+				EachCharge(sub, p, allocation, func(_ models.MonthDate, amount float64) { total += amount })
+				if !approxEqual(total, tt.want) {
+					t.Errorf("%s total = %v, want %v", allocation, total, tt.want)
+				}
+			}
+		})
+	}
+
+	// Weekly subscriptions get a deduction for every charge.
+	weekly := subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 100)
+	weekly.Discounts = []models.Discount{fixed(10, 1)}
+	if got := MonthlyCharge(weekly, month(t, "01-2025"), models.AllocationRenewal); !approxEqual(got, 5*90) {
+		t.Errorf("weekly renewal charge = %v, want %v", got, 5*90)
+	}
+	if got := MonthlyCharge(weekly, month(t, "01-2025"), models.AllocationAmortized); !approxEqual(got, 90*weeksPerMonth) {
+		t.Errorf("weekly amortized charge = %v, want %v", got, 90*weeksPerMonth)
+	}
+}
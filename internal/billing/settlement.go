@@ -0,0 +1,87 @@
+package billing
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+type debtKey struct {
+	from, to uuid.UUID
+}
+
+// Settlement accumulates what members owe the owners of shared
+// subscriptions over a period: the share of every charge the owner paid.
+type Settlement struct {
+	period     Period
+	allocation string
+	conv       Converter
+	owed       map[debtKey]float64
+}
+
+// NewSettlement starts an empty settlement. Amounts are converted with
+// conv unless it is nil.
+func NewSettlement(period Period, allocation string, conv Converter) *Settlement {
+	return &Settlement{period: period, allocation: allocation, conv: conv, owed: map[debtKey]float64{}}
+}
+
+// Add records the shares of the members of sub in each of its charges in
+// the period.
+func (s *Settlement) Add(sub *models.Subscription) error {
+	var err error
+	EachCharge(sub, s.period, s.allocation, func(month models.MonthDate, amount float64) {
+		if err != nil || amount == 0 {
+			return
+		}
+		if s.conv != nil {
+			if amount, err = s.conv.Convert(amount, sub.Currency, month.Time); err != nil {
+				return
+			}
+		}
+		for userID, share := range Shares(sub, month) {
+			if userID != sub.UserID && share > 0 {
+				s.owed[debtKey{from: userID, to: sub.UserID}] += amount * share
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscription %d: %w", sub.ID, err)
+	}
+	return nil
+}
+
+// Debts nets what each pair of users owes the other and returns the
+// non-zero balances ordered by debtor and creditor. With userID only the
+// debts userID owes or is owed are returned.
+func (s *Settlement) Debts(userID *uuid.UUID) []models.Debt {
+	debts := []models.Debt{}
+	for key, amount := range s.owed {
+		// Each pair is handled once, from the key with the smaller debtor.
+		back := s.owed[debtKey{from: key.to, to: key.from}]
+		if back > 0 && key.to.String() < key.from.String() {
+			continue
+		}
+		debt := models.Debt{From: key.from, To: key.to}
+		net := amount - back
+		if net < 0 {
+			debt.From, debt.To, net = key.to, key.from, -net
+		}
+		if debt.Amount = Round(net); debt.Amount == 0 {
+			continue
+		}
+		if userID != nil && debt.From != *userID && debt.To != *userID {
+			continue
+		}
+		debts = append(debts, debt)
+	}
+	sort.Slice(debts, func(i, j int) bool {
+		if debts[i].From != debts[j].From {
+			return debts[i].From.String() < debts[j].From.String()
+		}
+		return debts[i].To.String() < debts[j].To.String()
+	})
+	return debts
+}
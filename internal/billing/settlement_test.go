@@ -0,0 +1,195 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+var carol = uuid.MustParse("00000000-0000-0000-0000-00000000000d")
+
+// ownedBy is shared with userID as the owner.
+func ownedBy(userID uuid.UUID, price int, members ...models.Member) *models.Subscription {
+	sub := shared(price, members...)
+	sub.UserID = userID
+	return sub
+}
+
+type fixedRate struct {
+	currency string
+	rate     float64
+}
+
+func (f fixedRate) Convert(amount float64, currency string, _ time.Time) (float64, error) {
+	switch currency {
+	case "RUB":
+		return amount, nil
+	case f.currency:
+		return amount * f.rate, nil
+	}
+	return 0, errors.New("no rate")
+}
+
+func TestSettlementDebts(t *testing.T) {
+	oneMonth := NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "01-2025"), time.Now())
+	threeMonths := NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "03-2025"), time.Now())
+
+	tests := []struct {
+		name   string
+		period Period
+		subs   []*models.Subscription
+		userID *uuid.UUID
+		want   []models.Debt
+	}{
+		{
+			name:   "not shared",
+			period: oneMonth,
+			subs:   []*models.Subscription{shared(100)},
+			want:   []models.Debt{},
+		},
+		{
+			name:   "members owe the owner",
+			period: threeMonths,
+			subs:   []*models.Subscription{shared(300, weight(alice, 1), amount(bob, 50))},
+			want: []models.Debt{
+				{From: alice, To: owner, Amount: 375},
+				{From: bob, To: owner, Amount: 150},
+			},
+		},
+		{
+			name:   "uneven thirds are rounded",
+			period: oneMonth,
+			subs:   []*models.Subscription{shared(100, weight(alice, 1), weight(bob, 1))},
+			want: []models.Debt{
+				{From: alice, To: owner, Amount: 33},
+				{From: bob, To: owner, Amount: 33},
+			},
+		},
+		{
+			name:   "thirds are rounded once over the period",
+			period: threeMonths,
+			subs:   []*models.Subscription{shared(100, weight(alice, 1), weight(bob, 1))},
+			want: []models.Debt{
+				{From: alice, To: owner, Amount: 100},
+				{From: bob, To: owner, Amount: 100},
+			},
+		},
+		{
+			name:   "owner listed as a member owes nobody",
+			period: oneMonth,
+			subs:   []*models.Subscription{shared(100, weight(owner, 3), weight(alice, 1))},
+			want:   []models.Debt{{From: alice, To: owner, Amount: 25}},
+		},
+		{
+			name:   "debts in both directions are netted",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(alice, 100, weight(bob, 1)),
+				ownedBy(bob, 60, weight(alice, 1)),
+			},
+			want: []models.Debt{{From: bob, To: alice, Amount: 20}},
+		},
+		{
+			name:   "netting can turn the debt around",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(alice, 60, weight(bob, 1)),
+				ownedBy(bob, 100, weight(alice, 1)),
+			},
+			want: []models.Debt{{From: alice, To: bob, Amount: 20}},
+		},
+		{
+			name:   "debts that cancel out are dropped",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(alice, 100, amount(bob, 40)),
+				ownedBy(bob, 100, amount(alice, 40)),
+			},
+			want: []models.Debt{},
+		},
+		{
+			name:   "net below half a unit rounds to nothing",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(alice, 100, weight(bob, 1), weight(carol, 1)),
+				ownedBy(bob, 100, amount(alice, 33)),
+			},
+			want: []models.Debt{{From: carol, To: alice, Amount: 33}},
+		},
+		{
+			name:   "ordered by debtor and creditor",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(carol, 100, amount(bob, 10), amount(alice, 20)),
+				ownedBy(bob, 100, amount(alice, 30)),
+			},
+			want: []models.Debt{
+				{From: alice, To: bob, Amount: 30},
+				{From: alice, To: carol, Amount: 20},
+				{From: bob, To: carol, Amount: 10},
+			},
+		},
+		{
+			name:   "only the debts of one user",
+			period: oneMonth,
+			subs: []*models.Subscription{
+				ownedBy(carol, 100, amount(bob, 10), amount(alice, 20)),
+				ownedBy(bob, 100, amount(alice, 30)),
+			},
+			userID: &carol,
+			want: []models.Debt{
+				{From: alice, To: carol, Amount: 20},
+				{From: bob, To: carol, Amount: 10},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSettlement(tt.period, models.AllocationRenewal, nil)
+			for i, sub := range tt.subs {
+				sub.ID = i + 1
+				if err := s.Add(sub); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			}
+			got := s.Debts(tt.userID)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Debts = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("debt %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSettlementConverts(t *testing.T) {
+	period := NewPeriod(monthPtr(t, "01-2025"), monthPtr(t, "01-2025"), time.Now())
+	s := NewSettlement(period, models.AllocationRenewal, fixedRate{currency: "USD", rate: 90})
+
+	usd := ownedBy(alice, 10, weight(bob, 1))
+	usd.Currency = "USD"
+	rub := ownedBy(bob, 100, weight(alice, 1))
+	rub.ID = 2
+	for _, sub := range []*models.Subscription{usd, rub} {
+		if err := s.Add(sub); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	want := models.Debt{From: bob, To: alice, Amount: 400}
+	if got := s.Debts(nil); len(got) != 1 || got[0] != want {
+		t.Errorf("Debts = %+v, want %+v", got, want)
+	}
+
+	eur := ownedBy(alice, 10, weight(bob, 1))
+	eur.Currency = "EUR"
+	if err := s.Add(eur); err == nil {
+		t.Error("Add without a rate succeeded, want error")
+	}
+}
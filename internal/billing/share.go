@@ -0,0 +1,65 @@
+package billing
+
+import (
+	"math"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+// Shares splits what sub charges in month between its owner and members,
+// as fractions that add up to 1. Fixed amounts are taken from the price
+// of month in member order, at most until it is used up; the rest is
+// split by weight between the weighted members and the owner, who has
+// weight 1 unless listed. Without members the owner pays everything.
+func Shares(sub *models.Subscription, month models.MonthDate) map[uuid.UUID]float64 {
+	shares := map[uuid.UUID]float64{}
+	if len(sub.Members) == 0 {
+		shares[sub.UserID] = 1
+		return shares
+	}
+
+	price := float64(sub.PriceOn(month))
+	left := 1.0
+	weights := map[uuid.UUID]int{}
+	owner := false
+	for _, m := range sub.Members {
+		if m.UserID == sub.UserID {
+			owner = true
+		}
+		if m.Weight > 0 {
+			weights[m.UserID] = m.Weight
+			continue
+		}
+		share := 0.0
+		if price > 0 {
+			share = math.Min(float64(m.Amount)/price, left)
+		}
+		shares[m.UserID] = share
+		left -= share
+	}
+	if !owner {
+		weights[sub.UserID] = 1
+	}
+
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		// Only fixed amounts, the owner among them, pays what is left.
+		shares[sub.UserID] += left
+		return shares
+	}
+	for id, w := range weights {
+		shares[id] = left * float64(w) / float64(total)
+	}
+	return shares
+}
+
+// ShareOf returns the fraction of what sub charges in month that userID
+// pays.
+func ShareOf(sub *models.Subscription, month models.MonthDate, userID uuid.UUID) float64 {
+	return Shares(sub, month)[userID]
+}
@@ -0,0 +1,91 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+var (
+	owner = uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	alice = uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	bob   = uuid.MustParse("00000000-0000-0000-0000-00000000000c")
+)
+
+func shared(price int, members ...models.Member) *models.Subscription {
+	sub := &models.Subscription{
+		ID:            1,
+		Price:         price,
+		Currency:      "RUB",
+		UserID:        owner,
+		BillingPeriod: models.BillingMonth,
+		IntervalCount: 1,
+		Members:       members,
+	}
+	sub.StartDate, _ = models.ParseMonthDate("01-2025")
+	return sub
+}
+
+func weight(id uuid.UUID, w int) models.Member {
+	return models.Member{UserID: id, Weight: w}
+}
+
+func amount(id uuid.UUID, a int) models.Member {
+	return models.Member{UserID: id, Amount: a}
+}
+
+func TestShares(t *testing.T) {
+	tests := []struct {
+		name string
+		sub  *models.Subscription
+		want map[uuid.UUID]float64
+	}{
+		{"no members", shared(300), map[uuid.UUID]float64{owner: 1}},
+		{"equal weights", shared(300, weight(alice, 1), weight(bob, 1)), map[uuid.UUID]float64{owner: 1.0 / 3, alice: 1.0 / 3, bob: 1.0 / 3}},
+		{"uneven weights", shared(100, weight(alice, 2), weight(bob, 4)), map[uuid.UUID]float64{owner: 1.0 / 7, alice: 2.0 / 7, bob: 4.0 / 7}},
+		{"fixed amount, owner pays the rest", shared(300, amount(alice, 100)), map[uuid.UUID]float64{owner: 2.0 / 3, alice: 1.0 / 3}},
+		{"amount over the price", shared(300, amount(alice, 500)), map[uuid.UUID]float64{owner: 0, alice: 1}},
+		{"amounts over the price are capped in member order", shared(300, amount(alice, 200), amount(bob, 200)), map[uuid.UUID]float64{owner: 0, alice: 2.0 / 3, bob: 1.0 / 3}},
+		{"later amounts get nothing", shared(300, amount(alice, 300), amount(bob, 50)), map[uuid.UUID]float64{owner: 0, alice: 1, bob: 0}},
+		{"weights split what amounts leave", shared(300, amount(alice, 100), weight(bob, 2)), map[uuid.UUID]float64{owner: 2.0 / 9, alice: 1.0 / 3, bob: 4.0 / 9}},
+		{"owner listed with a weight", shared(100, weight(owner, 3), weight(alice, 1)), map[uuid.UUID]float64{owner: 3.0 / 4, alice: 1.0 / 4}},
+		{"owner listed with an amount", shared(100, amount(owner, 50), weight(alice, 1)), map[uuid.UUID]float64{owner: 0.5, alice: 0.5}},
+		{"owner listed with an amount pays the rest", shared(100, amount(owner, 50), amount(alice, 20)), map[uuid.UUID]float64{owner: 0.8, alice: 0.2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Shares(tt.sub, tt.sub.StartDate)
+			sum := 0.0
+			for _, share := range got {
+				sum += share
+			}
+			if !approxEqual(sum, 1) {
+				t.Errorf("shares add up to %v: %v", sum, got)
+			}
+			for id, want := range tt.want {
+				if !approxEqual(got[id], want) {
+					t.Errorf("share of %s = %v, want %v", id, got[id], want)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %d shares, want %d: %v", len(got), len(tt.want), got)
+			}
+		})
+	}
+}
+
+func TestSharesFollowScheduledPrice(t *testing.T) {
+	sub := shared(100, amount(alice, 100))
+	sub.PriceSchedule = []models.PriceChange{{Price: 200, EffectiveFrom: month(t, "03-2025")}}
+	if got := ShareOf(sub, month(t, "02-2025"), alice); !approxEqual(got, 1) {
+		t.Errorf("share before the change = %v, want 1", got)
+	}
+	if got := ShareOf(sub, month(t, "03-2025"), alice); !approxEqual(got, 0.5) {
+		t.Errorf("share after the change = %v, want 0.5", got)
+	}
+	if got := ShareOf(sub, month(t, "03-2025"), bob); got != 0 {
+		t.Errorf("share of a stranger = %v, want 0", got)
+	}
+}
@@ -0,0 +1,106 @@
+package billing
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"testtask/internal/models"
+)
+
+// Day truncates t to midnight UTC.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// endsBefore returns the first day sub is no longer active, or the zero
+// time for open-ended subscriptions.
+func endsBefore(sub *models.Subscription) time.Time {
+	if sub.EndDate == nil {
+		return time.Time{}
+	}
+	return sub.EndDate.AddMonths(1).Time
+}
+
+// LastDay returns the last day sub is active. ok is false for open-ended
+// subscriptions.
+func LastDay(sub *models.Subscription) (day time.Time, ok bool) {
+	end := endsBefore(sub)
+	if end.IsZero() {
+		return time.Time{}, false
+	}
+	return end.AddDate(0, 0, -1), true
+}
+
+// NextRenewal returns the first billing date of sub on or after from; see
+// billingDate for when renewals fall. ok is false when sub ends before it
+// renews again.
+func NextRenewal(sub *models.Subscription, from time.Time) (date time.Time, ok bool) {
+	period, count := interval(sub)
+	first := anchor(sub)
+	from = Day(from)
+
+	date = first
+	if from.After(first) {
+		var k int
+		if period == models.BillingWeek {
+			days := daysBetween(first, from)
+			k = (days + 7*count - 1) / (7 * count)
+		} else {
+			k = (models.MonthOf(from).Index() - models.MonthOf(first).Index()) / (periodMonths[period] * count)
+		}
+		if date = billingDate(sub, k); date.Before(from) {
+			date = billingDate(sub, k+1)
+		}
+	}
+	if end := endsBefore(sub); !end.IsZero() && !date.Before(end) {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// UpcomingEvents lists the next renewal and the expiration of sub when
+// they fall between from and to, both inclusive. The first charge after
+// a trial is listed as a trial conversion instead of a renewal.
+func UpcomingEvents(sub *models.Subscription, from, to time.Time) []models.UpcomingEvent {
+	from, to = Day(from), Day(to)
+	inWindow := func(t time.Time) bool {
+		return !t.Before(from) && !t.After(to)
+	}
+
+	var events []models.UpcomingEvent
+	if date, ok := NextRenewal(sub, from); ok && inWindow(date) {
+		month := models.MonthOf(date)
+		event := models.UpcomingEvent{
+			Event:        models.EventRenewal,
+			Date:         date.Format(models.DayLayout),
+			Amount:       int(math.Round(renewalCharge(sub, month))),
+			Currency:     sub.Currency,
+			Subscription: sub,
+		}
+		if day, ok := TrialConversion(sub); ok && day.Equal(date) {
+			event.Event = models.EventTrialConversion
+			event.Note = "trial converts to paid on " + event.Date
+		}
+		events = append(events, event)
+	}
+	if day, ok := LastDay(sub); ok && inWindow(day) {
+		events = append(events, models.UpcomingEvent{
+			Event:        models.EventExpiration,
+			Date:         day.Format(models.DayLayout),
+			Subscription: sub,
+		})
+	}
+	return events
+}
+
+// SortEvents orders events by date and subscription.
+func SortEvents(events []models.UpcomingEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Date != events[j].Date {
+			return events[i].Date < events[j].Date
+		}
+		return events[i].Subscription.ID < events[j].Subscription.ID
+	})
+}
@@ -0,0 +1,176 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(models.DayLayout, s)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNextRenewal(t *testing.T) {
+	monthly := subscription(month(t, "01-2025"), nil, models.BillingMonth, 1, 100)
+	ending := subscription(month(t, "01-2025"), monthPtr(t, "03-2025"), models.BillingMonth, 1, 100)
+	quarterly := subscription(month(t, "01-2025"), nil, models.BillingQuarter, 1, 300)
+	yearly := subscription(month(t, "03-2024"), nil, models.BillingYear, 1, 1200)
+	bimonthly := subscription(month(t, "01-2025"), nil, models.BillingMonth, 2, 100)
+	weekly := subscription(month(t, "01-2025"), nil, models.BillingWeek, 1, 10)
+	biweekly := subscription(month(t, "01-2025"), nil, models.BillingWeek, 2, 10)
+	monthEnd := subscription(models.MonthOf(at("2025-01-31")), nil, models.BillingMonth, 1, 100)
+
+	tests := []struct {
+		name string
+		sub  *models.Subscription
+		from string
+		want string // empty when there is no renewal
+	}{
+		{"before the start", monthly, "2024-12-10", "2025-01-01"},
+		{"on the start", monthly, "2025-01-01", "2025-01-01"},
+		{"mid month", monthly, "2025-03-15", "2025-04-01"},
+		{"on a renewal", monthly, "2025-04-01", "2025-04-01"},
+		{"time of day is ignored", monthly, "2025-04-01T15:30:00Z", "2025-04-01"},
+		{"other time zones are read in UTC", monthly, "2025-04-01T01:00:00+03:00", "2025-04-01"},
+		{"last day of a month", monthly, "2025-02-28", "2025-03-01"},
+		{"month-end start", monthEnd, "2025-02-02", "2025-03-01"},
+		{"quarterly between renewals", quarterly, "2025-02-10", "2025-04-01"},
+		{"quarterly just after a renewal", quarterly, "2025-04-02", "2025-07-01"},
+		{"yearly", yearly, "2025-01-10", "2025-03-01"},
+		{"yearly just after the anniversary", yearly, "2025-03-02", "2026-03-01"},
+		{"every 2 months", bimonthly, "2025-02-01", "2025-03-01"},
+		{"every 2 months on a skipped month", bimonthly, "2025-04-15", "2025-05-01"},
+		{"weekly on a renewal", weekly, "2025-01-08", "2025-01-08"},
+		{"weekly between renewals", weekly, "2025-01-09", "2025-01-15"},
+		{"weekly across a month", weekly, "2025-01-30", "2025-02-05"},
+		{"every 2 weeks", biweekly, "2025-01-02", "2025-01-15"},
+		{"last renewal before the end", ending, "2025-03-01", "2025-03-01"},
+		{"ends before renewing again", ending, "2025-03-15", ""},
+		{"already ended", ending, "2025-06-01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NextRenewal(tt.sub, at(tt.from))
+			if tt.want == "" {
+				if ok {
+					t.Errorf("NextRenewal = %s, want none", got.Format(models.DayLayout))
+				}
+				return
+			}
+			if !ok || got.Format(models.DayLayout) != tt.want {
+				t.Errorf("NextRenewal = %s, %v, want %s", got.Format(models.DayLayout), ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastDay(t *testing.T) {
+	tests := []struct {
+		end  string
+		want string
+	}{
+		{"03-2025", "2025-03-31"},
+		{"04-2025", "2025-04-30"},
+		{"02-2024", "2024-02-29"},
+		{"02-2025", "2025-02-28"},
+		{"12-2025", "2025-12-31"},
+	}
+	for _, tt := range tests {
+		sub := subscription(month(t, "01-2024"), monthPtr(t, tt.end), models.BillingMonth, 1, 100)
+		if got, ok := LastDay(sub); !ok || got.Format(models.DayLayout) != tt.want {
+			t.Errorf("LastDay(%s) = %s, %v, want %s", tt.end, got.Format(models.DayLayout), ok, tt.want)
+		}
+	}
+	if _, ok := LastDay(subscription(month(t, "01-2024"), nil, models.BillingMonth, 1, 100)); ok {
+		t.Error("LastDay of an open-ended subscription is set")
+	}
+}
+
+func TestUpcomingEvents(t *testing.T) {
+	sub := subscription(month(t, "01-2025"), monthPtr(t, "04-2025"), models.BillingMonth, 1, 100)
+	sub.PriceSchedule = []models.PriceChange{{Price: 150, EffectiveFrom: month(t, "04-2025")}}
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     []models.UpcomingEvent
+	}{
+		{
+			name: "renewal at the scheduled price",
+			from: "2025-03-20", to: "2025-04-19",
+			want: []models.UpcomingEvent{{Event: models.EventRenewal, Date: "2025-04-01", Amount: 150, Currency: "RUB"}},
+		},
+		{
+			name: "price before the change",
+			from: "2025-02-20", to: "2025-03-19",
+			want: []models.UpcomingEvent{{Event: models.EventRenewal, Date: "2025-03-01", Amount: 100, Currency: "RUB"}},
+		},
+		{
+			name: "expiration without renewal",
+			from: "2025-04-10", to: "2025-05-10",
+			want: []models.UpcomingEvent{{Event: models.EventExpiration, Date: "2025-04-30"}},
+		},
+		{
+			name: "window edges are inclusive",
+			from: "2025-04-01", to: "2025-04-30",
+			want: []models.UpcomingEvent{
+				{Event: models.EventRenewal, Date: "2025-04-01", Amount: 150, Currency: "RUB"},
+				{Event: models.EventExpiration, Date: "2025-04-30"},
+			},
+		},
+		{
+			name: "nothing in the window",
+			from: "2025-03-02", to: "2025-03-31",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UpcomingEvents(sub, at(tt.from), at(tt.to))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.Subscription = sub
+				if got[i] != want {
+					t.Errorf("event %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSortEvents(t *testing.T) {
+	a := &models.Subscription{ID: 1}
+	b := &models.Subscription{ID: 2}
+	events := []models.UpcomingEvent{
+		{Event: models.EventRenewal, Date: "2025-04-02", Subscription: a},
+		{Event: models.EventExpiration, Date: "2025-04-01", Subscription: b},
+		{Event: models.EventRenewal, Date: "2025-04-01", Subscription: b},
+		{Event: models.EventRenewal, Date: "2025-04-01", Subscription: a},
+	}
+	SortEvents(events)
+	want := []struct {
+		date string
+		id   int
+		kind string
+	}{
+		{"2025-04-01", 1, models.EventRenewal},
+		{"2025-04-01", 2, models.EventExpiration},
+		{"2025-04-01", 2, models.EventRenewal},
+		{"2025-04-02", 1, models.EventRenewal},
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Date != w.date || e.Subscription.ID != w.id || e.Event != w.kind {
+			t.Errorf("event %d = %s %d %s, want %s %d %s", i, e.Date, e.Subscription.ID, e.Event, w.date, w.id, w.kind)
+		}
+	}
+}
@@ -18,21 +18,27 @@ type RedisClient struct {
 	ctx    context.Context
 }
 
-func Connect(cfg config.RedisConfig) (*RedisClient, error) {
+// New returns a client for cfg without checking that Redis is reachable.
+func New(cfg config.RedisConfig) *RedisClient {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
 		Password: cfg.Password,
 		DB:       cfg.DB,
 	})
+	return &RedisClient{client: rdb, ctx: context.Background()}
+}
+
+func Connect(cfg config.RedisConfig) (*RedisClient, error) {
+	client := New(cfg)
 
-	_, err := rdb.Ping(context.Background()).Result()
+	_, err := client.client.Ping(client.ctx).Result()
 	if err != nil {
 
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 
 	}
 
-	return &RedisClient{client: rdb, ctx: context.Background()}, nil
+	return client, nil
 }
 func (r *RedisClient) Close() error {
 	return r.client.Close()
@@ -65,3 +71,44 @@ func (r *RedisClient) GetSubscription(id int) (*models.Subscription, error) {
 func (r *RedisClient) DeleteSubscription(id int) error {
 	return r.client.Del(r.ctx, strconv.Itoa(id)).Err()
 }
+
+func idempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+// ReserveIdempotency stores rec unless its key is already taken. It returns
+// the stored record and whether it was created by this call.
+func (r *RedisClient) ReserveIdempotency(rec *models.IdempotencyRecord, ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+	recJSON, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err := r.client.SetNX(r.ctx, idempotencyKey(rec.Key), recJSON, ttl).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		return rec, true, nil
+	}
+	stored, err := r.client.Get(r.ctx, idempotencyKey(rec.Key)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	var existing models.IdempotencyRecord
+	if err := json.Unmarshal([]byte(stored), &existing); err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+func (r *RedisClient) SetIdempotency(rec *models.IdempotencyRecord, ttl time.Duration) error {
+	recJSON, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(r.ctx, idempotencyKey(rec.Key), recJSON, ttl).Err()
+}
+
+func (r *RedisClient) DeleteIdempotency(key string) error {
+	return r.client.Del(r.ctx, idempotencyKey(key)).Err()
+}
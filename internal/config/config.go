@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -11,6 +12,10 @@ type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Database DatabaseConfig `yaml:"database"`
 	Redis    RedisConfig    `yaml:"redis"`
+	API      APIConfig      `yaml:"api"`
+	Purge    PurgeConfig    `yaml:"purge"`
+	Currency CurrencyConfig `yaml:"currency"`
+	Webhooks WebhookConfig  `yaml:"webhooks"`
 }
 
 type ServerConfig struct {
@@ -34,6 +39,55 @@ type RedisConfig struct {
 	DB       int    `yaml:"db"`
 }
 
+type APIConfig struct {
+	// AcceptISODates lets clients send YYYY-MM-DD dates next to MM-YYYY.
+	AcceptISODates bool `yaml:"accept_iso_dates"`
+	// RequireIfMatch rejects PATCH and DELETE requests without If-Match.
+	RequireIfMatch bool `yaml:"require_if_match"`
+	// IdempotencyTTL is how long Idempotency-Key responses are replayed.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+	// IdempotencyLease is how long a key stays claimed by a request that
+	// has not answered yet. A retry after that takes the request over.
+	IdempotencyLease time.Duration `yaml:"idempotency_lease"`
+}
+
+type PurgeConfig struct {
+	// RetentionDays is how long deleted subscriptions stay in the trash.
+	// Zero disables the purge job.
+	RetentionDays int           `yaml:"retention_days"`
+	Interval      time.Duration `yaml:"interval"`
+}
+
+type CurrencyConfig struct {
+	// Default is the currency of subscriptions created without one and of
+	// totals requested without one.
+	Default string `yaml:"default"`
+	// RatesFile is an ECB-style exchange rate file, CSV or XML. Without it
+	// totals can only be taken in the currency the subscriptions use.
+	RatesFile string `yaml:"rates_file"`
+	// RatesBase is the currency the file quotes rates against.
+	RatesBase string `yaml:"rates_base"`
+}
+
+type WebhookConfig struct {
+	// Enabled starts the dispatcher that sends queued events.
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	// BatchSize is how many deliveries are claimed at a time. They stay
+	// claimed for BatchSize+1 times Timeout, which is also how long
+	// deliveries claimed by a dispatcher that stops are delayed.
+	BatchSize int           `yaml:"batch_size"`
+	Timeout   time.Duration `yaml:"timeout"`
+	// MaxAttempts is how many times a delivery is tried before it is
+	// moved to the dead-letter list.
+	MaxAttempts int           `yaml:"max_attempts"`
+	BackoffBase time.Duration `yaml:"backoff_base"`
+	BackoffMax  time.Duration `yaml:"backoff_max"`
+	// ExpiringWithinDays is how long before their last day subscriptions
+	// emit subscription.expiring. Zero disables the event.
+	ExpiringWithinDays int `yaml:"expiring_within_days"`
+}
+
 func LoadFromYAML() (*Config, error) {
 	data, err := os.ReadFile("config.yaml")
 	if err != nil {
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BudgetThresholds are the percentages of a budget at which an alert is
+// recorded.
+var BudgetThresholds = []int{80, 100}
+
+// Budget states reported by the status endpoint.
+const (
+	BudgetOK       = "ok"
+	BudgetWarning  = "warning"
+	BudgetExceeded = "exceeded"
+)
+
+// Budget caps the monthly spend of a user, overall, on one service or on
+// the catalog services of one category.
+type Budget struct {
+	ID          int       `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ServiceName *string   `json:"service_name,omitempty"`
+	Category    *string   `json:"category,omitempty"`
+	Amount      int       `json:"amount"`
+	Currency    string    `json:"currency" example:"RUB"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BudgetRequest creates or replaces a budget. At most one of service_name
+// and category may be set; without either it covers every subscription of
+// the user. Currency defaults to the configured currency.
+type BudgetRequest struct {
+	ServiceName *string `json:"service_name,omitempty" binding:"omitempty,max=100" maxLength:"100"`
+	Category    *string `json:"category,omitempty" binding:"omitempty,max=100" maxLength:"100"`
+	Amount      int     `json:"amount" binding:"required,min=1,max=100000000" minimum:"1"`
+	Currency    string  `json:"currency,omitempty" binding:"omitempty,currency" example:"RUB"`
+}
+
+// BudgetAlert records that the spend of a month reached Threshold percent
+// of a budget.
+type BudgetAlert struct {
+	ID        int64     `json:"id"`
+	BudgetID  int       `json:"budget_id"`
+	Month     MonthDate `json:"month" swaggertype:"string" example:"07-2025"`
+	Threshold int       `json:"threshold" example:"80"`
+	Spend     int64     `json:"spend"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BudgetStatus is the spend of one month against a budget.
+type BudgetStatus struct {
+	Budget  Budget        `json:"budget"`
+	Spend   int64         `json:"spend"`
+	Percent float64       `json:"percent" example:"85.5"`
+	Status  string        `json:"status" enums:"ok,warning,exceeded"`
+	Alerts  []BudgetAlert `json:"alerts"`
+}
+
+type BudgetStatusResponse struct {
+	UserID  uuid.UUID      `json:"user_id"`
+	Month   MonthDate      `json:"month" swaggertype:"string" example:"07-2025"`
+	Budgets []BudgetStatus `json:"budgets"`
+}
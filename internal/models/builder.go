@@ -1,39 +1,125 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
 
 type QueryBuilderInterface interface {
-	WithStartDate(startDate *string) *QueryBuilder
-	WithEndDate(endDate *string) *QueryBuilder
+	WithStartDate(startDate *MonthDate) *QueryBuilder
+	WithEndDate(endDate *MonthDate) *QueryBuilder
 	WithUserId(userId *string) *QueryBuilder
 	WithServiceName(serviceName *string) *QueryBuilder
-	WithName(name *string) *QueryBuilder
-	BuildQuery() string
+	WithCategory(category *string) *QueryBuilder
+	WithPlanID(planID *int) *QueryBuilder
+	WithMinPrice(minPrice *int) *QueryBuilder
+	WithMaxPrice(maxPrice *int) *QueryBuilder
+	WithActiveFrom(from *MonthDate) *QueryBuilder
+	WithActiveTo(to *MonthDate) *QueryBuilder
+	WithDeleted(deleted bool) *QueryBuilder
+	WithAsOf(asOf *time.Time) *QueryBuilder
+	WithID(id int) *QueryBuilder
+	WithIDs(ids []int) *QueryBuilder
+	WithMember(userId *string) *QueryBuilder
+	WithShared() *QueryBuilder
+	WithFilter(filter SubscriptionFilter) *QueryBuilder
+	WithCursor(sortBy string, order string, cursor *Cursor) *QueryBuilder
+	OrderBy(sortBy string, order string) *QueryBuilder
+	WithLimit(limit int) *QueryBuilder
+	WithOffset(offset int) *QueryBuilder
+	BuildQuery() (string, []interface{})
+}
+
+var _ QueryBuilderInterface = (*QueryBuilder)(nil)
+
+// SubscriptionColumns lists the columns read into a Subscription, in scan
+// order.
+const SubscriptionColumns = "id, service_name, service_id, plan_id, price, price_overridden, currency, user_id, start_date, end_date, billing_period, interval_count, trial_end, discounts, version, updated_at, deleted_at"
+
+const (
+	listQuery  = "SELECT " + SubscriptionColumns + " FROM subscriptions WHERE 1=1"
+	countQuery = "SELECT COUNT(*) FROM subscriptions WHERE 1=1"
+)
+
+// historyDefaults fills in columns added after a history entry was
+// written, with the values the migration that added them backfilled. The
+// currency has no default; migration 016 stores it in older entries.
+const historyDefaults = `'{"billing_period": "month", "interval_count": 1, "price_overridden": false, "discounts": []}'::jsonb`
+
+// asOfSource rebuilds the subscriptions table as it was at a moment from
+// the latest history entry of every subscription. Purged subscriptions
+// have no after state and drop out. The whole entry is kept as snapshot,
+// whose members and catalog data the filters read.
+const asOfSource = `(
+	SELECT (jsonb_populate_record(NULL::subscriptions, ` + historyDefaults + ` || h.after)).*, h.after AS snapshot
+	FROM (
+		SELECT DISTINCT ON (subscription_id) after
+		FROM subscription_history
+		WHERE changed_at <= %s
+		ORDER BY subscription_id, changed_at DESC, id DESC
+	) h
+	WHERE h.after IS NOT NULL
+) AS subscriptions`
+
+// sortExpressions maps the sortable API columns to SQL expressions.
+// Open-ended subscriptions sort after every dated one.
+var sortExpressions = map[string]string{
+	"id":           "id",
+	"service_name": "service_name",
+	"price":        "price",
+	"user_id":      "user_id",
+	"start_date":   "start_date",
+	"end_date":     "COALESCE(end_date, 'infinity'::date)",
+}
+
+// IsSortColumn reports whether column can be used as sort_by.
+func IsSortColumn(column string) bool {
+	_, ok := sortExpressions[column]
+	return ok
 }
 
 type QueryBuilder struct {
 	Query       string
 	placeHolder int
 	Args        []interface{}
+	// asOf is set once the query reads from the history.
+	asOf bool
+}
+
+func NewListQueryBuilder() *QueryBuilder {
+	return newQueryBuilder(listQuery)
+}
+
+func NewCountQueryBuilder() *QueryBuilder {
+	return newQueryBuilder(countQuery)
 }
 
-func NewQueryBuilder() *QueryBuilder {
+func newQueryBuilder(query string) *QueryBuilder {
 	return &QueryBuilder{
-		Query: "SELECT COALESCE(SUM(price), 0) FROM subsciptions WHERE 1=1",
+		Query: query,
 		Args:  []interface{}{},
 	}
 }
 
-func (builder *QueryBuilder) WithStartDate(startDate *string) *QueryBuilder {
-	if startDate != nil && *startDate != "" {
+func (builder *QueryBuilder) nextPlaceholder(arg interface{}) string {
+	builder.placeHolder++
+	builder.Args = append(builder.Args, arg)
+	return fmt.Sprintf("$%d", builder.placeHolder)
+}
+
+func (builder *QueryBuilder) WithStartDate(startDate *MonthDate) *QueryBuilder {
+	if startDate != nil {
 		builder.placeHolder++
 		builder.Query = builder.Query + fmt.Sprintf(" AND start_date >= $%d", builder.placeHolder)
 		builder.Args = append(builder.Args, *startDate)
 	}
 	return builder
 }
-func (builder *QueryBuilder) WithEndDate(endDate *string) *QueryBuilder {
-	if endDate != nil && *endDate != "" {
+func (builder *QueryBuilder) WithEndDate(endDate *MonthDate) *QueryBuilder {
+	if endDate != nil {
 		builder.placeHolder++
 		builder.Query = builder.Query + fmt.Sprintf(" AND end_date <= $%d", builder.placeHolder)
 		builder.Args = append(builder.Args, *endDate)
@@ -50,11 +136,192 @@ func (builder *QueryBuilder) WithUserId(userId *string) *QueryBuilder {
 	return builder
 }
 
+// WithServiceName keeps subscriptions named serviceName and those linked
+// to the catalog service serviceName is a name or alias of. As of a moment
+// the names the service had then are used; history entries recorded
+// before snapshots carried them are matched against the current catalog.
 func (builder *QueryBuilder) WithServiceName(serviceName *string) *QueryBuilder {
 	if serviceName != nil && *serviceName != "" {
-		builder.placeHolder++
-		builder.Query = builder.Query + fmt.Sprintf(" AND service_name = $%d", builder.placeHolder)
-		builder.Args = append(builder.Args, *serviceName)
+		name := builder.nextPlaceholder(*serviceName)
+		key := builder.nextPlaceholder(ServiceKey(*serviceName))
+		current := fmt.Sprintf("service_id IN (SELECT service_id FROM service_names WHERE key = %s)", key)
+		if builder.asOf {
+			current = fmt.Sprintf("snapshot->'service_keys' ? %s OR (NOT snapshot ? 'service_keys' AND %s)", key, current)
+		}
+		builder.Query = builder.Query + fmt.Sprintf(" AND (service_name = %s OR %s)", name, current)
+	}
+	return builder
+}
+
+// WithCategory keeps subscriptions linked to a catalog service of category.
+// As of a moment the category the service had then is used, like the
+// names in WithServiceName.
+func (builder *QueryBuilder) WithCategory(category *string) *QueryBuilder {
+	if category != nil && *category != "" {
+		p := builder.nextPlaceholder(*category)
+		current := fmt.Sprintf("service_id IN (SELECT id FROM services WHERE category = %s)", p)
+		if builder.asOf {
+			current = fmt.Sprintf("(snapshot->>'category' = %s OR (NOT snapshot ? 'category' AND %s))", p, current)
+		}
+		builder.Query = builder.Query + " AND " + current
+	}
+	return builder
+}
+
+func (builder *QueryBuilder) WithPlanID(planID *int) *QueryBuilder {
+	if planID != nil {
+		builder.Query = builder.Query + " AND plan_id = " + builder.nextPlaceholder(*planID)
+	}
+	return builder
+}
+
+func (builder *QueryBuilder) WithMinPrice(minPrice *int) *QueryBuilder {
+	if minPrice != nil {
+		builder.Query = builder.Query + " AND price >= " + builder.nextPlaceholder(*minPrice)
+	}
+	return builder
+}
+
+func (builder *QueryBuilder) WithMaxPrice(maxPrice *int) *QueryBuilder {
+	if maxPrice != nil {
+		builder.Query = builder.Query + " AND price <= " + builder.nextPlaceholder(*maxPrice)
+	}
+	return builder
+}
+
+// WithActiveFrom keeps subscriptions that have not ended before from.
+func (builder *QueryBuilder) WithActiveFrom(from *MonthDate) *QueryBuilder {
+	if from != nil {
+		builder.Query = builder.Query + fmt.Sprintf(" AND (end_date IS NULL OR end_date >= %s)", builder.nextPlaceholder(*from))
+	}
+	return builder
+}
+
+// WithActiveTo keeps subscriptions that have started by to.
+func (builder *QueryBuilder) WithActiveTo(to *MonthDate) *QueryBuilder {
+	if to != nil {
+		builder.Query = builder.Query + " AND start_date <= " + builder.nextPlaceholder(*to)
+	}
+	return builder
+}
+
+// WithDeleted selects soft-deleted rows when deleted is true and live rows
+// otherwise.
+func (builder *QueryBuilder) WithDeleted(deleted bool) *QueryBuilder {
+	if deleted {
+		builder.Query = builder.Query + " AND deleted_at IS NOT NULL"
+	} else {
+		builder.Query = builder.Query + " AND deleted_at IS NULL"
+	}
+	return builder
+}
+
+// WithAsOf reads subscriptions as they were at asOf instead of their
+// current state.
+func (builder *QueryBuilder) WithAsOf(asOf *time.Time) *QueryBuilder {
+	if asOf != nil {
+		source := fmt.Sprintf(asOfSource, builder.nextPlaceholder(*asOf))
+		builder.Query = strings.Replace(builder.Query, " FROM subscriptions ", " FROM "+source+" ", 1)
+		builder.asOf = true
+	}
+	return builder
+}
+
+func (builder *QueryBuilder) WithID(id int) *QueryBuilder {
+	builder.Query = builder.Query + " AND id = " + builder.nextPlaceholder(id)
+	return builder
+}
+
+// WithIDs keeps the subscriptions with one of ids. An empty list keeps
+// every subscription.
+func (builder *QueryBuilder) WithIDs(ids []int) *QueryBuilder {
+	if len(ids) > 0 {
+		builder.Query = builder.Query + " AND id = ANY(" + builder.nextPlaceholder(pq.Array(ids)) + ")"
+	}
+	return builder
+}
+
+// WithMember keeps the subscriptions userId owns or is a member of. As of
+// a moment the members then are used.
+func (builder *QueryBuilder) WithMember(userId *string) *QueryBuilder {
+	if userId != nil && *userId != "" {
+		p := builder.nextPlaceholder(*userId)
+		member := fmt.Sprintf("id IN (SELECT subscription_id FROM subscription_members WHERE user_id = %s)", p)
+		if builder.asOf {
+			member = fmt.Sprintf("snapshot->'members' @> jsonb_build_array(jsonb_build_object('user_id', %s::uuid))", p)
+		}
+		builder.Query = builder.Query + fmt.Sprintf(" AND (user_id = %s OR %s)", p, member)
+	}
+	return builder
+}
+
+// WithShared keeps the subscriptions that have members, as of a moment
+// those that had members then.
+func (builder *QueryBuilder) WithShared() *QueryBuilder {
+	if builder.asOf {
+		builder.Query = builder.Query + " AND COALESCE(snapshot->'members', '[]') <> '[]'"
+		return builder
+	}
+	builder.Query = builder.Query + " AND id IN (SELECT subscription_id FROM subscription_members)"
+	return builder
+}
+
+func (builder *QueryBuilder) WithFilter(filter SubscriptionFilter) *QueryBuilder {
+	builder.WithAsOf(filter.AsOf).WithDeleted(filter.Deleted)
+	if filter.Shared {
+		builder.WithMember(filter.UserID)
+	} else {
+		builder.WithUserId(filter.UserID)
+	}
+	return builder.
+		WithServiceName(filter.ServiceName).
+		WithCategory(filter.Category).
+		WithPlanID(filter.PlanID).
+		WithMinPrice(filter.MinPrice).
+		WithMaxPrice(filter.MaxPrice).
+		WithActiveFrom(filter.ActiveFrom).
+		WithActiveTo(filter.ActiveTo)
+}
+
+// WithCursor continues a keyset scan after the row the cursor points at.
+// Rows are compared by the sort expression first and by id to break ties.
+func (builder *QueryBuilder) WithCursor(sortBy string, order string, cursor *Cursor) *QueryBuilder {
+	if cursor == nil {
+		return builder
+	}
+	op := ">"
+	if order == SortDesc {
+		op = "<"
+	}
+	value := builder.nextPlaceholder(cursor.Value)
+	id := builder.nextPlaceholder(cursor.ID)
+	builder.Query = builder.Query + fmt.Sprintf(" AND (%s, id) %s (%s, %s)", sortExpressions[sortBy], op, value, id)
+	return builder
+}
+
+func (builder *QueryBuilder) OrderBy(sortBy string, order string) *QueryBuilder {
+	expr, ok := sortExpressions[sortBy]
+	if !ok {
+		expr = "id"
+	}
+	direction := "ASC"
+	if order == SortDesc {
+		direction = "DESC"
+	}
+	builder.Query = builder.Query + fmt.Sprintf(" ORDER BY %s %s, id %s", expr, direction, direction)
+	return builder
+}
+
+func (builder *QueryBuilder) WithLimit(limit int) *QueryBuilder {
+	if limit > 0 {
+		builder.Query = builder.Query + " LIMIT " + builder.nextPlaceholder(limit)
+	}
+	return builder
+}
+
+func (builder *QueryBuilder) WithOffset(offset int) *QueryBuilder {
+	if offset > 0 {
+		builder.Query = builder.Query + " OFFSET " + builder.nextPlaceholder(offset)
 	}
 	return builder
 }
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestListQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(*QueryBuilder) *QueryBuilder
+		where string
+		args  []interface{}
+	}{
+		{
+			name:  "no filter",
+			build: func(b *QueryBuilder) *QueryBuilder { return b.WithFilter(SubscriptionFilter{}) },
+			where: " AND deleted_at IS NULL",
+			args:  []interface{}{},
+		},
+		{
+			name: "filters take placeholders in order",
+			build: func(b *QueryBuilder) *QueryBuilder {
+				return b.WithFilter(SubscriptionFilter{UserID: stringPtr("u"), MinPrice: intPtr(100), MaxPrice: intPtr(500)})
+			},
+			where: " AND deleted_at IS NULL AND user_id = $1 AND price >= $2 AND price <= $3",
+			args:  []interface{}{"u", 100, 500},
+		},
+		{
+			name:  "empty user is ignored",
+			build: func(b *QueryBuilder) *QueryBuilder { return b.WithUserId(stringPtr("")) },
+			where: "",
+			args:  []interface{}{},
+		},
+		{
+			name: "page by offset",
+			build: func(b *QueryBuilder) *QueryBuilder {
+				return b.WithUserId(stringPtr("u")).OrderBy("price", SortDesc).WithLimit(20).WithOffset(40)
+			},
+			where: " AND user_id = $1 ORDER BY price DESC, id DESC LIMIT $2 OFFSET $3",
+			args:  []interface{}{"u", 20, 40},
+		},
+		{
+			name:  "first page has no offset",
+			build: func(b *QueryBuilder) *QueryBuilder { return b.OrderBy("id", SortAsc).WithLimit(20).WithOffset(0) },
+			where: " ORDER BY id ASC, id ASC LIMIT $1",
+			args:  []interface{}{20},
+		},
+		{
+			name:  "unknown sort column falls back to id",
+			build: func(b *QueryBuilder) *QueryBuilder { return b.OrderBy("price; DROP TABLE subscriptions", SortAsc) },
+			where: " ORDER BY id ASC, id ASC",
+			args:  []interface{}{},
+		},
+		{
+			name: "cursor ascending",
+			build: func(b *QueryBuilder) *QueryBuilder {
+				return b.WithCursor("service_name", SortAsc, &Cursor{Value: "Netflix", ID: 7}).OrderBy("service_name", SortAsc)
+			},
+			where: " AND (service_name, id) > ($1, $2) ORDER BY service_name ASC, id ASC",
+			args:  []interface{}{"Netflix", 7},
+		},
+		{
+			name: "cursor descending on end date",
+			build: func(b *QueryBuilder) *QueryBuilder {
+				return b.WithCursor("end_date", SortDesc, &Cursor{Value: "infinity", ID: 3})
+			},
+			where: " AND (COALESCE(end_date, 'infinity'::date), id) < ($1, $2)",
+			args:  []interface{}{"infinity", 3},
+		},
+		{
+			name:  "no cursor",
+			build: func(b *QueryBuilder) *QueryBuilder { return b.WithCursor("id", SortAsc, nil) },
+			where: "",
+			args:  []interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := tt.build(NewListQueryBuilder()).BuildQuery()
+			if want := listQuery + tt.where; query != want {
+				t.Errorf("query = %q, want %q", query, want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestCountQuery(t *testing.T) {
+	query, args := NewCountQueryBuilder().WithFilter(SubscriptionFilter{PlanID: intPtr(3)}).BuildQuery()
+	if !strings.HasPrefix(query, "SELECT COUNT(*) FROM subscriptions WHERE 1=1") {
+		t.Errorf("query = %q", query)
+	}
+	if !strings.HasSuffix(query, " AND plan_id = $1") || !reflect.DeepEqual(args, []interface{}{3}) {
+		t.Errorf("query = %q, args = %v", query, args)
+	}
+}
+
+func TestMemberFilters(t *testing.T) {
+	query, args := NewListQueryBuilder().
+		WithFilter(SubscriptionFilter{UserID: stringPtr("u"), Shared: true, ServiceName: stringPtr("Netflix "), Category: stringPtr("video")}).
+		WithShared().
+		BuildQuery()
+	want := listQuery + " AND deleted_at IS NULL" +
+		" AND (user_id = $1 OR id IN (SELECT subscription_id FROM subscription_members WHERE user_id = $1))" +
+		" AND (service_name = $2 OR service_id IN (SELECT service_id FROM service_names WHERE key = $3))" +
+		" AND service_id IN (SELECT id FROM services WHERE category = $4)" +
+		" AND id IN (SELECT subscription_id FROM subscription_members)"
+	if query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+	if wantArgs := []interface{}{"u", "Netflix ", "netflix", "video"}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+}
+
+func TestAsOfQuery(t *testing.T) {
+	asOf := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	query, args := NewListQueryBuilder().
+		WithFilter(SubscriptionFilter{AsOf: &asOf, UserID: stringPtr("u"), Shared: true, ServiceName: stringPtr("Netflix"), Category: stringPtr("video")}).
+		WithShared().
+		BuildQuery()
+
+	source, where, ok := strings.Cut(query, ") AS subscriptions WHERE 1=1")
+	if !ok {
+		t.Fatalf("query does not read from the history: %q", query)
+	}
+	if !strings.HasPrefix(source, "SELECT "+SubscriptionColumns+" FROM (") ||
+		!strings.Contains(source, "h.after AS snapshot") ||
+		!strings.Contains(source, "FROM subscription_history") ||
+		!strings.Contains(source, "WHERE changed_at <= $1") {
+		t.Errorf("source = %q", source)
+	}
+	want := " AND deleted_at IS NULL" +
+		" AND (user_id = $2 OR snapshot->'members' @> jsonb_build_array(jsonb_build_object('user_id', $2::uuid)))" +
+		" AND (service_name = $3 OR snapshot->'service_keys' ? $4 OR (NOT snapshot ? 'service_keys' AND service_id IN (SELECT service_id FROM service_names WHERE key = $4)))" +
+		" AND (snapshot->>'category' = $5 OR (NOT snapshot ? 'category' AND service_id IN (SELECT id FROM services WHERE category = $5)))" +
+		" AND COALESCE(snapshot->'members', '[]') <> '[]'"
+	if where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	if strings.Contains(query, "subscription_members") {
+		t.Error("as_of query reads the current members")
+	}
+	if wantArgs := []interface{}{asOf, "u", "Netflix", "netflix", "video"}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+
+	count, _ := NewCountQueryBuilder().WithAsOf(&asOf).WithDeleted(false).BuildQuery()
+	if !strings.HasPrefix(count, "SELECT COUNT(*) FROM (") || !strings.HasSuffix(count, ") AS subscriptions WHERE 1=1 AND deleted_at IS NULL") {
+		t.Errorf("count query = %q", count)
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// Cursor points at the last row of a page for keyset pagination.
+// Value holds the sort column of that row in its SQL text form.
+type Cursor struct {
+	SortBy string `json:"s"`
+	Order  string `json:"o"`
+	Value  string `json:"v"`
+	ID     int    `json:"id"`
+}
+
+func NewCursor(sortBy string, order string, sub *Subscription) *Cursor {
+	return &Cursor{SortBy: sortBy, Order: order, Value: sortValue(sortBy, sub), ID: sub.ID}
+}
+
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	if !IsSortColumn(c.SortBy) {
+		return nil, fmt.Errorf("malformed cursor: unknown sort column %q", c.SortBy)
+	}
+	return &c, nil
+}
+
+func sortValue(sortBy string, sub *Subscription) string {
+	switch sortBy {
+	case "service_name":
+		return sub.ServiceName
+	case "price":
+		return strconv.Itoa(sub.Price)
+	case "user_id":
+		return sub.UserID.String()
+	case "start_date":
+		return sub.StartDate.Format("2006-01-02")
+	case "end_date":
+		if sub.EndDate == nil {
+			return "infinity"
+		}
+		return sub.EndDate.Format("2006-01-02")
+	default:
+		return strconv.Itoa(sub.ID)
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	start, _ := ParseMonthDate("07-2025")
+	end, _ := ParseMonthDate("12-2025")
+	sub := &Subscription{
+		ID:          42,
+		ServiceName: "Yandex Plus",
+		Price:       400,
+		UserID:      uuid.MustParse("60601fee-2bf1-4721-ae6f-7636e79a0cba"),
+		StartDate:   start,
+		EndDate:     &end,
+	}
+	openEnded := *sub
+	openEnded.EndDate = nil
+
+	tests := []struct {
+		name  string
+		sort  string
+		sub   *Subscription
+		value string
+	}{
+		{"id", "id", sub, "42"},
+		{"service name", "service_name", sub, "Yandex Plus"},
+		{"price", "price", sub, "400"},
+		{"user", "user_id", sub, "60601fee-2bf1-4721-ae6f-7636e79a0cba"},
+		{"start date", "start_date", sub, "2025-07-01"},
+		{"end date", "end_date", sub, "2025-12-01"},
+		{"open-ended sorts last", "end_date", &openEnded, "infinity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, order := range []string{SortAsc, SortDesc} {
+				c, err := DecodeCursor(NewCursor(tt.sort, order, tt.sub).Encode())
+				if err != nil {
+					t.Fatalf("DecodeCursor: %v", err)
+				}
+				want := Cursor{SortBy: tt.sort, Order: order, Value: tt.value, ID: 42}
+				if *c != want {
+					t.Errorf("got %+v, want %+v", *c, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsMalformed(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"id","o":"asc","v":"1","id":1}`))},
+		{"not json", encode("id:1")},
+		{"wrong types", encode(`{"s":"id","o":"asc","v":"1","id":"1"}`)},
+		{"unknown sort column", encode(`{"s":"password","o":"asc","v":"1","id":1}`)},
+		{"sql in sort column", encode(`{"s":"id; DROP TABLE subscriptions","o":"asc","v":"1","id":1}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := DecodeCursor(tt.cursor); err == nil {
+				t.Errorf("DecodeCursor(%q) = %+v, want error", tt.cursor, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Date is a calendar day. It is exchanged as "YYYY-MM-DD" and stored in
+// DATE columns.
+type Date struct {
+	time.Time
+}
+
+// DateOf returns the day containing t, in UTC.
+func DateOf(t time.Time) Date {
+	y, m, d := t.UTC().Date()
+	return Date{time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+}
+
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(DayLayout, s)
+	if err != nil {
+		return Date{}, fmt.Errorf("%s is not a date, expected YYYY-MM-DD", strconv.Quote(s))
+	}
+	return DateOf(t), nil
+}
+
+func (d Date) String() string {
+	return d.Format(DayLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%s is not a date, expected YYYY-MM-DD", data)
+	}
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*d = DateOf(v)
+	case string:
+		parsed, err := ParseDate(v)
+		if err != nil {
+			return fmt.Errorf("cannot scan into Date: %w", err)
+		}
+		*d = parsed
+	case []byte:
+		return d.Scan(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Discount kinds. A percent discount takes Value percent off a charge, a
+// fixed one takes Value off it in the currency of the subscription.
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Discount reduces the charges of Months consecutive months starting with
+// From, which defaults to the first paid month of the subscription.
+type Discount struct {
+	Kind   string     `json:"kind" binding:"required,oneof=percent fixed" enums:"percent,fixed"`
+	Value  float64    `json:"value" example:"20"`
+	Months int        `json:"months" binding:"required,min=1,max=120" minimum:"1" maximum:"120"`
+	From   *MonthDate `json:"from,omitempty" swaggertype:"string" example:"07-2025"`
+}
+
+// Discounts is stored as a JSON array.
+type Discounts []Discount
+
+func (d *Discounts) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*d = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Discounts", src)
+	}
+	var list []Discount
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("cannot scan discounts: %w", err)
+	}
+	if len(list) == 0 {
+		list = nil
+	}
+	*d = list
+	return nil
+}
+
+func (d Discounts) Value() (driver.Value, error) {
+	if d == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]Discount(d))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Operations recorded in the subscription history.
+const (
+	HistoryCreate  = "create"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+	HistoryPurge   = "purge"
+)
+
+// SystemActor is recorded for changes made by background jobs.
+const SystemActor = "system"
+
+// HistoryEntry is one change of a subscription. Before and After hold the
+// whole row with its price_schedule and members as JSON, along with the
+// category and service_keys of its catalog service; Before is null on
+// create and After is null on purge.
+type HistoryEntry struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	Operation      string          `json:"operation" example:"update"`
+	Before         json.RawMessage `json:"before" swaggertype:"object"`
+	After          json.RawMessage `json:"after" swaggertype:"object"`
+	Actor          string          `json:"actor" example:"alice"`
+	ChangedAt      time.Time       `json:"changed_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "encoding/json"
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key. A
+// StatusCode of zero means the first request is still being processed.
+// Header holds the headers sent with Response.
+type IdempotencyRecord struct {
+	Key         string              `json:"key"`
+	RequestHash string              `json:"request_hash"`
+	StatusCode  int                 `json:"status_code,omitempty"`
+	Response    json.RawMessage     `json:"response,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+}
+
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// Member shares the cost of a subscription paid by its owner, the user_id
+// of the subscription. A member pays either Amount of every charge at the
+// full price, scaled like the charge when it is amortized or discounted,
+// or Weight parts of what is left after fixed amounts. The owner pays the
+// rest with weight 1 unless listed as a member.
+type Member struct {
+	UserID uuid.UUID `json:"user_id"`
+	Weight int       `json:"weight,omitempty"`
+	Amount int       `json:"amount,omitempty"`
+}
+
+// MemberRequest is one member in MembersRequest. Exactly one of weight and
+// amount must be set.
+type MemberRequest struct {
+	UserID string `json:"user_id" binding:"required,uuid" example:"60601fee-2bf1-4721-ae6f-7636e79a0cba"`
+	Weight int    `json:"weight,omitempty" binding:"omitempty,min=1,max=1000" minimum:"1" maximum:"1000"`
+	Amount int    `json:"amount,omitempty" binding:"omitempty,min=1,max=1000000" minimum:"1"`
+}
+
+// MembersRequest replaces the members of a subscription. An empty list
+// makes the owner pay in full again.
+type MembersRequest struct {
+	Members []MemberRequest `json:"members"`
+}
+
+// Debt is what From owes To for the shares of subscriptions To paid.
+type Debt struct {
+	From   uuid.UUID `json:"from"`
+	To     uuid.UUID `json:"to"`
+	Amount int64     `json:"amount"`
+}
+
+// SettlementResponse lists who owes whom for the shared subscriptions in
+// the months StartDate..EndDate; without StartDate from the start of each
+// subscription. Debts between two users are netted.
+type SettlementResponse struct {
+	StartDate  *MonthDate     `json:"start_date,omitempty" swaggertype:"string" example:"01-2025"`
+	EndDate    MonthDate      `json:"end_date" swaggertype:"string" example:"12-2025"`
+	Allocation string         `json:"allocation" example:"renewal"`
+	Currency   string         `json:"currency" example:"RUB"`
+	Debts      []Debt         `json:"debts"`
+	Rates      []ExchangeRate `json:"rates"`
+}
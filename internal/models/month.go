@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MonthDateLayout is the canonical API format of a MonthDate.
+const MonthDateLayout = "01-2006"
+
+// isoMonthLayouts are accepted in addition to MonthDateLayout when ISO
+// dates are enabled. Days and times are truncated to the month.
+var isoMonthLayouts = []string{"2006-01", "2006-01-02", time.RFC3339}
+
+var acceptISODates bool
+
+// SetAcceptISODates makes ParseMonthDate also accept YYYY-MM, YYYY-MM-DD
+// and RFC 3339 input.
+func SetAcceptISODates(accept bool) {
+	acceptISODates = accept
+}
+
+// MonthDate is a calendar month. It is exchanged as "MM-YYYY" and stored
+// as the first day of the month in DATE columns.
+type MonthDate struct {
+	time.Time
+}
+
+// DateParseError is returned for input that is not a valid month date.
+type DateParseError struct {
+	Value string
+}
+
+func (e *DateParseError) Error() string {
+	if acceptISODates {
+		return fmt.Sprintf("%s is not a month date, expected MM-YYYY or YYYY-MM-DD", e.Value)
+	}
+	return fmt.Sprintf("%s is not a month date, expected MM-YYYY", e.Value)
+}
+
+// MonthOf returns the month containing t.
+func MonthOf(t time.Time) MonthDate {
+	return MonthDate{time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func ParseMonthDate(s string) (MonthDate, error) {
+	if t, err := time.Parse(MonthDateLayout, s); err == nil {
+		return MonthOf(t), nil
+	}
+	if acceptISODates {
+		for _, layout := range isoMonthLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return MonthOf(t), nil
+			}
+		}
+	}
+	return MonthDate{}, &DateParseError{Value: strconv.Quote(s)}
+}
+
+// AddMonths returns the month n months after m.
+func (m MonthDate) AddMonths(n int) MonthDate {
+	return MonthDate{m.Time.AddDate(0, n, 0)}
+}
+
+// Index numbers months consecutively, so that the distance between two
+// months is the difference of their indexes.
+func (m MonthDate) Index() int {
+	return m.Year()*12 + int(m.Month()) - 1
+}
+
+func (m MonthDate) String() string {
+	return m.Format(MonthDateLayout)
+}
+
+func (m MonthDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *MonthDate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return &DateParseError{Value: string(data)}
+	}
+	parsed, err := ParseMonthDate(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m *MonthDate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*m = MonthOf(v)
+	case string:
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return fmt.Errorf("cannot scan %q into MonthDate: %w", v, err)
+		}
+		*m = MonthOf(t)
+	case []byte:
+		return m.Scan(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into MonthDate", src)
+	}
+	return nil
+}
+
+func (m MonthDate) Value() (driver.Value, error) {
+	return m.Format("2006-01-02"), nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Service is a catalog entry. Subscriptions whose name matches Name or one
+// of the Aliases, ignoring case and spacing, are linked to it and stored
+// under Name.
+type Service struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name" example:"Yandex Plus"`
+	Aliases      []string  `json:"aliases"`
+	Category     *string   `json:"category,omitempty" example:"streaming"`
+	DefaultPrice *int      `json:"default_price,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ServiceRequest creates or replaces a catalog entry. DefaultPrice is
+// used for subscriptions created without a price.
+type ServiceRequest struct {
+	Name         string   `json:"name" binding:"required,max=100" maxLength:"100" example:"Yandex Plus"`
+	Aliases      []string `json:"aliases,omitempty" example:"Яндекс Плюс"`
+	Category     *string  `json:"category,omitempty" binding:"omitempty,max=100" maxLength:"100" example:"streaming"`
+	DefaultPrice *int     `json:"default_price,omitempty" binding:"omitempty,min=1,max=1000000" minimum:"1" maximum:"1000000"`
+}
+
+// ServiceKey is the form names are matched against the catalog in: lower
+// case with runs of white space collapsed to one space.
+func ServiceKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// BackfillReport summarises a run of the service backfill.
+type BackfillReport struct {
+	ServicesCreated     int      `json:"services_created"`
+	SubscriptionsLinked int      `json:"subscriptions_linked"`
+	Unmatched           []string `json:"unmatched"`
+}
+
+// Plan is a price list entry of a catalog service. Subscriptions on a plan
+// are billed with its currency and period and, unless they override it,
+// its price.
+type Plan struct {
+	ID            int       `json:"id"`
+	ServiceID     int       `json:"service_id"`
+	Name          string    `json:"name" example:"Family"`
+	Price         int       `json:"price"`
+	Currency      string    `json:"currency" example:"RUB"`
+	BillingPeriod string    `json:"billing_period" enums:"week,month,quarter,year"`
+	IntervalCount int       `json:"interval_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PlanRequest creates or replaces a plan. Currency defaults to the
+// configured currency, BillingPeriod to month and IntervalCount to 1.
+type PlanRequest struct {
+	Name          string `json:"name" binding:"required,max=100" maxLength:"100" example:"Family"`
+	Price         int    `json:"price" binding:"required,min=1,max=1000000" minimum:"1" maximum:"1000000"`
+	Currency      string `json:"currency,omitempty" binding:"omitempty,currency" example:"RUB"`
+	BillingPeriod string `json:"billing_period,omitempty" binding:"omitempty,oneof=week month quarter year" enums:"week,month,quarter,year"`
+	IntervalCount int    `json:"interval_count,omitempty" binding:"omitempty,min=1,max=100" minimum:"1" maximum:"100"`
+}
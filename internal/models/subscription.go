@@ -1,39 +1,365 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Billing periods. The price of a subscription is charged once every
+// IntervalCount periods.
+const (
+	BillingWeek    = "week"
+	BillingMonth   = "month"
+	BillingQuarter = "quarter"
+	BillingYear    = "year"
+)
+
 type Subscription struct {
-	ID          int        `json:"id" db:"id"`
-	ServiceName string     `json:"service_name" db:"service_name"`
-	Price       int        `json:"price" db:"price"`
-	UserID      uuid.UUID  `json:"user_id" db:"user_id"`
-	StartDate   time.Time  `json:"start_date" db:"start_date"`
-	EndDate     *time.Time `json:"end_date,omitempty" db:"end_date"`
+	ID            int        `json:"id" db:"id"`
+	ServiceName   string     `json:"service_name" db:"service_name" binding:"required,max=100"`
+	ServiceID     *int       `json:"service_id,omitempty" db:"service_id"`
+	PlanID        *int       `json:"plan_id,omitempty" db:"plan_id"`
+	Price         int        `json:"price" db:"price" binding:"required,min=1,max=1000000"`
+	Currency      string     `json:"currency" db:"currency" binding:"required,currency" example:"RUB"`
+	UserID        uuid.UUID  `json:"user_id" db:"user_id" binding:"required"`
+	StartDate     MonthDate  `json:"start_date" db:"start_date" binding:"required" swaggertype:"string" example:"07-2025"`
+	EndDate       *MonthDate `json:"end_date,omitempty" db:"end_date" binding:"omitempty,gtefield=StartDate" swaggertype:"string" example:"12-2025"`
+	BillingPeriod string     `json:"billing_period" db:"billing_period" binding:"required,oneof=week month quarter year" enums:"week,month,quarter,year"`
+	IntervalCount int        `json:"interval_count" db:"interval_count" binding:"required,min=1,max=100"`
+	TrialEnd      *Date      `json:"trial_end,omitempty" db:"trial_end" swaggertype:"string" example:"2025-07-14"`
+	Discounts     Discounts  `json:"discounts,omitempty" db:"discounts"`
+	Version       int        `json:"version" db:"version"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+
+	// PriceOverridden is set when Price was given instead of taken from
+	// the plan; the price then no longer follows the plan.
+	PriceOverridden bool `json:"price_overridden,omitempty" db:"price_overridden"`
+
+	// PriceSchedule lists the scheduled prices that replace Price from
+	// their month on, oldest first. CurrentPrice is the price charged in
+	// the current month. Both are read-only.
+	PriceSchedule []PriceChange `json:"price_schedule,omitempty"`
+	CurrentPrice  int           `json:"current_price,omitempty"`
+
+	// Members share the cost with the owner. Read-only here, see
+	// PUT /subscription/{id}/members.
+	Members []Member `json:"members,omitempty"`
+}
+
+// ETag returns the entity tag of the current version of s.
+func (s *Subscription) ETag() string {
+	return fmt.Sprintf("\"%d\"", s.Version)
+}
+
+// PriceOn returns the price charged in month: the latest scheduled price
+// effective by then, or Price before the first one.
+func (s *Subscription) PriceOn(month MonthDate) int {
+	price := s.Price
+	for _, change := range s.PriceSchedule {
+		if change.EffectiveFrom.After(month.Time) {
+			break
+		}
+		price = change.Price
+	}
+	return price
+}
+
+// PriceChange is a price that applies from EffectiveFrom on. Changes
+// scheduled for a plan carry its PlanID and apply to the subscriptions on
+// the plan that do not override the price.
+type PriceChange struct {
+	ID            int64     `json:"id"`
+	Price         int       `json:"price"`
+	EffectiveFrom MonthDate `json:"effective_from" swaggertype:"string" example:"01-2026"`
+	PlanID        *int      `json:"plan_id,omitempty"`
+}
+
+// PriceScheduleRequest schedules a price change from EffectiveFrom on,
+// either to Price or by ChangePercent of the price in effect then. With
+// only PlanID the change is scheduled for the plan; otherwise it is
+// scheduled for every live subscription selected by SubscriptionIDs,
+// ServiceName and PlanID together.
+type PriceScheduleRequest struct {
+	EffectiveFrom   MonthDate `json:"effective_from" binding:"required" swaggertype:"string" example:"01-2026"`
+	Price           *int      `json:"price,omitempty" binding:"omitempty,min=1,max=1000000" minimum:"1" maximum:"1000000"`
+	ChangePercent   *float64  `json:"change_percent,omitempty" example:"10"`
+	SubscriptionIDs []int     `json:"subscription_ids,omitempty"`
+	ServiceName     *string   `json:"service_name,omitempty" binding:"omitempty,max=100" maxLength:"100"`
+	PlanID          *int      `json:"plan_id,omitempty" binding:"omitempty,min=1"`
+}
+
+// PriceScheduleResponse reports the prices a bulk schedule created or
+// replaced.
+type PriceScheduleResponse struct {
+	EffectiveFrom MonthDate     `json:"effective_from" swaggertype:"string" example:"01-2026"`
+	Scheduled     int           `json:"scheduled"`
+	Changes       []PriceChange `json:"changes"`
+	// SubscriptionIDs lists the subscriptions the changes were scheduled
+	// for, in the order of Changes. It is empty for a plan change.
+	SubscriptionIDs []int `json:"subscription_ids"`
 }
+
+// CreateSubscriptionRequest is the body of POST /subscription. Currency
+// defaults to the configured currency, BillingPeriod to month and
+// IntervalCount to 1. Price may be omitted when the service is in the
+// catalog with a default price. With PlanID the service, currency and
+// billing period come from the plan, and so does the price unless given.
 type CreateSubscriptionRequest struct {
-	ServiceName string `json:"service_name" binding:"required"`
-	Price       int    `json:"price" binding:"required,min=1"`
-	UserID      string `json:"user_id" binding:"required,uuid"`
-	StartDate   string `json:"start_date" binding:"required"`
-	EndDate     string `json:"end_date,omitempty"`
+	ServiceName   string     `json:"service_name,omitempty" binding:"omitempty,max=100" maxLength:"100"`
+	PlanID        *int       `json:"plan_id,omitempty" binding:"omitempty,min=1"`
+	Price         int        `json:"price,omitempty" binding:"omitempty,min=1,max=1000000" maximum:"1000000"`
+	Currency      string     `json:"currency,omitempty" binding:"omitempty,currency" example:"RUB"`
+	UserID        string     `json:"user_id" binding:"required,uuid" format:"uuid"`
+	StartDate     MonthDate  `json:"start_date" binding:"required" swaggertype:"string" example:"07-2025"`
+	EndDate       *MonthDate `json:"end_date,omitempty" binding:"omitempty,gtefield=StartDate" swaggertype:"string" example:"12-2025"`
+	BillingPeriod string     `json:"billing_period,omitempty" binding:"omitempty,oneof=week month quarter year" enums:"week,month,quarter,year"`
+	IntervalCount int        `json:"interval_count,omitempty" binding:"omitempty,min=1,max=100" minimum:"1" maximum:"100"`
+	TrialEnd      *Date      `json:"trial_end,omitempty" swaggertype:"string" example:"2025-07-14"`
+	Discounts     []Discount `json:"discounts,omitempty"`
 }
 
 type UpdateSubscriptionRequest struct {
-	UserID      string `json:"user_id,omitempty" `
-	ServiceName string `json:"service_name,omitempty"`
-	Price       *int   `json:"price,omitempty"`
-	StartDate   string `json:"start_date,omitempty"`
-	EndDate     string `json:"end_date,omitempty"`
+	UserID        string     `json:"user_id,omitempty" binding:"omitempty,uuid" format:"uuid"`
+	ServiceName   string     `json:"service_name,omitempty" binding:"omitempty,max=100" maxLength:"100"`
+	PlanID        *int       `json:"plan_id,omitempty" binding:"omitempty,min=1"`
+	Price         *int       `json:"price,omitempty" binding:"omitempty,min=1,max=1000000" minimum:"1" maximum:"1000000"`
+	Currency      string     `json:"currency,omitempty" binding:"omitempty,currency" example:"RUB"`
+	StartDate     *MonthDate `json:"start_date,omitempty" swaggertype:"string" example:"07-2025"`
+	EndDate       *MonthDate `json:"end_date,omitempty" swaggertype:"string" example:"12-2025"`
+	BillingPeriod string     `json:"billing_period,omitempty" binding:"omitempty,oneof=week month quarter year" enums:"week,month,quarter,year"`
+	IntervalCount *int       `json:"interval_count,omitempty" binding:"omitempty,min=1,max=100" minimum:"1" maximum:"100"`
+	TrialEnd      *Date      `json:"trial_end,omitempty" swaggertype:"string" example:"2025-07-14"`
+	// Discounts replaces the discounts when present; an empty list
+	// removes them.
+	Discounts *[]Discount `json:"discounts,omitempty"`
+}
+
+// SubscriptionFilter narrows subscription queries. Nil fields are ignored.
+type SubscriptionFilter struct {
+	UserID      *string
+	ServiceName *string
+	Category    *string
+	PlanID      *int
+	MinPrice    *int
+	MaxPrice    *int
+	ActiveFrom  *MonthDate
+	ActiveTo    *MonthDate
+	// Shared also keeps the subscriptions UserID is a member of.
+	Shared bool
+	// Deleted selects soft-deleted subscriptions instead of live ones.
+	Deleted bool
+	// AsOf reads the subscriptions as they were at that moment.
+	AsOf *time.Time
+}
+
+// ListParams describes one page of GET /subscription. When Cursor is set
+// the page continues after it and Offset is ignored.
+type ListParams struct {
+	Filter SubscriptionFilter
+	SortBy string
+	Order  string
+	Limit  int
+	Offset int
+	Cursor *Cursor
+}
+
+// SubscriptionList is one page read from the repository. Next is nil on
+// the last page.
+type SubscriptionList struct {
+	Items []*Subscription
+	Total int64
+	Next  *Cursor
+}
+
+type SubscriptionListResponse struct {
+	Items      []*Subscription `json:"items"`
+	Total      int64           `json:"total"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Allocations of subscriptions billed less often than monthly.
+// AllocationRenewal charges the whole price in the month it renews,
+// AllocationAmortized spreads it evenly over the months it covers.
+const (
+	AllocationRenewal   = "renewal"
+	AllocationAmortized = "amortized"
+)
+
+// TotalFilter selects subscriptions for cost calculations. StartDate and
+// EndDate bound the billing period by month; AsOf reads the subscriptions
+// as they were at that moment. With UserID only the share of that user in
+// the subscriptions they own or are a member of is counted.
+type TotalFilter struct {
+	UserID      *string
+	ServiceName *string
+	Category    *string
+	PlanID      *int
+	StartDate   *MonthDate
+	EndDate     *MonthDate
+	AsOf        *time.Time
+	Allocation  string
+	// Currency is the currency totals are converted into.
+	Currency string
+}
+
+// SubscriptionFilter returns the row filter for subscriptions that overlap
+// the months from..to.
+func (f TotalFilter) SubscriptionFilter(from *MonthDate, to MonthDate) SubscriptionFilter {
+	return SubscriptionFilter{
+		UserID:      f.UserID,
+		ServiceName: f.ServiceName,
+		Category:    f.Category,
+		PlanID:      f.PlanID,
+		ActiveFrom:  from,
+		ActiveTo:    &to,
+		Shared:      true,
+		AsOf:        f.AsOf,
+	}
+}
+
+// ExchangeRate is a rate used to convert a total: amounts in Currency
+// were multiplied by Rate, the rate published on Date.
+type ExchangeRate struct {
+	Currency string  `json:"currency" example:"USD"`
+	Date     string  `json:"date" example:"2025-07-01"`
+	Rate     float64 `json:"rate" example:"78.5"`
 }
 
 type TotalResponse struct {
-	Total int64 `json:"total"`
+	Total         int64          `json:"total"`
+	Currency      string         `json:"currency" example:"RUB"`
+	Subscriptions int            `json:"subscriptions"`
+	Months        int            `json:"months"`
+	Allocation    string         `json:"allocation" example:"renewal"`
+	Rates         []ExchangeRate `json:"rates"`
+}
+
+const (
+	GroupByService = "service_name"
+	GroupByPlan    = "plan_id"
+	GroupByUser    = "user_id"
+	GroupByMonth   = "month"
+)
+
+// BreakdownGroup is the spend of one group. Only the fields selected by
+// group_by are set; subscriptions without a plan are grouped without
+// plan_id.
+type BreakdownGroup struct {
+	ServiceName   string     `json:"service_name,omitempty"`
+	PlanID        *int       `json:"plan_id,omitempty"`
+	PlanName      string     `json:"plan_name,omitempty" example:"Yandex Plus Family"`
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	Month         *MonthDate `json:"month,omitempty" swaggertype:"string" example:"07-2025"`
+	Total         int64      `json:"total"`
+	Subscriptions int        `json:"subscriptions"`
+	Months        int        `json:"months"`
+}
+
+type BreakdownResponse struct {
+	GroupBy    []string         `json:"group_by"`
+	Allocation string           `json:"allocation" example:"renewal"`
+	Currency   string           `json:"currency" example:"RUB"`
+	Total      int64            `json:"total"`
+	Groups     []BreakdownGroup `json:"groups"`
+	Rates      []ExchangeRate   `json:"rates"`
+}
+
+// ForecastService is the projected cost of one service in one month.
+type ForecastService struct {
+	ServiceName   string `json:"service_name"`
+	Total         int64  `json:"total"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+// ForecastMonth is the projected spend of one month.
+type ForecastMonth struct {
+	Month    MonthDate         `json:"month" swaggertype:"string" example:"07-2025"`
+	Total    int64             `json:"total"`
+	Services []ForecastService `json:"services"`
+}
+
+type ForecastResponse struct {
+	Months     int             `json:"months"`
+	Allocation string          `json:"allocation" example:"renewal"`
+	Currency   string          `json:"currency" example:"RUB"`
+	Total      int64           `json:"total"`
+	Series     []ForecastMonth `json:"series"`
+	Rates      []ExchangeRate  `json:"rates"`
+}
+
+// DayLayout formats calendar days in responses.
+const DayLayout = "2006-01-02"
+
+// Kinds of upcoming events.
+const (
+	EventRenewal         = "renewal"
+	EventExpiration      = "expiration"
+	EventTrialConversion = "trial_conversion"
+)
+
+// UpcomingEvent is a renewal, the first charge after a trial or the last
+// day of a subscription. Amount and Currency are what a renewal or trial
+// conversion charges, after discounts.
+type UpcomingEvent struct {
+	Event        string        `json:"event" enums:"renewal,trial_conversion,expiration"`
+	Date         string        `json:"date" example:"2025-08-01"`
+	Amount       int           `json:"amount,omitempty"`
+	Currency     string        `json:"currency,omitempty" example:"RUB"`
+	Note         string        `json:"note,omitempty" example:"trial converts to paid on 2025-08-01"`
+	Subscription *Subscription `json:"subscription"`
+}
+
+type UpcomingResponse struct {
+	From   string          `json:"from" example:"2025-07-15"`
+	To     string          `json:"to" example:"2025-08-14"`
+	Events []UpcomingEvent `json:"events"`
+}
+
+// ImportRowResult reports the outcome of one imported line: the id of the
+// created subscription or the reason it was rejected.
+type ImportRowResult struct {
+	Line  int    `json:"line"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Mode    string            `json:"mode"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// Count fills Created and Failed from Rows.
+func (r *ImportResponse) Count() {
+	r.Created, r.Failed = 0, 0
+	for _, row := range r.Rows {
+		if row.Error != "" {
+			r.Failed++
+		} else if row.ID != 0 {
+			r.Created++
+		}
+	}
+}
+
+// FieldError describes why one request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
+// ErrorResponse is an RFC 7807 problem document. Error repeats Detail for
+// clients written against the old {"error": ...} body.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Error  string       `json:"error"`
+	Errors []FieldError `json:"errors,omitempty"`
 }
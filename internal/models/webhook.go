@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook event types.
+const (
+	EventSubscriptionCreated  = "subscription.created"
+	EventSubscriptionUpdated  = "subscription.updated"
+	EventSubscriptionDeleted  = "subscription.deleted"
+	EventSubscriptionRestored = "subscription.restored"
+	EventSubscriptionPurged   = "subscription.purged"
+	EventSubscriptionExpiring = "subscription.expiring"
+)
+
+// HistoryEvents maps history operations to the webhook event they emit.
+var HistoryEvents = map[string]string{
+	HistoryCreate:  EventSubscriptionCreated,
+	HistoryUpdate:  EventSubscriptionUpdated,
+	HistoryDelete:  EventSubscriptionDeleted,
+	HistoryRestore: EventSubscriptionRestored,
+	HistoryPurge:   EventSubscriptionPurged,
+}
+
+// IsWebhookEvent reports whether event is a known event type.
+func IsWebhookEvent(event string) bool {
+	if event == EventSubscriptionExpiring {
+		return true
+	}
+	for _, e := range HistoryEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a registered receiver of events. An empty Events list
+// subscribes to every event. Secret is only returned when the webhook is
+// created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url" example:"https://example.com/hooks/subscriptions"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookRequest creates or replaces a webhook. A missing secret is
+// generated on create and kept on replace; Active defaults to true.
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required,max=2000"`
+	Secret string   `json:"secret,omitempty" binding:"omitempty,min=16,max=255"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active,omitempty"`
+}
+
+// WebhookEvent is the body POSTed to webhooks. For subscription changes
+// Data is the history entry of the change.
+type WebhookEvent struct {
+	ID             int64           `json:"id"`
+	Type           string          `json:"type" example:"subscription.created"`
+	SubscriptionID int             `json:"subscription_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Data           json.RawMessage `json:"data" swaggertype:"object"`
+}
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event sent to one webhook.
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int        `json:"webhook_id"`
+	EventID       int64      `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status" enums:"pending,delivered,dead"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastStatus    *int       `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// OutgoingDelivery is a claimed delivery together with what is needed to
+// send it.
+type OutgoingDelivery struct {
+	ID       int64
+	Attempts int
+	URL      string
+	Secret   string
+	Event    WebhookEvent
+}
@@ -0,0 +1,66 @@
+package rates
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"testtask/internal/models"
+)
+
+type snapshotKey struct {
+	currency string
+	date     time.Time
+}
+
+// Converter converts amounts into one currency and remembers every rate
+// it used. A nil table only allows amounts already in that currency.
+type Converter struct {
+	table *Table
+	to    string
+	used  map[snapshotKey]float64
+}
+
+func NewConverter(table *Table, to string) *Converter {
+	return &Converter{table: table, to: to, used: map[snapshotKey]float64{}}
+}
+
+// Currency returns the currency amounts are converted into.
+func (c *Converter) Currency() string {
+	return c.to
+}
+
+// Convert converts amount from currency at the rate effective on.
+func (c *Converter) Convert(amount float64, currency string, on time.Time) (float64, error) {
+	if currency == c.to {
+		return amount, nil
+	}
+	if c.table == nil {
+		return 0, fmt.Errorf("%w for %s: no rates are loaded", ErrNoRate, currency)
+	}
+	rate, date, err := c.table.Rate(currency, c.to, on)
+	if err != nil {
+		return 0, err
+	}
+	c.used[snapshotKey{currency: currency, date: date}] = rate
+	return amount * rate, nil
+}
+
+// Snapshot lists the rates used so far, by currency and date.
+func (c *Converter) Snapshot() []models.ExchangeRate {
+	snapshot := make([]models.ExchangeRate, 0, len(c.used))
+	for key, rate := range c.used {
+		snapshot = append(snapshot, models.ExchangeRate{
+			Currency: key.currency,
+			Date:     key.date.Format(dateLayout),
+			Rate:     rate,
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Currency != snapshot[j].Currency {
+			return snapshot[i].Currency < snapshot[j].Currency
+		}
+		return snapshot[i].Date < snapshot[j].Date
+	})
+	return snapshot
+}
@@ -0,0 +1,193 @@
+// Package rates converts amounts between currencies using daily exchange
+// rates loaded from an ECB-style CSV or XML file.
+package rates
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// ErrNoRate means no rate for a currency was published on or before the
+// requested date.
+var ErrNoRate = errors.New("no exchange rate")
+
+type point struct {
+	date time.Time
+	rate float64
+}
+
+// Table holds daily rates quoted as units of a currency per one unit of
+// the base currency, like the ECB reference rates against EUR.
+type Table struct {
+	base   string
+	series map[string][]point
+}
+
+func NewTable(base string) *Table {
+	return &Table{base: strings.ToUpper(base), series: map[string][]point{}}
+}
+
+// Base returns the currency the rates are quoted against.
+func (t *Table) Base() string {
+	return t.base
+}
+
+// Add records the rate of currency on date. Call Sort after the last Add.
+func (t *Table) Add(currency string, date time.Time, rate float64) {
+	currency = strings.ToUpper(currency)
+	t.series[currency] = append(t.series[currency], point{date: date, rate: rate})
+}
+
+// Sort orders every series by date.
+func (t *Table) Sort() {
+	for _, s := range t.series {
+		sort.Slice(s, func(i, j int) bool { return s[i].date.Before(s[j].date) })
+	}
+}
+
+// lookup returns the latest rate of currency published on or before on.
+func (t *Table) lookup(currency string, on time.Time) (point, error) {
+	if currency == t.base {
+		return point{rate: 1}, nil
+	}
+	s := t.series[currency]
+	i := sort.Search(len(s), func(i int) bool { return s[i].date.After(on) })
+	if i == 0 {
+		return point{}, fmt.Errorf("%w for %s on %s", ErrNoRate, currency, on.Format(dateLayout))
+	}
+	return s[i-1], nil
+}
+
+// Rate returns how many units of to one unit of from is worth on the
+// given date, and the publication date of the most recent rate used.
+func (t *Table) Rate(from, to string, on time.Time) (float64, time.Time, error) {
+	f, err := t.lookup(from, on)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	c, err := t.lookup(to, on)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	date := f.date
+	if c.date.After(date) {
+		date = c.date
+	}
+	return c.rate / f.rate, date, nil
+}
+
+// Load reads a rates file. Files ending in .xml are read as the ECB
+// eurofxref XML, anything else as the ECB CSV with a Date column followed
+// by one column per currency.
+func Load(path, base string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rates file: %w", err)
+	}
+	defer f.Close()
+
+	t := NewTable(base)
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		err = t.readXML(f)
+	} else {
+		err = t.readCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file %s: %w", path, err)
+	}
+	t.Sort()
+	return t, nil
+}
+
+func (t *Table) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		date, err := time.Parse(dateLayout, strings.TrimSpace(record[0]))
+		if err != nil {
+			return fmt.Errorf("invalid date %q", record[0])
+		}
+		for i := 1; i < len(record) && i < len(header); i++ {
+			currency := strings.TrimSpace(header[i])
+			value := strings.TrimSpace(record[i])
+			// The ECB file ends every line with a comma and marks
+			// currencies that were not quoted that day with N/A.
+			if currency == "" || value == "" || value == "N/A" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate <= 0 {
+				return fmt.Errorf("invalid %s rate %q on %s", currency, value, record[0])
+			}
+			t.Add(currency, date, rate)
+		}
+	}
+}
+
+// readXML reads nested Cube elements: <Cube time="..."> groups the
+// <Cube currency="..." rate="..."/> quotes of one day.
+func (t *Table) readXML(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	var date time.Time
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || el.Name.Local != "Cube" {
+			continue
+		}
+		var currency, rate string
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "time":
+				if date, err = time.Parse(dateLayout, attr.Value); err != nil {
+					return fmt.Errorf("invalid date %q", attr.Value)
+				}
+			case "currency":
+				currency = attr.Value
+			case "rate":
+				rate = attr.Value
+			}
+		}
+		if currency == "" {
+			continue
+		}
+		if date.IsZero() {
+			return fmt.Errorf("%s rate outside of a dated Cube", currency)
+		}
+		value, err := strconv.ParseFloat(rate, 64)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("invalid %s rate %q on %s", currency, rate, date.Format(dateLayout))
+		}
+		t.Add(currency, date, value)
+	}
+}
@@ -0,0 +1,204 @@
+package rates
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+// ecbCSV mimics eurofxref-hist.csv: newest day first, a trailing comma on
+// every line and N/A for currencies not quoted that day.
+const ecbCSV = `Date, USD, JPY, RUB,
+2025-07-02, 1.1782, 169.66, N/A,
+2025-07-01, 1.1778, 169.57, 92.5,
+2025-06-30, 1.1720, 169.17, 92.1,
+`
+
+const ecbXML = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender><gesmes:name>European Central Bank</gesmes:name></gesmes:Sender>
+	<Cube>
+		<Cube time="2025-07-02">
+			<Cube currency="USD" rate="1.1782"/>
+			<Cube currency="JPY" rate="169.66"/>
+		</Cube>
+		<Cube time="2025-07-01">
+			<Cube currency="USD" rate="1.1778"/>
+			<Cube currency="JPY" rate="169.57"/>
+			<Cube currency="RUB" rate="92.5"/>
+		</Cube>
+		<Cube time="2025-06-30">
+			<Cube currency="USD" rate="1.1720"/>
+			<Cube currency="JPY" rate="169.17"/>
+			<Cube currency="RUB" rate="92.1"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func day(s string) time.Time {
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestLoadAndRate(t *testing.T) {
+	files := map[string]string{
+		"eurofxref-hist.csv": ecbCSV,
+		"eurofxref-hist.xml": ecbXML,
+		"EUROFXREF.XML":      ecbXML,
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		on       string
+		rate     float64
+		date     string
+		err      error
+	}{
+		{"base to currency", "EUR", "USD", "2025-07-02", 1.1782, "2025-07-02", nil},
+		{"currency to base", "USD", "EUR", "2025-07-02", 1 / 1.1782, "2025-07-02", nil},
+		{"cross rate", "USD", "JPY", "2025-07-02", 169.66 / 1.1782, "2025-07-02", nil},
+		{"same currency", "USD", "USD", "2025-07-01", 1, "2025-07-01", nil},
+		{"older quote", "USD", "EUR", "2025-06-30", 1 / 1.1720, "2025-06-30", nil},
+		{"weekend uses the last published rate", "EUR", "USD", "2025-07-05", 1.1782, "2025-07-02", nil},
+		{"missing quote falls back to an earlier day", "EUR", "RUB", "2025-07-02", 92.5, "2025-07-01", nil},
+		{"cross rate reports the newer date", "RUB", "USD", "2025-07-02", 1.1782 / 92.5, "2025-07-02", nil},
+		{"before the first rate", "EUR", "USD", "2025-06-29", 0, "", ErrNoRate},
+		{"unknown currency", "EUR", "GBP", "2025-07-02", 0, "", ErrNoRate},
+		{"unknown source currency", "GBP", "EUR", "2025-07-02", 0, "", ErrNoRate},
+	}
+	for name, content := range files {
+		table, err := Load(writeFile(t, name, content), "eur")
+		if err != nil {
+			t.Fatalf("Load(%s): %v", name, err)
+		}
+		if table.Base() != "EUR" {
+			t.Errorf("%s: Base() = %s, want EUR", name, table.Base())
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				rate, date, err := table.Rate(tt.from, tt.to, day(tt.on))
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("got error %v, want %v", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if math.Abs(rate-tt.rate) > 1e-12 {
+					t.Errorf("rate = %v, want %v", rate, tt.rate)
+				}
+				if tt.from != tt.to && date.Format(dateLayout) != tt.date {
+					t.Errorf("date = %s, want %s", date.Format(dateLayout), tt.date)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadRejectsMalformedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"empty csv", "rates.csv", ""},
+		{"bad csv date", "rates.csv", "Date,USD\n02.07.2025,1.17\n"},
+		{"bad csv rate", "rates.csv", "Date,USD\n2025-07-02,abc\n"},
+		{"zero csv rate", "rates.csv", "Date,USD\n2025-07-02,0\n"},
+		{"negative csv rate", "rates.csv", "Date,USD\n2025-07-02,-1.1\n"},
+		{"bad xml date", "rates.xml", `<Cube><Cube time="2025/07/02"><Cube currency="USD" rate="1.1"/></Cube></Cube>`},
+		{"bad xml rate", "rates.xml", `<Cube><Cube time="2025-07-02"><Cube currency="USD" rate="x"/></Cube></Cube>`},
+		{"xml rate without a day", "rates.xml", `<Cube><Cube currency="USD" rate="1.1"/></Cube>`},
+		{"broken xml", "rates.xml", `<Cube><Cube time="2025-07-02">`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(writeFile(t, tt.file, tt.content), "EUR"); err == nil {
+				t.Error("Load succeeded, want error")
+			}
+		})
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.csv"), "EUR"); err == nil {
+		t.Error("Load of a missing file succeeded, want error")
+	}
+}
+
+func TestTableSortsUnorderedInput(t *testing.T) {
+	table := NewTable("EUR")
+	table.Add("usd", day("2025-07-02"), 1.2)
+	table.Add("usd", day("2025-06-30"), 1.0)
+	table.Add("usd", day("2025-07-01"), 1.1)
+	table.Sort()
+	rate, _, err := table.Rate("EUR", "USD", day("2025-07-01"))
+	if err != nil || rate != 1.1 {
+		t.Errorf("Rate = %v, %v, want 1.1", rate, err)
+	}
+}
+
+func TestConverter(t *testing.T) {
+	table, err := Load(writeFile(t, "rates.csv", ecbCSV), "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := NewConverter(table, "EUR")
+	if got, err := conv.Convert(100, "EUR", day("2025-07-02")); err != nil || got != 100 {
+		t.Errorf("Convert in the target currency = %v, %v, want 100", got, err)
+	}
+	if got, err := conv.Convert(117.82, "USD", day("2025-07-02")); err != nil || math.Abs(got-100) > 1e-9 {
+		t.Errorf("Convert USD = %v, %v, want 100", got, err)
+	}
+	if got, err := conv.Convert(92.5, "RUB", day("2025-07-01")); err != nil || math.Abs(got-1) > 1e-9 {
+		t.Errorf("Convert RUB = %v, %v, want 1", got, err)
+	}
+	if _, err := conv.Convert(1, "GBP", day("2025-07-02")); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert GBP error = %v, want %v", err, ErrNoRate)
+	}
+
+	want := []models.ExchangeRate{
+		{Currency: "RUB", Date: "2025-07-01", Rate: 1 / 92.5},
+		{Currency: "USD", Date: "2025-07-02", Rate: 1 / 1.1782},
+	}
+	got := conv.Snapshot()
+	if len(got) != len(want) {
+		t.Fatalf("Snapshot = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Currency != want[i].Currency || got[i].Date != want[i].Date || math.Abs(got[i].Rate-want[i].Rate) > 1e-12 {
+			t.Errorf("Snapshot[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestConverterWithoutRates(t *testing.T) {
+	conv := NewConverter(nil, "RUB")
+	if got, err := conv.Convert(100, "RUB", day("2025-07-02")); err != nil || got != 100 {
+		t.Errorf("Convert in the target currency = %v, %v, want 100", got, err)
+	}
+	if _, err := conv.Convert(100, "USD", day("2025-07-02")); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert USD error = %v, want %v", err, ErrNoRate)
+	}
+	if snapshot := conv.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("Snapshot = %+v, want empty", snapshot)
+	}
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+// lastChange returns when the latest history entry of a subscription was
+// recorded.
+func lastChange(t *testing.T, repo *SubscriptionRepository, id int) time.Time {
+	t.Helper()
+	history, err := repo.GetSubscriptionHistory(id)
+	if err != nil || len(history) == 0 {
+		t.Fatalf("GetSubscriptionHistory = %v, %v", history, err)
+	}
+	return history[len(history)-1].ChangedAt
+}
+
+func TestAsOfMembers(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	bob := uuid.MustParse("00000000-0000-0000-0000-00000000000c")
+	id := insertTestSubscription(t, repo, owner, "Netflix", 300, "01-2025")
+	if _, err := repo.ReplaceMembers(id, nil, []models.Member{{UserID: alice, Weight: 2}}, "test"); err != nil {
+		t.Fatalf("ReplaceMembers: %v", err)
+	}
+	asOf := lastChange(t, repo, id)
+	if _, err := repo.ReplaceMembers(id, nil, []models.Member{{UserID: bob, Weight: 2}}, "test"); err != nil {
+		t.Fatalf("ReplaceMembers: %v", err)
+	}
+
+	total := func(user uuid.UUID, asOf *time.Time) int64 {
+		t.Helper()
+		userID := user.String()
+		from := month(t, "01-2025")
+		got, err := repo.SumTotalSubscriptions(models.TotalFilter{
+			UserID: &userID, StartDate: &from, EndDate: &from, AsOf: asOf,
+			Allocation: models.AllocationRenewal, Currency: "RUB",
+		})
+		if err != nil {
+			t.Fatalf("SumTotalSubscriptions: %v", err)
+		}
+		return got.Total
+	}
+	tests := []struct {
+		name string
+		user uuid.UUID
+		asOf *time.Time
+		want int64
+	}{
+		{"removed member before the removal", alice, &asOf, 200},
+		{"removed member now", alice, nil, 0},
+		{"later member before joining", bob, &asOf, 0},
+		{"later member now", bob, nil, 200},
+		{"owner before the removal", owner, &asOf, 100},
+	}
+	for _, tt := range tests {
+		if got := total(tt.user, tt.asOf); got != tt.want {
+			t.Errorf("%s: total = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	aliceID, from := alice.String(), month(t, "01-2025")
+	settlement, err := repo.Settlement(models.TotalFilter{
+		UserID: &aliceID, StartDate: &from, EndDate: &from, AsOf: &asOf,
+		Allocation: models.AllocationRenewal, Currency: "RUB",
+	})
+	if err != nil {
+		t.Fatalf("Settlement: %v", err)
+	}
+	if len(settlement.Debts) != 1 || settlement.Debts[0] != (models.Debt{From: alice, To: owner, Amount: 200}) {
+		t.Errorf("debts as of before the removal = %+v", settlement.Debts)
+	}
+}
+
+func TestAsOfCatalog(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	video := "video"
+	service := &models.Service{Name: "Netflix", Aliases: []string{"NFLX"}, Category: &video}
+	if err := repo.CreateService(service); err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	id := insertTestSubscription(t, repo, owner, "Netflix", 300, "01-2025")
+	asOf := lastChange(t, repo, id)
+
+	movies := "movies"
+	service.Aliases, service.Category = nil, &movies
+	if err := repo.UpdateService(service, "test"); err != nil {
+		t.Fatalf("UpdateService: %v", err)
+	}
+	if history, _ := repo.GetSubscriptionHistory(id); len(history) != 2 {
+		t.Fatalf("catalog change recorded %d history entries, want 2", len(history))
+	}
+
+	count := func(filter models.SubscriptionFilter) int64 {
+		t.Helper()
+		list, err := repo.GetAllSubscription(models.ListParams{Filter: filter})
+		if err != nil {
+			t.Fatalf("GetAllSubscription: %v", err)
+		}
+		return list.Total
+	}
+	alias := "nflx"
+	tests := []struct {
+		name   string
+		filter models.SubscriptionFilter
+		want   int64
+	}{
+		{"old category as of before", models.SubscriptionFilter{Category: &video, AsOf: &asOf}, 1},
+		{"new category as of before", models.SubscriptionFilter{Category: &movies, AsOf: &asOf}, 0},
+		{"old category now", models.SubscriptionFilter{Category: &video}, 0},
+		{"new category now", models.SubscriptionFilter{Category: &movies}, 1},
+		{"removed alias as of before", models.SubscriptionFilter{ServiceName: &alias, AsOf: &asOf}, 1},
+		{"removed alias now", models.SubscriptionFilter{ServiceName: &alias}, 0},
+	}
+	for _, tt := range tests {
+		if got := count(tt.filter); got != tt.want {
+			t.Errorf("%s: %d subscriptions, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+const budgetColumns = "id, user_id, service_name, category, amount, currency, created_at, updated_at"
+
+func scanBudget(row rowScanner) (*models.Budget, error) {
+	var b models.Budget
+	if err := row.Scan(&b.ID, &b.UserID, &b.ServiceName, &b.Category, &b.Amount, &b.Currency, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateBudget fails with ErrConflict when the user already has a budget
+// for the same scope.
+func (r *SubscriptionRepository) CreateBudget(b *models.Budget) error {
+	query := `
+		INSERT INTO budgets (user_id, service_name, category, amount, currency)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at`
+
+	if err := r.db.QueryRow(query, b.UserID, b.ServiceName, b.Category, b.Amount, b.Currency).
+		Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return wrapError("failed to create budget", err)
+	}
+	r.logger.WithField("budget_id", b.ID).Info("Budget created")
+	return nil
+}
+
+func (r *SubscriptionRepository) GetBudget(userID uuid.UUID, id int) (*models.Budget, error) {
+	query := `SELECT ` + budgetColumns + ` FROM budgets WHERE id = $1 AND user_id = $2`
+	b, err := scanBudget(r.db.QueryRow(query, id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("budget %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to get budget", err)
+	}
+	return b, nil
+}
+
+// ListBudgets returns the budgets of a user, the overall one first.
+func (r *SubscriptionRepository) ListBudgets(userID uuid.UUID) ([]*models.Budget, error) {
+	query := `
+		SELECT ` + budgetColumns + ` FROM budgets
+		WHERE user_id = $1
+		ORDER BY service_name NULLS FIRST, category NULLS FIRST, id`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, wrapError("failed to list budgets", err)
+	}
+	defer rows.Close()
+
+	budgets := []*models.Budget{}
+	for rows.Next() {
+		b, err := scanBudget(rows)
+		if err != nil {
+			return nil, wrapError("failed to scan budget", err)
+		}
+		budgets = append(budgets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list budgets", err)
+	}
+	return budgets, nil
+}
+
+func (r *SubscriptionRepository) UpdateBudget(b *models.Budget) error {
+	query := `
+		UPDATE budgets
+		SET service_name = $3, category = $4, amount = $5, currency = $6, updated_at = now()
+		WHERE id = $1 AND user_id = $2
+		RETURNING created_at, updated_at`
+
+	if err := r.db.QueryRow(query, b.ID, b.UserID, b.ServiceName, b.Category, b.Amount, b.Currency).
+		Scan(&b.CreatedAt, &b.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("budget %d: %w", b.ID, ErrNotFound)
+		}
+		return wrapError("failed to update budget", err)
+	}
+	return nil
+}
+
+func (r *SubscriptionRepository) DeleteBudget(userID uuid.UUID, id int) error {
+	result, err := r.db.Exec(`DELETE FROM budgets WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return wrapError("failed to delete budget", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return wrapError("failed to get rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("budget %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// budgetSpend measures the spend of every month from..to against every
+// budget of the user, the same way /subscription/total charges each of
+// those months. It returns the statuses of one month after the other, each
+// in the order of the budgets.
+func (r *SubscriptionRepository) budgetSpend(userID uuid.UUID, from, to models.MonthDate) ([][]models.BudgetStatus, error) {
+	budgets, err := r.ListBudgets(userID)
+	if err != nil {
+		return nil, err
+	}
+	user := userID.String()
+	statuses := make([][]models.BudgetStatus, to.Index()-from.Index()+1)
+	for i := range statuses {
+		statuses[i] = make([]models.BudgetStatus, 0, len(budgets))
+	}
+	for _, b := range budgets {
+		breakdown, err := r.SpendBreakdown(models.TotalFilter{
+			UserID:      &user,
+			ServiceName: b.ServiceName,
+			Category:    b.Category,
+			StartDate:   &from,
+			EndDate:     &to,
+			Allocation:  models.AllocationRenewal,
+			Currency:    b.Currency,
+		}, []string{models.GroupByMonth})
+		if err != nil {
+			return nil, fmt.Errorf("budget %d: %w", b.ID, err)
+		}
+		spend := make([]int64, len(statuses))
+		for _, g := range breakdown.Groups {
+			if i := g.Month.Index() - from.Index(); i >= 0 && i < len(spend) {
+				spend[i] = g.Total
+			}
+		}
+		for i := range statuses {
+			statuses[i] = append(statuses[i], newBudgetStatus(*b, spend[i]))
+		}
+	}
+	return statuses, nil
+}
+
+func newBudgetStatus(b models.Budget, spend int64) models.BudgetStatus {
+	status := models.BudgetStatus{
+		Budget:  b,
+		Spend:   spend,
+		Percent: float64(spend) * 100 / float64(b.Amount),
+		Status:  models.BudgetOK,
+		Alerts:  []models.BudgetAlert{},
+	}
+	switch {
+	case status.Percent >= 100:
+		status.Status = models.BudgetExceeded
+	case status.Percent >= float64(models.BudgetThresholds[0]):
+		status.Status = models.BudgetWarning
+	}
+	return status
+}
+
+// BudgetStatus reports the spend of month against every budget of the
+// user together with the alerts recorded for that month.
+func (r *SubscriptionRepository) BudgetStatus(userID uuid.UUID, month models.MonthDate) (*models.BudgetStatusResponse, error) {
+	spend, err := r.budgetSpend(userID, month, month)
+	if err != nil {
+		return nil, err
+	}
+	statuses := spend[0]
+	query := `
+		SELECT a.id, a.budget_id, a.month, a.threshold, a.spend, a.amount, a.created_at
+		FROM budget_alerts a
+		JOIN budgets b ON b.id = a.budget_id
+		WHERE b.user_id = $1 AND a.month = $2
+		ORDER BY a.threshold`
+
+	rows, err := r.db.Query(query, userID, month)
+	if err != nil {
+		return nil, wrapError("failed to list budget alerts", err)
+	}
+	defer rows.Close()
+
+	index := map[int]int{}
+	for i, s := range statuses {
+		index[s.Budget.ID] = i
+	}
+	for rows.Next() {
+		var a models.BudgetAlert
+		if err := rows.Scan(&a.ID, &a.BudgetID, &a.Month, &a.Threshold, &a.Spend, &a.Amount, &a.CreatedAt); err != nil {
+			return nil, wrapError("failed to scan budget alert", err)
+		}
+		if i, ok := index[a.BudgetID]; ok {
+			statuses[i].Alerts = append(statuses[i].Alerts, a)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list budget alerts", err)
+	}
+	return &models.BudgetStatusResponse{UserID: userID, Month: month, Budgets: statuses}, nil
+}
+
+// EvaluateBudgets records an alert for every threshold the spend of a
+// month from..to has reached on a budget of the user. Each threshold is
+// recorded once a month; the new alerts are returned.
+func (r *SubscriptionRepository) EvaluateBudgets(userID uuid.UUID, from, to models.MonthDate) ([]models.BudgetAlert, error) {
+	spend, err := r.budgetSpend(userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO budget_alerts (budget_id, month, threshold, spend, amount)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (budget_id, month, threshold) DO NOTHING
+		RETURNING id, created_at`
+
+	var alerts []models.BudgetAlert
+	for i, statuses := range spend {
+		month := from.AddMonths(i)
+		for _, s := range statuses {
+			for _, threshold := range models.BudgetThresholds {
+				if s.Percent < float64(threshold) {
+					break
+				}
+				a := models.BudgetAlert{
+					BudgetID:  s.Budget.ID,
+					Month:     month,
+					Threshold: threshold,
+					Spend:     s.Spend,
+					Amount:    s.Budget.Amount,
+				}
+				err := r.db.QueryRow(query, a.BudgetID, a.Month, a.Threshold, a.Spend, a.Amount).Scan(&a.ID, &a.CreatedAt)
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				if err != nil {
+					return alerts, wrapError("failed to record budget alert", err)
+				}
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	return alerts, nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"testing"
+
+	"testtask/internal/models"
+)
+
+func TestBudgetThresholds(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	netflix := "Netflix"
+	overall := &models.Budget{UserID: alice, Amount: 1000, Currency: "RUB"}
+	perService := &models.Budget{UserID: alice, ServiceName: &netflix, Amount: 400, Currency: "RUB"}
+	for _, b := range []*models.Budget{perService, overall} {
+		if err := repo.CreateBudget(b); err != nil {
+			t.Fatalf("CreateBudget: %v", err)
+		}
+	}
+	insertTestSubscription(t, repo, alice, "Netflix", 300, "01-2025")
+	insertTestSubscription(t, repo, alice, "Spotify", 500, "02-2025")
+	insertTestSubscription(t, repo, alice, "Netflix", 150, "03-2025")
+	// Someone else's spend counts for nobody here.
+	insertTestSubscription(t, repo, owner, "Netflix", 1000, "01-2025")
+
+	spend, err := repo.budgetSpend(alice, month(t, "01-2025"), month(t, "03-2025"))
+	if err != nil {
+		t.Fatalf("budgetSpend: %v", err)
+	}
+	want := []struct {
+		overall, service int64
+		status           string
+	}{
+		{300, 300, models.BudgetOK},
+		{800, 300, models.BudgetOK},
+		{950, 450, models.BudgetExceeded},
+	}
+	if len(spend) != len(want) {
+		t.Fatalf("got %d months, want %d", len(spend), len(want))
+	}
+	for i, w := range want {
+		statuses := spend[i]
+		// The overall budget comes first.
+		if len(statuses) != 2 || statuses[0].Budget.ID != overall.ID || statuses[1].Budget.ID != perService.ID {
+			t.Fatalf("month %d: statuses = %+v", i, statuses)
+		}
+		if statuses[0].Spend != w.overall || statuses[1].Spend != w.service || statuses[1].Status != w.status {
+			t.Errorf("month %d: spend %d and %d (%s), want %d and %d (%s)", i,
+				statuses[0].Spend, statuses[1].Spend, statuses[1].Status, w.overall, w.service, w.status)
+		}
+	}
+	if spend[1][0].Status != models.BudgetWarning || spend[1][0].Percent != 80 {
+		t.Errorf("80%% of the overall budget = %+v", spend[1][0])
+	}
+
+	alerts, err := repo.EvaluateBudgets(alice, month(t, "01-2025"), month(t, "03-2025"))
+	if err != nil {
+		t.Fatalf("EvaluateBudgets: %v", err)
+	}
+	type alert struct {
+		budget    int
+		month     string
+		threshold int
+	}
+	var got []alert
+	for _, a := range alerts {
+		got = append(got, alert{a.BudgetID, a.Month.String(), a.Threshold})
+	}
+	wantAlerts := []alert{
+		{overall.ID, "02-2025", 80},
+		{overall.ID, "03-2025", 80},
+		{perService.ID, "03-2025", 80},
+		{perService.ID, "03-2025", 100},
+	}
+	if len(got) != len(wantAlerts) {
+		t.Fatalf("alerts = %+v, want %+v", got, wantAlerts)
+	}
+	for i := range wantAlerts {
+		if got[i] != wantAlerts[i] {
+			t.Fatalf("alerts = %+v, want %+v", got, wantAlerts)
+		}
+	}
+	if again, err := repo.EvaluateBudgets(alice, month(t, "01-2025"), month(t, "03-2025")); err != nil || len(again) != 0 {
+		t.Errorf("second evaluation = %+v, %v, want no new alerts", again, err)
+	}
+
+	status, err := repo.BudgetStatus(alice, month(t, "03-2025"))
+	if err != nil {
+		t.Fatalf("BudgetStatus: %v", err)
+	}
+	if len(status.Budgets) != 2 || len(status.Budgets[0].Alerts) != 1 || len(status.Budgets[1].Alerts) != 2 ||
+		status.Budgets[1].Spend != 450 {
+		t.Errorf("status = %+v", status.Budgets)
+	}
+}
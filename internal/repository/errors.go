@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// Errors returned by the repository wrap one of these sentinels, so that
+// callers can tell failures apart with errors.Is.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("invalid data")
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrVersionMismatch means the row changed since the caller read it.
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// classify returns the sentinel matching a database/sql or driver error,
+// or nil when the error is not recognised.
+func classify(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "23":
+			// Integrity constraint violation: unique, foreign key, check.
+			if pqErr.Code.Name() == "unique_violation" || pqErr.Code.Name() == "foreign_key_violation" {
+				return ErrConflict
+			}
+			return ErrValidation
+		case "22":
+			return ErrValidation
+		case "08", "53", "57":
+			// Connection exception, insufficient resources, operator
+			// intervention such as a shutdown.
+			return ErrUnavailable
+		case "40":
+			return ErrConflict
+		}
+		return nil
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.As(err, &netErr) {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// wrapError annotates err with msg and the sentinel it classifies as.
+func wrapError(msg string, err error) error {
+	if kind := classify(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", msg, kind, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
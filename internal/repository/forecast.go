@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"time"
+
+	"testtask/internal/models"
+)
+
+// Forecast projects the spend of the next months, starting with the
+// current one, from the subscriptions active in that window. It is the
+// spend breakdown by month and service laid out as a series, with every
+// month present even when nothing is charged in it.
+func (r *SubscriptionRepository) Forecast(filter models.TotalFilter, months int) (*models.ForecastResponse, error) {
+	from := models.MonthOf(time.Now())
+	to := from.AddMonths(months - 1)
+	filter.StartDate, filter.EndDate, filter.AsOf = &from, &to, nil
+
+	breakdown, err := r.SpendBreakdown(filter, []string{models.GroupByMonth, models.GroupByService})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &models.ForecastResponse{
+		Months:     months,
+		Allocation: breakdown.Allocation,
+		Currency:   breakdown.Currency,
+		Total:      breakdown.Total,
+		Series:     make([]models.ForecastMonth, months),
+		Rates:      breakdown.Rates,
+	}
+	for i := range resp.Series {
+		resp.Series[i] = models.ForecastMonth{Month: from.AddMonths(i), Services: []models.ForecastService{}}
+	}
+	for _, g := range breakdown.Groups {
+		month := &resp.Series[g.Month.Index()-from.Index()]
+		month.Total += g.Total
+		month.Services = append(month.Services, models.ForecastService{
+			ServiceName:   g.ServiceName,
+			Total:         g.Total,
+			Subscriptions: g.Subscriptions,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"testtask/internal/models"
+)
+
+// snapshotExpression is the state of subscription row s kept in the
+// history: the row with the price schedule that applies to it, in the
+// order attach uses, its members in user order, and the category and
+// name keys of its catalog service that as_of filters match.
+const snapshotExpression = `to_jsonb(s) || jsonb_build_object(
+	'price_schedule', COALESCE((
+		SELECT jsonb_agg(jsonb_build_object('id', p.id, 'plan_id', p.plan_id, 'price', p.price, 'effective_from', p.effective_from)
+			ORDER BY p.effective_from, p.plan_id IS NULL, p.id)
+		FROM subscription_prices p
+		WHERE p.subscription_id = s.id OR (p.plan_id = s.plan_id AND NOT s.price_overridden)
+	), '[]'::jsonb),
+	'members', COALESCE((
+		SELECT jsonb_agg(jsonb_strip_nulls(jsonb_build_object('user_id', m.user_id, 'weight', m.weight, 'amount', m.amount))
+			ORDER BY m.user_id)
+		FROM subscription_members m
+		WHERE m.subscription_id = s.id
+	), '[]'::jsonb),
+	'category', (SELECT c.category FROM services c WHERE c.id = s.service_id),
+	'service_keys', COALESCE((
+		SELECT jsonb_agg(n.key ORDER BY n.key) FROM service_names n WHERE n.service_id = s.service_id
+	), '[]'::jsonb)
+)`
+
+// latestHistoryQuery selects the latest history entry of every
+// subscription at the moment in placeholder %s.
+const latestHistoryQuery = `
+	SELECT DISTINCT ON (subscription_id) subscription_id, after
+	FROM subscription_history
+	WHERE changed_at <= %s
+	ORDER BY subscription_id, changed_at DESC, id DESC`
+
+// lockSnapshot locks the subscription row for the rest of tx and returns
+// it as JSON. deleted selects whether the row must be in the trash or not.
+func lockSnapshot(tx *sql.Tx, id int, deleted bool) ([]byte, error) {
+	query := `
+		SELECT ` + snapshotExpression + ` FROM subscriptions s
+		WHERE s.id = $1 AND (s.deleted_at IS NOT NULL) = $2
+		FOR UPDATE`
+
+	var snapshot []byte
+	if err := tx.QueryRow(query, id, deleted).Scan(&snapshot); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("subscription %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to lock subscription", err)
+	}
+	return snapshot, nil
+}
+
+// lockSnapshots is lockSnapshot for every subscription matching where,
+// live or in the trash. It returns the ids in order and the snapshots by
+// id.
+func lockSnapshots(tx *sql.Tx, where string, args ...interface{}) ([]int, map[int][]byte, error) {
+	rows, err := tx.Query(`SELECT s.id, `+snapshotExpression+` FROM subscriptions s WHERE `+where+` ORDER BY s.id FOR UPDATE OF s`, args...)
+	if err != nil {
+		return nil, nil, wrapError("failed to lock subscriptions", err)
+	}
+	defer rows.Close()
+	var ids []int
+	snapshots := map[int][]byte{}
+	for rows.Next() {
+		var (
+			id       int
+			snapshot []byte
+		)
+		if err := rows.Scan(&id, &snapshot); err != nil {
+			return nil, nil, wrapError("failed to scan subscription snapshot", err)
+		}
+		ids = append(ids, id)
+		snapshots[id] = snapshot
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, wrapError("failed to lock subscriptions", err)
+	}
+	return ids, snapshots, nil
+}
+
+// touchSubscriptions gives every subscription in ids a new version and a
+// history entry after a change stored outside its row, such as its price
+// schedule. before holds the snapshots from lockSnapshots.
+func touchSubscriptions(tx *sql.Tx, ids []int, before map[int][]byte, actor string) error {
+	for _, id := range ids {
+		if _, err := tx.Exec(`UPDATE subscriptions SET version = version + 1, updated_at = now() WHERE id = $1`, id); err != nil {
+			return wrapError("failed to update subscription", err)
+		}
+		if err := recordHistory(tx, id, models.HistoryUpdate, before[id], actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchChangedSubscriptions is touchSubscriptions for the subscriptions in
+// ids whose snapshot is no longer the one in before, such as after a
+// change of their catalog service. It returns the ids it touched.
+func touchChangedSubscriptions(tx *sql.Tx, ids []int, before map[int][]byte, actor string) ([]int, error) {
+	var changed []int
+	for _, id := range ids {
+		var same bool
+		query := `SELECT ` + snapshotExpression + ` = $2::jsonb FROM subscriptions s WHERE s.id = $1`
+		if err := tx.QueryRow(query, id, before[id]).Scan(&same); err != nil {
+			return nil, wrapError("failed to compare subscription snapshot", err)
+		}
+		if !same {
+			changed = append(changed, id)
+		}
+	}
+	return changed, touchSubscriptions(tx, changed, before, actor)
+}
+
+// recordHistory appends a history entry whose after state is the current
+// row of subscription id as seen by tx, and queues the entry as a webhook
+// event in the outbox.
+func recordHistory(tx *sql.Tx, id int, operation string, before []byte, actor string) error {
+	query := `
+		WITH entry AS (
+			INSERT INTO subscription_history (subscription_id, operation, before, after, actor)
+			SELECT s.id, $2, $3, ` + snapshotExpression + `, $4 FROM subscriptions s WHERE s.id = $1
+			RETURNING *
+		)
+		INSERT INTO webhook_events (event_type, subscription_id, payload)
+		SELECT $5, e.subscription_id, to_jsonb(e) FROM entry e`
+
+	// A nil []byte would be sent as an empty string rather than NULL.
+	var beforeArg interface{}
+	if before != nil {
+		beforeArg = before
+	}
+	if _, err := tx.Exec(query, id, operation, beforeArg, actor, models.HistoryEvents[operation]); err != nil {
+		return wrapError("failed to record subscription history", err)
+	}
+	return nil
+}
+
+// GetSubscriptionAsOf returns the subscription as it was at asOf. It is
+// not found when it did not exist yet or was deleted at that moment.
+func (r *SubscriptionRepository) GetSubscriptionAsOf(id int, asOf time.Time) (*models.Subscription, error) {
+	query, args := models.NewListQueryBuilder().
+		WithAsOf(&asOf).
+		WithDeleted(false).
+		WithID(id).
+		BuildQuery()
+
+	sub, err := scanSubscription(r.db.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("subscription %d at %s: %w", id, asOf.Format(time.RFC3339), ErrNotFound)
+		}
+		r.logger.WithError(err).WithField("subscription_id", id).Error("Failed to get subscription")
+		return nil, wrapError("failed to get subscription", err)
+	}
+	schedules, members, err := r.loadDetails(query, args, &asOf)
+	if err != nil {
+		return nil, err
+	}
+	schedules.attach(sub)
+	sub.Members = members[sub.ID]
+	return sub, nil
+}
+
+// GetSubscriptionHistory returns every recorded change of a subscription,
+// oldest first. Purged subscriptions keep their history.
+func (r *SubscriptionRepository) GetSubscriptionHistory(id int) ([]models.HistoryEntry, error) {
+	query := `
+		SELECT id, subscription_id, operation, before, after, actor, changed_at
+		FROM subscription_history
+		WHERE subscription_id = $1
+		ORDER BY changed_at, id`
+
+	rows, err := r.db.Query(query, id)
+	if err != nil {
+		return nil, wrapError("failed to get subscription history", err)
+	}
+	defer rows.Close()
+
+	var entries []models.HistoryEntry
+	for rows.Next() {
+		var entry models.HistoryEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.SubscriptionID, &entry.Operation, &before, &after, &entry.Actor, &entry.ChangedAt); err != nil {
+			return nil, wrapError("failed to scan subscription history", err)
+		}
+		entry.Before = nullJSON(before)
+		entry.After = nullJSON(after)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to get subscription history", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("subscription %d: %w", id, ErrNotFound)
+	}
+	return entries, nil
+}
+
+func nullJSON(b []byte) []byte {
+	if b == nil {
+		return []byte("null")
+	}
+	return b
+}
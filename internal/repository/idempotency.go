@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"testtask/internal/models"
+)
+
+// ReserveIdempotencyKey claims rec.Key for a new request. When the key is
+// already in use the stored record is returned with reserved set to false.
+// A claim without a response lapses after lease, so that a retry can take
+// over from a request that never finished; a response is kept for ttl.
+// Records live in Redis when it is available and in Postgres otherwise,
+// also while Redis fails. Keys claimed during such an outage are only
+// known to Postgres.
+func (r *SubscriptionRepository) ReserveIdempotencyKey(rec *models.IdempotencyRecord, lease, ttl time.Duration) (*models.IdempotencyRecord, bool, error) {
+	if r.cache != nil {
+		stored, reserved, err := r.cache.ReserveIdempotency(rec, lease)
+		if err == nil {
+			return stored, reserved, nil
+		}
+		r.logger.WithError(err).Warn("Redis idempotency lookup failed; falling back to DB")
+	}
+	query := `
+		INSERT INTO idempotency_keys (key, request_hash)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response = NULL, response_headers = NULL, created_at = now()
+		WHERE idempotency_keys.created_at < now() - make_interval(secs => $3)
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < now() - make_interval(secs => $4))
+		RETURNING key`
+	var key string
+	err := r.db.QueryRow(query, rec.Key, rec.RequestHash, ttl.Seconds(), lease.Seconds()).Scan(&key)
+	if err == nil {
+		return rec, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, wrapError("failed to reserve idempotency key", err)
+	}
+
+	existing := &models.IdempotencyRecord{Key: rec.Key}
+	var status sql.NullInt64
+	var response, header []byte
+	if err := r.db.QueryRow(
+		`SELECT request_hash, status_code, response, response_headers FROM idempotency_keys WHERE key = $1`,
+		rec.Key,
+	).Scan(&existing.RequestHash, &status, &response, &header); err != nil {
+		return nil, false, wrapError("failed to load idempotency key", err)
+	}
+	existing.StatusCode = int(status.Int64)
+	existing.Response = response
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &existing.Header); err != nil {
+			return nil, false, fmt.Errorf("failed to decode idempotent response headers: %w", err)
+		}
+	}
+	return existing, false, nil
+}
+
+// CompleteIdempotencyKey stores the response sent for a reserved key. It
+// is kept for ttl from now on.
+func (r *SubscriptionRepository) CompleteIdempotencyKey(rec *models.IdempotencyRecord, ttl time.Duration) error {
+	if r.cache != nil {
+		err := r.cache.SetIdempotency(rec, ttl)
+		if err == nil {
+			return nil
+		}
+		r.logger.WithError(err).Warn("Redis idempotency update failed; falling back to DB")
+	}
+	var header interface{}
+	if len(rec.Header) > 0 {
+		data, err := json.Marshal(rec.Header)
+		if err != nil {
+			return fmt.Errorf("failed to encode idempotent response headers: %w", err)
+		}
+		header = data
+	}
+	// The key may have been reserved in Redis before it failed.
+	_, err := r.db.Exec(`
+		INSERT INTO idempotency_keys (key, request_hash, status_code, response, response_headers)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = EXCLUDED.status_code, response = EXCLUDED.response,
+			response_headers = EXCLUDED.response_headers, created_at = now()`,
+		rec.Key, rec.RequestHash, rec.StatusCode, []byte(rec.Response), header,
+	)
+	if err != nil {
+		return wrapError("failed to store idempotent response", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees a reserved key whose request failed, so that
+// the client can retry it.
+func (r *SubscriptionRepository) ReleaseIdempotencyKey(key string) error {
+	if r.cache != nil {
+		err := r.cache.DeleteIdempotency(key)
+		if err == nil {
+			return nil
+		}
+		r.logger.WithError(err).Warn("Redis idempotency release failed; falling back to DB")
+	}
+	if _, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL`, key); err != nil {
+		return wrapError(fmt.Sprintf("failed to release idempotency key %q", key), err)
+	}
+	return nil
+}
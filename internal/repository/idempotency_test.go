@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"testtask/internal/cache"
+	"testtask/internal/config"
+	"testtask/internal/models"
+)
+
+func sameJSON(t *testing.T, got, want []byte) bool {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %q: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("invalid JSON %q: %v", want, err)
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	rec := &models.IdempotencyRecord{Key: "k1", RequestHash: "a"}
+
+	if _, reserved, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil || !reserved {
+		t.Fatalf("first reserve = %v, %v, want reserved", reserved, err)
+	}
+	stored, reserved, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour)
+	if err != nil || reserved || stored.Completed() || stored.RequestHash != "a" {
+		t.Fatalf("reserve in progress = %+v, %v, %v", stored, reserved, err)
+	}
+
+	done := &models.IdempotencyRecord{Key: "k1", RequestHash: "a", StatusCode: 201, Response: json.RawMessage(`{"id":1}`),
+		Header: map[string][]string{"Etag": {`"1"`}}}
+	if err := repo.CompleteIdempotencyKey(done, time.Hour); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	if err := repo.ReleaseIdempotencyKey("k1"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	stored, reserved, err = repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour)
+	if err != nil || reserved {
+		t.Fatalf("reserve after completion = %v, %v", reserved, err)
+	}
+	if stored.StatusCode != 201 || !sameJSON(t, stored.Response, done.Response) || !reflect.DeepEqual(stored.Header, done.Header) {
+		t.Errorf("stored = %+v, want %+v", stored, done)
+	}
+}
+
+func TestIdempotencyKeyRelease(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	rec := &models.IdempotencyRecord{Key: "k1", RequestHash: "a"}
+	if _, _, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil {
+		t.Fatalf("ReserveIdempotencyKey: %v", err)
+	}
+	if err := repo.ReleaseIdempotencyKey("k1"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	if _, reserved, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil || !reserved {
+		t.Errorf("reserve after release = %v, %v, want reserved", reserved, err)
+	}
+}
+
+func TestIdempotencyLeaseLapses(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	if _, _, err := repo.ReserveIdempotencyKey(&models.IdempotencyRecord{Key: "k1", RequestHash: "a"}, time.Millisecond, time.Hour); err != nil {
+		t.Fatalf("ReserveIdempotencyKey: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	stored, reserved, err := repo.ReserveIdempotencyKey(&models.IdempotencyRecord{Key: "k1", RequestHash: "b"}, time.Millisecond, time.Hour)
+	if err != nil || !reserved || stored.RequestHash != "b" {
+		t.Fatalf("reserve after the lease = %+v, %v, %v, want taken over", stored, reserved, err)
+	}
+
+	// A response is kept for the whole TTL.
+	done := &models.IdempotencyRecord{Key: "k1", RequestHash: "b", StatusCode: 201, Response: json.RawMessage(`{}`)}
+	if err := repo.CompleteIdempotencyKey(done, time.Hour); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if stored, reserved, err := repo.ReserveIdempotencyKey(&models.IdempotencyRecord{Key: "k1", RequestHash: "b"}, time.Millisecond, time.Hour); err != nil || reserved || !stored.Completed() {
+		t.Errorf("reserve of a completed key = %+v, %v, %v", stored, reserved, err)
+	}
+}
+
+func TestIdempotencyFallsBackWithoutRedis(t *testing.T) {
+	unreachable := cache.New(config.RedisConfig{Host: "127.0.0.1", Port: "1"})
+	defer unreachable.Close()
+	repo := newTestRepository(t, unreachable)
+
+	rec := &models.IdempotencyRecord{Key: "k1", RequestHash: "a"}
+	if _, reserved, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil || !reserved {
+		t.Fatalf("first reserve = %v, %v, want reserved", reserved, err)
+	}
+	if _, reserved, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil || reserved {
+		t.Fatalf("second reserve = %v, %v, want in use", reserved, err)
+	}
+	done := &models.IdempotencyRecord{Key: "k1", RequestHash: "a", StatusCode: 201, Response: json.RawMessage(`{}`)}
+	if err := repo.CompleteIdempotencyKey(done, time.Hour); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	if stored, _, err := repo.ReserveIdempotencyKey(rec, time.Minute, time.Hour); err != nil || stored.StatusCode != 201 {
+		t.Errorf("reserve after completion = %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/billing"
+	"testtask/internal/models"
+	"testtask/internal/rates"
+)
+
+const memberQuery = `
+	SELECT subscription_id, user_id, COALESCE(weight, 0), COALESCE(amount, 0)
+	FROM subscription_members`
+
+// memberAsOfQuery reads the members kept in the history snapshots at a
+// moment. The placeholder of the moment is filled in with fmt.Sprintf.
+const memberAsOfQuery = `
+	SELECT h.subscription_id, m.user_id, COALESCE(m.weight, 0), COALESCE(m.amount, 0)
+	FROM (` + latestHistoryQuery + `) h
+	CROSS JOIN LATERAL jsonb_to_recordset(h.after->'members') AS m(user_id uuid, weight int, amount int)`
+
+// loadMembers returns the members of the subscriptions read by query, by
+// subscription, in user order.
+func loadMembers(q querier, query string, args ...interface{}) (map[int][]models.Member, error) {
+	rows, err := q.Query(query+` ORDER BY subscription_id, user_id`, args...)
+	if err != nil {
+		return nil, wrapError("failed to load subscription members", err)
+	}
+	defer rows.Close()
+
+	members := map[int][]models.Member{}
+	for rows.Next() {
+		var (
+			m  models.Member
+			id int
+		)
+		if err := rows.Scan(&id, &m.UserID, &m.Weight, &m.Amount); err != nil {
+			return nil, wrapError("failed to scan subscription member", err)
+		}
+		members[id] = append(members[id], m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to load subscription members", err)
+	}
+	return members, nil
+}
+
+// attachMembers loads the members of one subscription.
+func (r *SubscriptionRepository) attachMembers(sub *models.Subscription) error {
+	members, err := loadMembers(r.db, memberQuery+` WHERE subscription_id = $1`, sub.ID)
+	if err != nil {
+		return err
+	}
+	sub.Members = members[sub.ID]
+	return nil
+}
+
+// attachDetails loads the parts of sub stored outside its row: the price
+// schedule and the members.
+func (r *SubscriptionRepository) attachDetails(sub *models.Subscription) error {
+	if err := r.attachPriceSchedule(sub); err != nil {
+		return err
+	}
+	return r.attachMembers(sub)
+}
+
+// ReplaceMembers replaces the members of a live subscription if its row
+// still has version, when given, and returns the subscription with the
+// stored list. Like any other change it bumps the version and is
+// recorded in the history.
+func (r *SubscriptionRepository) ReplaceMembers(id int, version *int, members []models.Member, actor string) (*models.Subscription, error) {
+	err := r.inTx(func(tx *sql.Tx) error {
+		before, err := lockSnapshot(tx, id, false)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`
+			UPDATE subscriptions
+			SET version = version + 1, updated_at = now()
+			WHERE id = $1 AND ($2::int IS NULL OR version = $2)`, id, version)
+		if err != nil {
+			return wrapError("failed to update subscription", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return wrapError("failed to get rows affected", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("subscription %d: %w", id, ErrVersionMismatch)
+		}
+		if _, err := tx.Exec(`DELETE FROM subscription_members WHERE subscription_id = $1`, id); err != nil {
+			return wrapError("failed to replace subscription members", err)
+		}
+		for _, m := range members {
+			if _, err := tx.Exec(`
+				INSERT INTO subscription_members (subscription_id, user_id, weight, amount)
+				VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0))`,
+				id, m.UserID, m.Weight, m.Amount); err != nil {
+				return wrapError("failed to replace subscription members", err)
+			}
+		}
+		return recordHistory(tx, id, models.HistoryUpdate, before, actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.forgetSubscriptions([]int{id})
+	r.logger.WithField("subscription_id", id).WithField("members", len(members)).Info("Subscription members replaced")
+	return r.GetSubscriptionByID(id)
+}
+
+// Settlement works out who owes whom for the shared subscriptions matching
+// filter: every member owes the owner their share of each charge in the
+// period. With UserID only the debts of that user are listed.
+func (r *SubscriptionRepository) Settlement(filter models.TotalFilter) (*models.SettlementResponse, error) {
+	period := billing.NewPeriod(filter.StartDate, filter.EndDate, time.Now())
+	query, args := models.NewListQueryBuilder().
+		WithFilter(filter.SubscriptionFilter(period.From, period.To)).
+		WithShared().
+		BuildQuery()
+
+	conv := rates.NewConverter(r.rates, filter.Currency)
+	settlement := billing.NewSettlement(period, filter.Allocation, conv)
+	if err := r.eachPricedSubscription(query, args, filter.AsOf, settlement.Add); err != nil {
+		return nil, fmt.Errorf("failed to settle shared subscriptions: %w", err)
+	}
+
+	var userID *uuid.UUID
+	if filter.UserID != nil {
+		id := uuid.MustParse(*filter.UserID)
+		userID = &id
+	}
+	resp := &models.SettlementResponse{
+		StartDate:  period.From,
+		EndDate:    period.To,
+		Allocation: filter.Allocation,
+		Currency:   filter.Currency,
+		Debts:      settlement.Debts(userID),
+		Rates:      conv.Snapshot(),
+	}
+	return resp, nil
+}
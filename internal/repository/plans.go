@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"testtask/internal/models"
+)
+
+const planColumns = "id, service_id, name, price, currency, billing_period, interval_count, created_at, updated_at"
+
+func scanPlan(row rowScanner) (*models.Plan, error) {
+	var p models.Plan
+	if err := row.Scan(&p.ID, &p.ServiceID, &p.Name, &p.Price, &p.Currency, &p.BillingPeriod, &p.IntervalCount, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreatePlan adds a plan to service p.ServiceID. Plan names are unique
+// within a service.
+func (r *SubscriptionRepository) CreatePlan(p *models.Plan) error {
+	query := `
+		INSERT INTO plans (service_id, name, price, currency, billing_period, interval_count)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at`
+
+	if err := r.db.QueryRow(query, p.ServiceID, p.Name, p.Price, p.Currency, p.BillingPeriod, p.IntervalCount).
+		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return wrapError("failed to create plan", err)
+	}
+	r.logger.WithField("plan_id", p.ID).Info("Plan created")
+	return nil
+}
+
+func (r *SubscriptionRepository) GetPlan(serviceID, id int) (*models.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE id = $1 AND service_id = $2`
+	p, err := scanPlan(r.db.QueryRow(query, id, serviceID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("plan %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to get plan", err)
+	}
+	return p, nil
+}
+
+// ListPlans returns the plans of a service, cheapest first.
+func (r *SubscriptionRepository) ListPlans(serviceID int) ([]*models.Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE service_id = $1 ORDER BY price, name, id`
+	rows, err := r.db.Query(query, serviceID)
+	if err != nil {
+		return nil, wrapError("failed to list plans", err)
+	}
+	defer rows.Close()
+
+	plans := []*models.Plan{}
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, wrapError("failed to scan plan", err)
+		}
+		plans = append(plans, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list plans", err)
+	}
+	return plans, nil
+}
+
+// UpdatePlan replaces a plan and carries the change over to its
+// subscriptions: all of them take the new currency and billing period,
+// those that do not override the price take the new price.
+func (r *SubscriptionRepository) UpdatePlan(p *models.Plan, actor string) error {
+	query := `
+		UPDATE plans
+		SET name = $3, price = $4, currency = $5, billing_period = $6, interval_count = $7, updated_at = now()
+		WHERE id = $1 AND service_id = $2
+		RETURNING created_at, updated_at`
+
+	var changed []int
+	err := r.inTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(query, p.ID, p.ServiceID, p.Name, p.Price, p.Currency, p.BillingPeriod, p.IntervalCount).
+			Scan(&p.CreatedAt, &p.UpdatedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("plan %d: %w", p.ID, ErrNotFound)
+			}
+			return wrapError("failed to update plan", err)
+		}
+		var err error
+		changed, err = rewriteSubscriptions(tx,
+			`plan_id = $1 AND (currency <> $2 OR billing_period <> $3 OR interval_count <> $4 OR (NOT price_overridden AND price <> $5))`,
+			[]interface{}{p.ID, p.Currency, p.BillingPeriod, p.IntervalCount, p.Price},
+			`currency = $2, billing_period = $3, interval_count = $4, price = CASE WHEN price_overridden THEN price ELSE $5 END`,
+			[]interface{}{p.Currency, p.BillingPeriod, p.IntervalCount, p.Price},
+			actor)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.forgetSubscriptions(changed)
+	r.logger.WithField("plan_id", p.ID).Infof("Plan updated, %d subscriptions changed", len(changed))
+	return nil
+}
+
+// DeletePlan removes a plan. It fails with ErrConflict while
+// subscriptions, including those in the trash, are on it.
+func (r *SubscriptionRepository) DeletePlan(serviceID, id int) error {
+	result, err := r.db.Exec(`DELETE FROM plans WHERE id = $1 AND service_id = $2`, id, serviceID)
+	if err != nil {
+		return wrapError("failed to delete plan", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return wrapError("failed to get rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("plan %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// namePlans sets PlanName on the groups of a breakdown by plan, qualified
+// with the service name.
+func (r *SubscriptionRepository) namePlans(groups []models.BreakdownGroup) error {
+	var ids []int
+	for _, g := range groups {
+		if g.PlanID != nil {
+			ids = append(ids, *g.PlanID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	query := `
+		SELECT p.id, s.name || ' ' || p.name
+		FROM plans p
+		JOIN services s ON s.id = p.service_id
+		WHERE p.id = ANY($1)`
+
+	rows, err := r.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return wrapError("failed to get plan names", err)
+	}
+	defer rows.Close()
+	names := map[int]string{}
+	for rows.Next() {
+		var (
+			id   int
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return wrapError("failed to scan plan name", err)
+		}
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return wrapError("failed to get plan names", err)
+	}
+	for i, g := range groups {
+		if g.PlanID != nil {
+			groups[i].PlanName = names[*g.PlanID]
+		}
+	}
+	return nil
+}
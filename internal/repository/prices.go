@@ -0,0 +1,358 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	"testtask/internal/models"
+)
+
+// priceSchedules holds scheduled prices by subscription and by plan, each
+// list oldest first.
+type priceSchedules struct {
+	bySubscription map[int][]models.PriceChange
+	byPlan         map[int][]models.PriceChange
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func loadPriceSchedules(q querier, query string, args ...interface{}) (*priceSchedules, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, wrapError("failed to load scheduled prices", err)
+	}
+	defer rows.Close()
+
+	s := &priceSchedules{bySubscription: map[int][]models.PriceChange{}, byPlan: map[int][]models.PriceChange{}}
+	for rows.Next() {
+		var (
+			change         models.PriceChange
+			subscriptionID sql.NullInt64
+		)
+		if err := rows.Scan(&change.ID, &subscriptionID, &change.PlanID, &change.Price, &change.EffectiveFrom); err != nil {
+			return nil, wrapError("failed to scan scheduled price", err)
+		}
+		// A history snapshot lists the plan prices of a subscription with
+		// both ids set; they belong to that subscription alone.
+		if subscriptionID.Valid {
+			id := int(subscriptionID.Int64)
+			s.bySubscription[id] = append(s.bySubscription[id], change)
+		} else {
+			s.byPlan[*change.PlanID] = append(s.byPlan[*change.PlanID], change)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to load scheduled prices", err)
+	}
+	return s, nil
+}
+
+const priceScheduleQuery = `
+	SELECT id, subscription_id, plan_id, price, effective_from
+	FROM subscription_prices`
+
+// attach sets the price schedule of sub. Plan prices only apply when sub
+// does not override the plan price; on the same month the price of the
+// subscription wins.
+func (s *priceSchedules) attach(sub *models.Subscription) {
+	var schedule []models.PriceChange
+	if sub.PlanID != nil && !sub.PriceOverridden {
+		schedule = append(schedule, s.byPlan[*sub.PlanID]...)
+	}
+	schedule = append(schedule, s.bySubscription[sub.ID]...)
+	sort.SliceStable(schedule, func(i, j int) bool {
+		return schedule[i].EffectiveFrom.Before(schedule[j].EffectiveFrom.Time)
+	})
+	sub.PriceSchedule = schedule
+}
+
+// attachPriceSchedule loads the price schedule of one subscription.
+func (r *SubscriptionRepository) attachPriceSchedule(sub *models.Subscription) error {
+	schedules, err := loadPriceSchedules(r.db, priceScheduleQuery+`
+		WHERE subscription_id = $1 OR plan_id = $2
+		ORDER BY effective_from, id`, sub.ID, sub.PlanID)
+	if err != nil {
+		return err
+	}
+	schedules.attach(sub)
+	return nil
+}
+
+// priceScheduleAsOfQuery reads the price schedules kept in the history
+// snapshots at a moment, in their order. The placeholder of the moment
+// is filled in with fmt.Sprintf.
+const priceScheduleAsOfQuery = `
+	SELECT p.id, h.subscription_id, p.plan_id, p.price, p.effective_from
+	FROM (` + latestHistoryQuery + `) h
+	CROSS JOIN LATERAL ROWS FROM (
+		jsonb_to_recordset(h.after->'price_schedule') AS (id bigint, plan_id int, price int, effective_from date)
+	) WITH ORDINALITY AS p(id, plan_id, price, effective_from, n)`
+
+// loadDetails loads the price schedules and members of the subscriptions
+// selected by query: the current ones, or with asOf the ones kept in the
+// history at that moment. History entries recorded before snapshots
+// carried them have neither.
+func (r *SubscriptionRepository) loadDetails(query string, args []interface{}, asOf *time.Time) (*priceSchedules, map[int][]models.Member, error) {
+	if asOf != nil {
+		moment := fmt.Sprintf("$%d", len(args)+1)
+		args := append(append([]interface{}{}, args...), *asOf)
+		schedules, err := loadPriceSchedules(r.db, fmt.Sprintf(priceScheduleAsOfQuery, moment)+`
+			WHERE h.subscription_id IN (SELECT id FROM (`+query+`) selected)
+			ORDER BY h.subscription_id, p.n`, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		members, err := loadMembers(r.db, fmt.Sprintf(memberAsOfQuery, moment)+`
+			WHERE h.subscription_id IN (SELECT id FROM (`+query+`) selected)`, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return schedules, members, nil
+	}
+
+	schedules, err := loadPriceSchedules(r.db, priceScheduleQuery+`
+		WHERE subscription_id IN (SELECT id FROM (`+query+`) selected)
+		   OR plan_id IN (SELECT plan_id FROM (`+query+`) selected)
+		ORDER BY effective_from, id`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := loadMembers(r.db, memberQuery+`
+		WHERE subscription_id IN (SELECT id FROM (`+query+`) selected)`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schedules, members, nil
+}
+
+// eachPricedSubscription is eachSubscription with the price schedule and
+// the members of every subscription attached, for cost calculations. Only
+// the details of the subscriptions selected by query are loaded; asOf
+// must be the moment query reads the subscriptions at, if any.
+func (r *SubscriptionRepository) eachPricedSubscription(query string, args []interface{}, asOf *time.Time, fn func(*models.Subscription) error) error {
+	schedules, members, err := r.loadDetails(query, args, asOf)
+	if err != nil {
+		return err
+	}
+	return r.eachSubscription(query, args, func(sub *models.Subscription) error {
+		schedules.attach(sub)
+		sub.Members = members[sub.ID]
+		return fn(sub)
+	})
+}
+
+// SchedulePrices creates or replaces the scheduled prices described by req
+// in one transaction. A change expressed in percent is applied to the
+// price each target has in the month the change takes effect. Every
+// subscription whose schedule changes gets a new version and a history
+// entry.
+func (r *SubscriptionRepository) SchedulePrices(req models.PriceScheduleRequest, actor string) (*models.PriceScheduleResponse, error) {
+	resp := &models.PriceScheduleResponse{
+		EffectiveFrom:   req.EffectiveFrom,
+		Changes:         []models.PriceChange{},
+		SubscriptionIDs: []int{},
+	}
+	var affected []int
+	err := r.inTx(func(tx *sql.Tx) error {
+		var err error
+		if req.PlanID != nil && len(req.SubscriptionIDs) == 0 && req.ServiceName == nil {
+			affected, err = schedulePlanPrice(tx, req, resp, actor)
+		} else {
+			affected, err = scheduleSubscriptionPrices(tx, req, resp, actor)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Cached subscriptions carry their old schedule.
+	r.forgetSubscriptions(affected)
+	resp.Scheduled = len(resp.Changes)
+	r.logger.Infof("Scheduled %d price changes from %s", resp.Scheduled, req.EffectiveFrom)
+	return resp, nil
+}
+
+// scheduledPrice returns the price req sets when current is in effect.
+func scheduledPrice(req models.PriceScheduleRequest, current int) int {
+	if req.Price != nil {
+		return *req.Price
+	}
+	price := int(math.Round(float64(current) * (1 + *req.ChangePercent/100)))
+	if price < 1 {
+		price = 1
+	}
+	return price
+}
+
+// schedulePlanPrice schedules the change for the plan and returns the ids
+// of the subscriptions that follow its price.
+func schedulePlanPrice(tx *sql.Tx, req models.PriceScheduleRequest, resp *models.PriceScheduleResponse, actor string) ([]int, error) {
+	// The plan is read as a subscription that follows its list price.
+	plan := &models.Subscription{PlanID: req.PlanID}
+	err := tx.QueryRow(`SELECT price FROM plans WHERE id = $1 FOR UPDATE`, *req.PlanID).Scan(&plan.Price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("plan %d: %w", *req.PlanID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, wrapError("failed to get plan", err)
+	}
+	schedules, err := loadPriceSchedules(tx, priceScheduleQuery+` WHERE plan_id = $1 ORDER BY effective_from, id`, *req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	schedules.attach(plan)
+
+	ids, before, err := lockSnapshots(tx, `plan_id = $1 AND NOT price_overridden`, *req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	change := models.PriceChange{Price: scheduledPrice(req, plan.PriceOn(req.EffectiveFrom)), EffectiveFrom: req.EffectiveFrom, PlanID: req.PlanID}
+	if err := upsertPriceChange(tx, nil, &change); err != nil {
+		return nil, err
+	}
+	resp.Changes = append(resp.Changes, change)
+	if err := touchSubscriptions(tx, ids, before, actor); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// scheduleSubscriptionPrices schedules the change for every selected live
+// subscription and returns their ids. Listed ids that do not exist or do
+// not match the other selectors fail the whole schedule.
+func scheduleSubscriptionPrices(tx *sql.Tx, req models.PriceScheduleRequest, resp *models.PriceScheduleResponse, actor string) ([]int, error) {
+	query, args := models.NewListQueryBuilder().
+		WithFilter(models.SubscriptionFilter{ServiceName: req.ServiceName, PlanID: req.PlanID}).
+		WithIDs(req.SubscriptionIDs).
+		OrderBy("id", models.SortAsc).
+		BuildQuery()
+
+	rows, err := tx.Query(query+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, wrapError("failed to select subscriptions", err)
+	}
+	var subs []*models.Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			rows.Close()
+			return nil, wrapError("failed to scan subscription", err)
+		}
+		subs = append(subs, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to select subscriptions", err)
+	}
+	if len(req.SubscriptionIDs) > 0 && len(subs) != len(req.SubscriptionIDs) {
+		return nil, fmt.Errorf("%d of %d subscriptions do not exist or do not match: %w", len(req.SubscriptionIDs)-len(subs), len(req.SubscriptionIDs), ErrNotFound)
+	}
+
+	ids := make([]int, 0, len(subs))
+	planIDs := []int{}
+	for _, sub := range subs {
+		ids = append(ids, sub.ID)
+		if sub.PlanID != nil {
+			planIDs = append(planIDs, *sub.PlanID)
+		}
+	}
+	schedules, err := loadPriceSchedules(tx, priceScheduleQuery+`
+		WHERE subscription_id = ANY($1) OR plan_id = ANY($2)
+		ORDER BY effective_from, id`, pq.Array(ids), pq.Array(planIDs))
+	if err != nil {
+		return nil, err
+	}
+	_, before, err := lockSnapshots(tx, `id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		schedules.attach(sub)
+		change := models.PriceChange{Price: scheduledPrice(req, sub.PriceOn(req.EffectiveFrom)), EffectiveFrom: req.EffectiveFrom}
+		if err := upsertPriceChange(tx, &sub.ID, &change); err != nil {
+			return nil, err
+		}
+		resp.Changes = append(resp.Changes, change)
+		resp.SubscriptionIDs = append(resp.SubscriptionIDs, sub.ID)
+	}
+	if err := touchSubscriptions(tx, ids, before, actor); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// upsertPriceChange stores change for a subscription or, when
+// subscriptionID is nil, for change.PlanID, replacing a change scheduled
+// for the same month.
+func upsertPriceChange(tx *sql.Tx, subscriptionID *int, change *models.PriceChange) error {
+	conflict := "(subscription_id, effective_from)"
+	if subscriptionID == nil {
+		conflict = "(plan_id, effective_from)"
+	}
+	query := `
+		INSERT INTO subscription_prices (subscription_id, plan_id, price, effective_from)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ` + conflict + ` DO UPDATE SET price = EXCLUDED.price, created_at = now()
+		RETURNING id`
+
+	if err := tx.QueryRow(query, subscriptionID, change.PlanID, change.Price, change.EffectiveFrom).Scan(&change.ID); err != nil {
+		return wrapError("failed to schedule price", err)
+	}
+	return nil
+}
+
+// DeleteScheduledPrice cancels a scheduled price. Prices that took effect
+// before the current month are part of past totals and cannot be removed.
+// Every subscription whose schedule changes gets a new version and a
+// history entry.
+func (r *SubscriptionRepository) DeleteScheduledPrice(id int64, actor string) error {
+	var affected []int
+	err := r.inTx(func(tx *sql.Tx) error {
+		var (
+			subscriptionID sql.NullInt64
+			planID         sql.NullInt64
+			effectiveFrom  time.Time
+		)
+		err := tx.QueryRow(`
+			SELECT subscription_id, plan_id, effective_from FROM subscription_prices
+			WHERE id = $1
+			FOR UPDATE`, id).Scan(&subscriptionID, &planID, &effectiveFrom)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("price %d: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return wrapError("failed to get scheduled price", err)
+		}
+		if effectiveFrom.Before(models.MonthOf(time.Now()).Time) {
+			return fmt.Errorf("price %d took effect before the current month: %w", id, ErrConflict)
+		}
+
+		var before map[int][]byte
+		if subscriptionID.Valid {
+			affected, before, err = lockSnapshots(tx, `id = $1`, subscriptionID.Int64)
+		} else {
+			affected, before, err = lockSnapshots(tx, `plan_id = $1 AND NOT price_overridden`, planID.Int64)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM subscription_prices WHERE id = $1`, id); err != nil {
+			return wrapError("failed to delete scheduled price", err)
+		}
+		return touchSubscriptions(tx, affected, before, actor)
+	})
+	if err != nil {
+		return err
+	}
+	r.forgetSubscriptions(affected)
+	return nil
+}
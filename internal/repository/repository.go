@@ -4,10 +4,14 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"testtask/internal/billing"
 	"testtask/internal/cache"
 	"testtask/internal/config"
 	"testtask/internal/models"
+	"testtask/internal/rates"
+	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
@@ -16,12 +20,20 @@ type SubscriptionRepository struct {
 	db     *sql.DB
 	logger *logrus.Logger
 	cache  *cache.RedisClient
+	rates  *rates.Table
 }
 
 func NewSubscriptionRepository(db *sql.DB, logger *logrus.Logger, cacheClient *cache.RedisClient) *SubscriptionRepository {
 	return &SubscriptionRepository{db: db, logger: logger, cache: cacheClient}
 }
 
+// SetExchangeRates sets the rates totals are converted with. Without
+// rates only subscriptions already in the requested currency can be
+// summed.
+func (r *SubscriptionRepository) SetExchangeRates(table *rates.Table) {
+	r.rates = table
+}
+
 func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
@@ -38,12 +50,34 @@ func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
 	return db, nil
 }
 
-func (r *SubscriptionRepository) InsertSubscription(sub *models.Subscription) (int, error) {
+// inTx runs fn in a transaction that is committed when fn succeeds.
+func (r *SubscriptionRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return wrapError("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return wrapError("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// insertSubscription inserts sub and records its creation in the history.
+func insertSubscription(tx *sql.Tx, sub *models.Subscription, actor string) (int, error) {
 	var id int
 	query := `
-		INSERT INTO subscriptions (service_name, price, user_id, start_date, end_date)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`
+		INSERT INTO subscriptions (service_name, price, user_id, start_date, end_date, billing_period, interval_count, currency, service_id, plan_id, price_overridden, trial_end, discounts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, version, updated_at`
+
+	if err := resolveService(tx, sub); err != nil {
+		return 0, err
+	}
 
 	var endDate interface{}
 	if sub.EndDate != nil {
@@ -52,60 +86,121 @@ func (r *SubscriptionRepository) InsertSubscription(sub *models.Subscription) (i
 		endDate = nil
 	}
 
-	if err := r.db.QueryRow(
+	if err := tx.QueryRow(
 		query,
 		sub.ServiceName,
 		sub.Price,
 		sub.UserID,
 		sub.StartDate,
 		endDate,
-	).Scan(&id); err != nil {
-		r.logger.WithError(err).Error("Failed to create subscription")
-		return 0, fmt.Errorf("failed to create subscription: %w", err)
+		sub.BillingPeriod,
+		sub.IntervalCount,
+		sub.Currency,
+		sub.ServiceID,
+		sub.PlanID,
+		sub.PriceOverridden,
+		sub.TrialEnd,
+		sub.Discounts,
+	).Scan(&id, &sub.Version, &sub.UpdatedAt); err != nil {
+		return 0, wrapError("failed to create subscription", err)
 	}
-	r.logger.WithField("subscription_id", id).Info("Subscription created successfully")
+	if err := recordHistory(tx, id, models.HistoryCreate, nil, actor); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *SubscriptionRepository) cacheCreated(sub *models.Subscription, id int) {
 	if r.cache != nil {
 		sub.ID = id
+		if err := r.attachDetails(sub); err != nil {
+			r.logger.WithError(err).Warn("failed to load subscription details, subscription not cached")
+			return
+		}
 		if err := r.cache.SetSubscription(sub); err != nil {
 			r.logger.WithError(err).Warn("failed to set subscription in cache")
 		}
 	}
+}
+
+func (r *SubscriptionRepository) InsertSubscription(sub *models.Subscription, actor string) (int, error) {
+	var id int
+	err := r.inTx(func(tx *sql.Tx) error {
+		var err error
+		id, err = insertSubscription(tx, sub, actor)
+		return err
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to create subscription")
+		return 0, err
+	}
+	r.logger.WithField("subscription_id", id).Info("Subscription created successfully")
+	r.cacheCreated(sub, id)
 	return id, nil
 }
+
+// BatchError reports which row of a batch made it fail.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// InsertSubscriptions inserts all subs in a single transaction. If any row
+// fails nothing is inserted and a *BatchError is returned.
+func (r *SubscriptionRepository) InsertSubscriptions(subs []*models.Subscription, actor string) ([]int, error) {
+	ids := make([]int, len(subs))
+	err := r.inTx(func(tx *sql.Tx) error {
+		for i, sub := range subs {
+			id, err := insertSubscription(tx, sub, actor)
+			if err != nil {
+				r.logger.WithError(err).WithField("row", i).Error("Failed to import subscription")
+				return &BatchError{Index: i, Err: err}
+			}
+			ids[i] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, sub := range subs {
+		r.cacheCreated(sub, ids[i])
+	}
+	r.logger.WithField("count", len(subs)).Info("Subscriptions imported successfully")
+	return ids, nil
+}
 func (r *SubscriptionRepository) GetSubscriptionByID(id int) (*models.Subscription, error) {
 	if r.cache != nil {
 		if sub, err := r.cache.GetSubscription(id); err == nil && sub != nil {
 			r.logger.WithField("subscription_id", id).Info("Subscription loaded from cache")
+			sub.CurrentPrice = sub.PriceOn(models.MonthOf(time.Now()))
 			return sub, nil
 		} else if err != nil {
 			r.logger.WithError(err).WithField("subscription_id", id).Debug("Cache lookup failed; falling back to DB")
 		}
 	}
-	query := `
-		SELECT id, service_name, price, user_id, start_date, end_date
-		FROM subscriptions
-		WHERE id = $1`
-
-	sub := &models.Subscription{}
-	var endDate sql.NullTime
-	if err := r.db.QueryRow(query, id).Scan(
-		&sub.ID,
-		&sub.ServiceName,
-		&sub.Price,
-		&sub.UserID,
-		&sub.StartDate,
-		&endDate,
-	); err != nil {
+	query := `SELECT ` + models.SubscriptionColumns + ` FROM subscriptions WHERE id = $1 AND deleted_at IS NULL`
+
+	sub, err := scanSubscription(r.db.QueryRow(query, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("subscription not found")
+			return nil, fmt.Errorf("subscription %d: %w", id, ErrNotFound)
 		}
 		r.logger.WithError(err).WithField("subscription_id", id).Error("Failed to get subscription")
-		return nil, fmt.Errorf("failed to get subscription: %w", err)
+		return nil, wrapError("failed to get subscription", err)
 	}
-	if endDate.Valid {
-		t := endDate.Time
-		sub.EndDate = &t
+	if err := r.attachDetails(sub); err != nil {
+		return nil, err
 	}
+	sub.CurrentPrice = sub.PriceOn(models.MonthOf(time.Now()))
 	if r.cache != nil {
 		if err := r.cache.SetSubscription(sub); err != nil {
 			r.logger.WithError(err).Warn("failed to set subscription in cache")
@@ -116,22 +211,36 @@ func (r *SubscriptionRepository) GetSubscriptionByID(id int) (*models.Subscripti
 	r.logger.WithField("subscription_id", id).Info("Subscription loaded from database")
 	return sub, nil
 }
-func (r *SubscriptionRepository) DeleteSubscription(id int) error {
-	query := `DELETE FROM subscriptions WHERE id = $1`
 
-	result, err := r.db.Exec(query, id)
-	if err != nil {
-		r.logger.WithError(err).WithField("subscription_id", id).Error("Failed to delete subscription")
-		return fmt.Errorf("failed to delete subscription: %w", err)
-	}
+// DeleteSubscription moves the subscription to the trash. When version is
+// not nil the row is only deleted if it still has that version.
+func (r *SubscriptionRepository) DeleteSubscription(id int, version *int, actor string) error {
+	query := `
+		UPDATE subscriptions
+		SET deleted_at = now(), version = version + 1, updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL AND ($2::int IS NULL OR version = $2)`
 
-	rowsAffected, err := result.RowsAffected()
+	err := r.inTx(func(tx *sql.Tx) error {
+		before, err := lockSnapshot(tx, id, false)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(query, id, version)
+		if err != nil {
+			r.logger.WithError(err).WithField("subscription_id", id).Error("Failed to delete subscription")
+			return wrapError("failed to delete subscription", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return wrapError("failed to get rows affected", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("subscription %d: %w", id, ErrVersionMismatch)
+		}
+		return recordHistory(tx, id, models.HistoryDelete, before, actor)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("subscription not found")
+		return err
 	}
 	if r.cache != nil {
 		if err := r.cache.DeleteSubscription(id); err != nil {
@@ -143,7 +252,11 @@ func (r *SubscriptionRepository) DeleteSubscription(id int) error {
 	r.logger.WithField("subscription_id", id).Info("Subscription deleted successfully")
 	return nil
 }
-func (r *SubscriptionRepository) UpdateSubscription(subscription *models.Subscription) error {
+
+// UpdateSubscription writes subscription back if its row still has
+// subscription.Version, and bumps the version. A concurrent change makes
+// it fail with ErrVersionMismatch.
+func (r *SubscriptionRepository) UpdateSubscription(subscription *models.Subscription, actor string) error {
 	if r.cache != nil {
 		if err := r.cache.DeleteSubscription(subscription.ID); err != nil {
 			r.logger.WithError(err).Warn("failed to delete subscription from cache during update")
@@ -152,9 +265,12 @@ func (r *SubscriptionRepository) UpdateSubscription(subscription *models.Subscri
 	}
 	query := `
 		UPDATE subscriptions
-		SET service_name = $2, price = $3, start_date = $4, end_date = $5, user_id = $6
-		WHERE id = $1
-		RETURNING id`
+		SET service_name = $2, price = $3, start_date = $4, end_date = $5, user_id = $6,
+			billing_period = $8, interval_count = $9, currency = $10, service_id = $11,
+			plan_id = $12, price_overridden = $13, trial_end = $14, discounts = $15,
+			version = version + 1, updated_at = now()
+		WHERE id = $1 AND version = $7 AND deleted_at IS NULL
+		RETURNING version, updated_at`
 
 	var endDate interface{}
 	if subscription.EndDate != nil {
@@ -163,22 +279,49 @@ func (r *SubscriptionRepository) UpdateSubscription(subscription *models.Subscri
 		endDate = nil
 	}
 
-	var returnedId int
-	if err := r.db.QueryRow(
-		query,
-		subscription.ID,
-		subscription.ServiceName,
-		subscription.Price,
-		subscription.StartDate,
-		endDate,
-		subscription.UserID,
-	).Scan(&returnedId); err != nil {
-		r.logger.WithError(err).WithField("subscription_id", subscription.ID).Error("Failed to update subscription")
-		return fmt.Errorf("failed to update subscription: %w", err)
+	err := r.inTx(func(tx *sql.Tx) error {
+		before, err := lockSnapshot(tx, subscription.ID, false)
+		if err != nil {
+			return err
+		}
+		if err := resolveService(tx, subscription); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(
+			query,
+			subscription.ID,
+			subscription.ServiceName,
+			subscription.Price,
+			subscription.StartDate,
+			endDate,
+			subscription.UserID,
+			subscription.Version,
+			subscription.BillingPeriod,
+			subscription.IntervalCount,
+			subscription.Currency,
+			subscription.ServiceID,
+			subscription.PlanID,
+			subscription.PriceOverridden,
+			subscription.TrialEnd,
+			subscription.Discounts,
+		).Scan(&subscription.Version, &subscription.UpdatedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("subscription %d: %w", subscription.ID, ErrVersionMismatch)
+			}
+			r.logger.WithError(err).WithField("subscription_id", subscription.ID).Error("Failed to update subscription")
+			return wrapError("failed to update subscription", err)
+		}
+		return recordHistory(tx, subscription.ID, models.HistoryUpdate, before, actor)
+	})
+	if err != nil {
+		return err
 	}
 
 	if r.cache != nil {
-		if err := r.cache.SetSubscription(subscription); err != nil {
+		// A new plan brings a different price schedule.
+		if err := r.attachDetails(subscription); err != nil {
+			r.logger.WithError(err).Warn("failed to load subscription details")
+		} else if err := r.cache.SetSubscription(subscription); err != nil {
 			r.logger.WithError(err).Warn("failed to update subscription in cache")
 		} else {
 			r.logger.WithField("subscription_id", subscription.ID).Debug("Subscription updated in cache")
@@ -188,48 +331,224 @@ func (r *SubscriptionRepository) UpdateSubscription(subscription *models.Subscri
 	r.logger.WithField("subscription_id", subscription.ID).Info("Subscription updated successfully")
 	return nil
 }
-func (r *SubscriptionRepository) GetAllSubscription() ([]*models.Subscription, error) {
 
-	rows, err := r.db.Query("SELECT id, service_name, price, user_id, start_date, end_date FROM subscriptions")
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row rowScanner) (*models.Subscription, error) {
+	s := &models.Subscription{}
+	if err := row.Scan(&s.ID, &s.ServiceName, &s.ServiceID, &s.PlanID, &s.Price, &s.PriceOverridden, &s.Currency, &s.UserID, &s.StartDate, &s.EndDate, &s.BillingPeriod, &s.IntervalCount, &s.TrialEnd, &s.Discounts, &s.Version, &s.UpdatedAt, &s.DeletedAt); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// eachSubscription streams the rows of query to fn without buffering them.
+func (r *SubscriptionRepository) eachSubscription(query string, args []interface{}, fn func(*models.Subscription) error) error {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+		return wrapError("failed to query subscriptions", err)
 	}
 	defer rows.Close()
-	subs := []*models.Subscription{}
 
 	for rows.Next() {
-		s := &models.Subscription{}
-		var endDate sql.NullTime
-		if err := rows.Scan(&s.ID, &s.ServiceName, &s.Price, &s.UserID, &s.StartDate, &endDate); err != nil {
-			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return wrapError("failed to scan subscription", err)
 		}
-		if endDate.Valid {
-			t := endDate.Time
-			s.EndDate = &t
+		if err := fn(s); err != nil {
+			return err
 		}
-		subs = append(subs, s)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+		return wrapError("row iteration error", err)
 	}
-	return subs, nil
+	return nil
 }
 
-func (r *SubscriptionRepository) SumTotalSubscriptions(startDate, endDate *string, userID *string, serviceName *string) (int64, error) {
-	builder := models.NewQueryBuilder().
-		WithStartDate(startDate).
-		WithEndDate(endDate).
-		WithUserId(userID).
-		WithServiceName(serviceName)
+func (r *SubscriptionRepository) GetAllSubscription(params models.ListParams) (*models.SubscriptionList, error) {
+	countQuery, countArgs := models.NewCountQueryBuilder().
+		WithFilter(params.Filter).
+		BuildQuery()
+	var total int64
+	if err := r.db.QueryRow(countQuery, countArgs...).Scan(&total); err != nil {
+		return nil, wrapError("failed to count subscriptions", err)
+	}
 
+	builder := models.NewListQueryBuilder().
+		WithFilter(params.Filter).
+		WithCursor(params.SortBy, params.Order, params.Cursor).
+		OrderBy(params.SortBy, params.Order).
+		WithLimit(params.Limit + 1)
+	if params.Cursor == nil {
+		builder.WithOffset(params.Offset)
+	}
 	query, args := builder.BuildQuery()
 
-	var total sql.NullInt64
-	if err := r.db.QueryRow(query, args...).Scan(&total); err != nil {
-		return 0, fmt.Errorf("failed to sum subscriptions: %w", err)
+	subs := []*models.Subscription{}
+	if err := r.eachSubscription(query, args, func(s *models.Subscription) error {
+		subs = append(subs, s)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
-	if !total.Valid {
-		return 0, nil
+
+	list := &models.SubscriptionList{Items: subs, Total: total}
+	if len(subs) > params.Limit {
+		list.Items = subs[:params.Limit]
+		list.Next = models.NewCursor(params.SortBy, params.Order, list.Items[params.Limit-1])
+	}
+	return list, nil
+}
+
+// SumTotalSubscriptions charges every matching subscription its price for
+// each month it is active inside the requested period. For one user only
+// their share of shared subscriptions is charged.
+func (r *SubscriptionRepository) SumTotalSubscriptions(filter models.TotalFilter) (*models.TotalResponse, error) {
+	period := billing.NewPeriod(filter.StartDate, filter.EndDate, time.Now())
+	query, args := models.NewListQueryBuilder().
+		WithFilter(filter.SubscriptionFilter(period.From, period.To)).
+		BuildQuery()
+
+	conv := rates.NewConverter(r.rates, filter.Currency)
+	resp := &models.TotalResponse{Allocation: filter.Allocation, Currency: filter.Currency}
+	var member *uuid.UUID
+	if filter.UserID != nil {
+		id := uuid.MustParse(*filter.UserID)
+		member = &id
+	}
+	var total float64
+	if err := r.eachPricedSubscription(query, args, filter.AsOf, func(s *models.Subscription) error {
+		months := 0
+		var convErr error
+		billing.EachCharge(s, period, filter.Allocation, func(month models.MonthDate, amount float64) {
+			if member != nil {
+				share := billing.ShareOf(s, month, *member)
+				if share == 0 {
+					return
+				}
+				amount *= share
+			}
+			months++
+			if convErr != nil {
+				return
+			}
+			amount, convErr = conv.Convert(amount, s.Currency, month.Time)
+			total += amount
+		})
+		if convErr != nil {
+			return fmt.Errorf("subscription %d: %w", s.ID, convErr)
+		}
+		if months == 0 {
+			return nil
+		}
+		resp.Subscriptions++
+		resp.Months += months
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to sum subscriptions: %w", err)
+	}
+	resp.Total = billing.Round(total)
+	resp.Rates = conv.Snapshot()
+	return resp, nil
+}
+
+// SpendBreakdown splits the total of SumTotalSubscriptions into groups.
+func (r *SubscriptionRepository) SpendBreakdown(filter models.TotalFilter, groupBy []string) (*models.BreakdownResponse, error) {
+	period := billing.NewPeriod(filter.StartDate, filter.EndDate, time.Now())
+	query, args := models.NewListQueryBuilder().
+		WithFilter(filter.SubscriptionFilter(period.From, period.To)).
+		BuildQuery()
+
+	conv := rates.NewConverter(r.rates, filter.Currency)
+	breakdown := billing.NewBreakdown(period, filter.Allocation, conv, groupBy)
+	if filter.UserID != nil {
+		breakdown.OnlyShareOf(uuid.MustParse(*filter.UserID))
+	}
+	if err := r.eachPricedSubscription(query, args, filter.AsOf, breakdown.Add); err != nil {
+		return nil, fmt.Errorf("failed to build spend breakdown: %w", err)
+	}
+	resp := breakdown.Result()
+	if err := r.namePlans(resp.Groups); err != nil {
+		return nil, err
+	}
+	resp.GroupBy = groupBy
+	resp.Currency = filter.Currency
+	resp.Rates = conv.Snapshot()
+	return resp, nil
+}
+
+func (r *SubscriptionRepository) ExportSubscriptions(params models.ListParams, fn func(*models.Subscription) error) error {
+	query, args := models.NewListQueryBuilder().
+		WithFilter(params.Filter).
+		OrderBy(params.SortBy, params.Order).
+		BuildQuery()
+	return r.eachSubscription(query, args, fn)
+}
+
+// RestoreSubscription takes a subscription out of the trash.
+func (r *SubscriptionRepository) RestoreSubscription(id int, actor string) (*models.Subscription, error) {
+	query := `
+		UPDATE subscriptions
+		SET deleted_at = NULL, version = version + 1, updated_at = now()
+		WHERE id = $1
+		RETURNING ` + models.SubscriptionColumns
+
+	var sub *models.Subscription
+	err := r.inTx(func(tx *sql.Tx) error {
+		before, err := lockSnapshot(tx, id, true)
+		if err != nil {
+			return err
+		}
+		if sub, err = scanSubscription(tx.QueryRow(query, id)); err != nil {
+			r.logger.WithError(err).WithField("subscription_id", id).Error("Failed to restore subscription")
+			return wrapError("failed to restore subscription", err)
+		}
+		return recordHistory(tx, id, models.HistoryRestore, before, actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.attachDetails(sub); err != nil {
+		return nil, err
+	}
+	sub.CurrentPrice = sub.PriceOn(models.MonthOf(time.Now()))
+	r.logger.WithField("subscription_id", id).Info("Subscription restored successfully")
+	return sub, nil
+}
+
+// PurgeDeletedSubscriptions permanently removes subscriptions that have
+// been in the trash for longer than retention. The last state of each one
+// stays in its history and is sent to webhooks.
+func (r *SubscriptionRepository) PurgeDeletedSubscriptions(retention time.Duration) (int64, error) {
+	// Every part of the statement sees the rows as they were before the
+	// delete, so the snapshots still have the prices and members that
+	// are deleted with the subscriptions.
+	query := `
+		WITH expired AS (
+			SELECT s.id, ` + snapshotExpression + ` AS snapshot FROM subscriptions s
+			WHERE s.deleted_at < now() - make_interval(secs => $1)
+			FOR UPDATE
+		), purged AS (
+			DELETE FROM subscriptions
+			WHERE id IN (SELECT id FROM expired)
+			RETURNING id
+		), entries AS (
+			INSERT INTO subscription_history (subscription_id, operation, before, after, actor)
+			SELECT x.id, $2, x.snapshot, NULL, $3 FROM expired x JOIN purged p ON p.id = x.id
+			RETURNING *
+		)
+		INSERT INTO webhook_events (event_type, subscription_id, payload)
+		SELECT $4, e.subscription_id, to_jsonb(e) FROM entries e`
+
+	result, err := r.db.Exec(query, retention.Seconds(), models.HistoryPurge, models.SystemActor, models.EventSubscriptionPurged)
+	if err != nil {
+		return 0, wrapError("failed to purge subscriptions", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapError("failed to get rows affected", err)
 	}
-	return total.Int64, nil
+	return purged, nil
 }
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"testtask/internal/cache"
+	"testtask/internal/models"
+	"testtask/internal/testdb"
+)
+
+var (
+	owner = uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	alice = uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+)
+
+// newTestRepository returns a repository on a fresh database, skipping the
+// test when there is none. cacheClient may be nil.
+func newTestRepository(t *testing.T, cacheClient *cache.RedisClient) *SubscriptionRepository {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewSubscriptionRepository(testdb.Open(t), logger, cacheClient)
+}
+
+func month(t *testing.T, s string) models.MonthDate {
+	t.Helper()
+	m, err := models.ParseMonthDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// insertTestSubscription stores a monthly subscription of userID from start
+// on and returns its id.
+func insertTestSubscription(t *testing.T, repo *SubscriptionRepository, userID uuid.UUID, name string, price int, start string) int {
+	t.Helper()
+	sub := &models.Subscription{
+		ServiceName:   name,
+		Price:         price,
+		Currency:      "RUB",
+		UserID:        userID,
+		StartDate:     month(t, start),
+		BillingPeriod: models.BillingMonth,
+		IntervalCount: 1,
+	}
+	id, err := repo.InsertSubscription(sub, "test")
+	if err != nil {
+		t.Fatalf("InsertSubscription: %v", err)
+	}
+	return id
+}
@@ -0,0 +1,366 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+
+	"testtask/internal/models"
+)
+
+const serviceQuery = `
+	SELECT s.id, s.name, s.category, s.default_price, s.created_at, s.updated_at,
+		COALESCE(array_agg(n.alias ORDER BY n.alias) FILTER (WHERE n.alias IS NOT NULL), '{}')
+	FROM services s
+	LEFT JOIN service_names n ON n.service_id = s.id`
+
+func scanService(row rowScanner) (*models.Service, error) {
+	var (
+		s       models.Service
+		aliases pq.StringArray
+	)
+	if err := row.Scan(&s.ID, &s.Name, &s.Category, &s.DefaultPrice, &s.CreatedAt, &s.UpdatedAt, &aliases); err != nil {
+		return nil, err
+	}
+	s.Aliases = aliases
+	return &s, nil
+}
+
+// resolveService links sub to the catalog service its name matches and
+// stores it under the canonical name. Names missing from the catalog are
+// kept as they are. A subscription without a price takes the default
+// price of its service.
+func resolveService(tx *sql.Tx, sub *models.Subscription) error {
+	if sub.PlanID != nil {
+		return resolvePlan(tx, sub)
+	}
+	sub.PriceOverridden = false
+	query := `
+		SELECT s.id, s.name, s.default_price
+		FROM service_names n
+		JOIN services s ON s.id = n.service_id
+		WHERE n.key = $1`
+
+	var (
+		id           int
+		name         string
+		defaultPrice sql.NullInt64
+	)
+	err := tx.QueryRow(query, models.ServiceKey(sub.ServiceName)).Scan(&id, &name, &defaultPrice)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		sub.ServiceID = nil
+	case err != nil:
+		return wrapError("failed to resolve service", err)
+	default:
+		sub.ServiceID = &id
+		sub.ServiceName = name
+		if sub.Price == 0 && defaultPrice.Valid {
+			sub.Price = int(defaultPrice.Int64)
+		}
+	}
+	if sub.Price == 0 {
+		return fmt.Errorf("service %q has no default price, price is required: %w", sub.ServiceName, ErrValidation)
+	}
+	return nil
+}
+
+// resolvePlan applies the plan of sub: its service, currency, billing
+// period and, unless overridden, price. A service_name naming another
+// service is rejected.
+func resolvePlan(tx *sql.Tx, sub *models.Subscription) error {
+	query := `
+		SELECT p.service_id, s.name, p.price, p.currency, p.billing_period, p.interval_count
+		FROM plans p
+		JOIN services s ON s.id = p.service_id
+		WHERE p.id = $1`
+
+	var (
+		plan models.Plan
+		name string
+	)
+	err := tx.QueryRow(query, *sub.PlanID).Scan(&plan.ServiceID, &name, &plan.Price, &plan.Currency, &plan.BillingPeriod, &plan.IntervalCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("plan %d does not exist: %w", *sub.PlanID, ErrValidation)
+	}
+	if err != nil {
+		return wrapError("failed to resolve plan", err)
+	}
+	if sub.ServiceName != "" && models.ServiceKey(sub.ServiceName) != models.ServiceKey(name) {
+		var other int
+		err := tx.QueryRow(`SELECT service_id FROM service_names WHERE key = $1`, models.ServiceKey(sub.ServiceName)).Scan(&other)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return wrapError("failed to resolve service", err)
+		}
+		if other != plan.ServiceID {
+			return fmt.Errorf("plan %d belongs to service %q, not %q: %w", *sub.PlanID, name, sub.ServiceName, ErrValidation)
+		}
+	}
+	sub.ServiceID = &plan.ServiceID
+	sub.ServiceName = name
+	sub.Currency = plan.Currency
+	sub.BillingPeriod = plan.BillingPeriod
+	sub.IntervalCount = plan.IntervalCount
+	if !sub.PriceOverridden || sub.Price == 0 {
+		sub.Price = plan.Price
+		sub.PriceOverridden = false
+	}
+	return nil
+}
+
+// insertServiceNames registers the lookup keys of s. A key that is taken
+// by another service fails with ErrConflict.
+func insertServiceNames(tx *sql.Tx, s *models.Service) error {
+	query := `INSERT INTO service_names (key, service_id, alias) VALUES ($1, $2, $3)`
+
+	if _, err := tx.Exec(query, models.ServiceKey(s.Name), s.ID, nil); err != nil {
+		return wrapError(fmt.Sprintf("failed to register service name %q", s.Name), err)
+	}
+	for _, alias := range s.Aliases {
+		if _, err := tx.Exec(query, models.ServiceKey(alias), s.ID, alias); err != nil {
+			return wrapError(fmt.Sprintf("failed to register alias %q", alias), err)
+		}
+	}
+	return nil
+}
+
+func (r *SubscriptionRepository) CreateService(s *models.Service) error {
+	query := `
+		INSERT INTO services (name, category, default_price)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at`
+
+	err := r.inTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(query, s.Name, s.Category, s.DefaultPrice).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return wrapError("failed to create service", err)
+		}
+		return insertServiceNames(tx, s)
+	})
+	if err != nil {
+		return err
+	}
+	r.logger.WithField("service_id", s.ID).Info("Service created")
+	return nil
+}
+
+func (r *SubscriptionRepository) GetService(id int) (*models.Service, error) {
+	s, err := scanService(r.db.QueryRow(serviceQuery+` WHERE s.id = $1 GROUP BY s.id`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("service %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to get service", err)
+	}
+	return s, nil
+}
+
+// ListServices returns the catalog ordered by name, optionally narrowed to
+// one category.
+func (r *SubscriptionRepository) ListServices(category *string) ([]*models.Service, error) {
+	rows, err := r.db.Query(serviceQuery+` WHERE $1::text IS NULL OR s.category = $1 GROUP BY s.id ORDER BY s.name, s.id`, category)
+	if err != nil {
+		return nil, wrapError("failed to list services", err)
+	}
+	defer rows.Close()
+
+	services := []*models.Service{}
+	for rows.Next() {
+		s, err := scanService(rows)
+		if err != nil {
+			return nil, wrapError("failed to scan service", err)
+		}
+		services = append(services, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list services", err)
+	}
+	return services, nil
+}
+
+// UpdateService replaces the catalog entry. When the canonical name
+// changes, the linked subscriptions are renamed with it. Linked
+// subscriptions whose name, category or name keys change get a new
+// version and a history entry, so that as_of reads see the catalog as it
+// was.
+func (r *SubscriptionRepository) UpdateService(s *models.Service, actor string) error {
+	query := `
+		UPDATE services
+		SET name = $2, category = $3, default_price = $4, updated_at = now()
+		WHERE id = $1
+		RETURNING created_at, updated_at`
+
+	var changed []int
+	err := r.inTx(func(tx *sql.Tx) error {
+		ids, before, err := lockSnapshots(tx, `service_id = $1`, s.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(query, s.ID, s.Name, s.Category, s.DefaultPrice).Scan(&s.CreatedAt, &s.UpdatedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("service %d: %w", s.ID, ErrNotFound)
+			}
+			return wrapError("failed to update service", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM service_names WHERE service_id = $1`, s.ID); err != nil {
+			return wrapError("failed to update service names", err)
+		}
+		if err := insertServiceNames(tx, s); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE subscriptions SET service_name = $2 WHERE service_id = $1 AND service_name <> $2`, s.ID, s.Name); err != nil {
+			return wrapError("failed to rename subscriptions", err)
+		}
+		changed, err = touchChangedSubscriptions(tx, ids, before, actor)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.forgetSubscriptions(changed)
+	r.logger.WithField("service_id", s.ID).Infof("Service updated, %d subscriptions changed", len(changed))
+	return nil
+}
+
+// DeleteService removes a catalog entry. It fails with ErrConflict while
+// subscriptions, including those in the trash, are linked to it.
+func (r *SubscriptionRepository) DeleteService(id int) error {
+	result, err := r.db.Exec(`DELETE FROM services WHERE id = $1`, id)
+	if err != nil {
+		return wrapError("failed to delete service", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return wrapError("failed to get rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("service %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// linkSubscriptions links the subscriptions matching where, live or in the
+// trash, to service serviceID under name.
+func linkSubscriptions(tx *sql.Tx, where string, args []interface{}, serviceID int, name, actor string) ([]int, error) {
+	return rewriteSubscriptions(tx, where, args, `service_id = $2, service_name = $3`, []interface{}{serviceID, name}, actor)
+}
+
+// rewriteSubscriptions applies set to the subscriptions matching where,
+// live or in the trash. The placeholders of set start at $2. Every changed
+// row gets a new version and a history entry. The ids of the changed rows
+// are returned.
+func rewriteSubscriptions(tx *sql.Tx, where string, whereArgs []interface{}, set string, setArgs []interface{}, actor string) ([]int, error) {
+	ids, before, err := lockSnapshots(tx, where, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE subscriptions
+		SET ` + set + `, version = version + 1, updated_at = now()
+		WHERE id = $1`
+
+	for _, id := range ids {
+		args := append([]interface{}{id}, setArgs...)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, wrapError("failed to update subscription", err)
+		}
+		if err := recordHistory(tx, id, models.HistoryUpdate, before[id], actor); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func (r *SubscriptionRepository) forgetSubscriptions(ids []int) {
+	if r.cache == nil {
+		return
+	}
+	for _, id := range ids {
+		if err := r.cache.DeleteSubscription(id); err != nil {
+			r.logger.WithError(err).Warn("failed to delete subscription from cache")
+		}
+	}
+}
+
+// BackfillServices links subscriptions stored before the catalog existed.
+// Names that match no service are reported as unmatched, or, when create
+// is set, added to the catalog under their most used spelling.
+func (r *SubscriptionRepository) BackfillServices(create bool, actor string) (*models.BackfillReport, error) {
+	query := `
+		SELECT service_name, COUNT(*) FROM subscriptions
+		WHERE service_id IS NULL
+		GROUP BY service_name
+		ORDER BY COUNT(*) DESC, service_name`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, wrapError("failed to list unlinked services", err)
+	}
+	spellings := map[string][]string{}
+	var keys []string
+	for rows.Next() {
+		var (
+			name  string
+			count int
+		)
+		if err := rows.Scan(&name, &count); err != nil {
+			rows.Close()
+			return nil, wrapError("failed to scan service name", err)
+		}
+		key := models.ServiceKey(name)
+		if _, ok := spellings[key]; !ok {
+			keys = append(keys, key)
+		}
+		spellings[key] = append(spellings[key], name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list unlinked services", err)
+	}
+
+	report := &models.BackfillReport{Unmatched: []string{}}
+	for _, key := range keys {
+		names := spellings[key]
+		var linked []int
+		err := r.inTx(func(tx *sql.Tx) error {
+			var (
+				id        int
+				canonical string
+			)
+			err := tx.QueryRow(`
+				SELECT s.id, s.name FROM service_names n JOIN services s ON s.id = n.service_id
+				WHERE n.key = $1`, key).Scan(&id, &canonical)
+			switch {
+			case errors.Is(err, sql.ErrNoRows) && !create:
+				report.Unmatched = append(report.Unmatched, names...)
+				return nil
+			case errors.Is(err, sql.ErrNoRows):
+				// Spellings are ordered by use, the first one wins.
+				s := &models.Service{Name: names[0]}
+				if err := tx.QueryRow(`INSERT INTO services (name) VALUES ($1) RETURNING id`, s.Name).Scan(&s.ID); err != nil {
+					return wrapError("failed to create service", err)
+				}
+				if err := insertServiceNames(tx, s); err != nil {
+					return err
+				}
+				id, canonical = s.ID, s.Name
+				report.ServicesCreated++
+			case err != nil:
+				return wrapError("failed to resolve service", err)
+			}
+			linked, err = linkSubscriptions(tx, `service_id IS NULL AND service_name = ANY($1)`, []interface{}{pq.Array(names)}, id, canonical, actor)
+			return err
+		})
+		if err != nil {
+			return report, fmt.Errorf("service %q: %w", names[0], err)
+		}
+		r.forgetSubscriptions(linked)
+		report.SubscriptionsLinked += len(linked)
+	}
+	sort.Strings(report.Unmatched)
+	r.logger.WithField("linked", report.SubscriptionsLinked).Infof("Backfilled services, %d created, %d names unmatched", report.ServicesCreated, len(report.Unmatched))
+	return report, nil
+}
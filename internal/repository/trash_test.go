@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func TestDeleteAndRestore(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	id := insertTestSubscription(t, repo, owner, "Netflix", 400, "01-2025")
+	if _, err := repo.ReplaceMembers(id, nil, []models.Member{{UserID: alice, Weight: 1}}, "test"); err != nil {
+		t.Fatalf("ReplaceMembers: %v", err)
+	}
+	price := 500
+	if _, err := repo.SchedulePrices(models.PriceScheduleRequest{
+		EffectiveFrom: month(t, "01-2024"), Price: &price, SubscriptionIDs: []int{id},
+	}, "test"); err != nil {
+		t.Fatalf("SchedulePrices: %v", err)
+	}
+	live, err := repo.GetSubscriptionByID(id)
+	if err != nil {
+		t.Fatalf("GetSubscriptionByID: %v", err)
+	}
+
+	stale := live.Version - 1
+	if err := repo.DeleteSubscription(id, &stale, "test"); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("delete of a stale version = %v, want ErrVersionMismatch", err)
+	}
+	if err := repo.DeleteSubscription(id, &live.Version, "test"); err != nil {
+		t.Fatalf("DeleteSubscription: %v", err)
+	}
+	if _, err := repo.GetSubscriptionByID(id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted subscription = %v, want ErrNotFound", err)
+	}
+	trash, err := repo.GetAllSubscription(models.ListParams{Filter: models.SubscriptionFilter{Deleted: true}})
+	if err != nil || trash.Total != 1 || trash.Items[0].ID != id {
+		t.Fatalf("trash = %+v, %v", trash, err)
+	}
+	if err := repo.DeleteSubscription(id, nil, "test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete = %v, want ErrNotFound", err)
+	}
+
+	restored, err := repo.RestoreSubscription(id, "test")
+	if err != nil {
+		t.Fatalf("RestoreSubscription: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Version != live.Version+2 {
+		t.Errorf("restored = %+v", restored)
+	}
+	if len(restored.Members) != 1 || restored.Members[0].UserID != alice || len(restored.PriceSchedule) != 1 || restored.CurrentPrice != 500 {
+		t.Errorf("restored without details: members %+v, schedule %+v, current price %d",
+			restored.Members, restored.PriceSchedule, restored.CurrentPrice)
+	}
+	if _, err := repo.RestoreSubscription(id, "test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore of a live subscription = %v, want ErrNotFound", err)
+	}
+
+	history, err := repo.GetSubscriptionHistory(id)
+	if err != nil {
+		t.Fatalf("GetSubscriptionHistory: %v", err)
+	}
+	var operations []string
+	for _, entry := range history {
+		operations = append(operations, entry.Operation)
+	}
+	want := []string{models.HistoryCreate, models.HistoryUpdate, models.HistoryUpdate, models.HistoryDelete, models.HistoryRestore}
+	if len(operations) != len(want) {
+		t.Fatalf("operations = %v, want %v", operations, want)
+	}
+	for i := range want {
+		if operations[i] != want[i] {
+			t.Fatalf("operations = %v, want %v", operations, want)
+		}
+	}
+}
+
+func TestPurgeKeepsLastState(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	expired := insertTestSubscription(t, repo, owner, "Netflix", 400, "01-2025")
+	recent := insertTestSubscription(t, repo, owner, "Spotify", 300, "01-2025")
+	live := insertTestSubscription(t, repo, owner, "Kinopoisk", 200, "01-2025")
+	if _, err := repo.ReplaceMembers(expired, nil, []models.Member{{UserID: alice, Amount: 100}}, "test"); err != nil {
+		t.Fatalf("ReplaceMembers: %v", err)
+	}
+	for _, id := range []int{expired, recent} {
+		if err := repo.DeleteSubscription(id, nil, "test"); err != nil {
+			t.Fatalf("DeleteSubscription: %v", err)
+		}
+	}
+	if _, err := repo.db.Exec(`UPDATE subscriptions SET deleted_at = now() - interval '2 days' WHERE id = $1`, expired); err != nil {
+		t.Fatalf("failed to age the deletion: %v", err)
+	}
+
+	purged, err := repo.PurgeDeletedSubscriptions(24 * time.Hour)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedSubscriptions = %d, %v, want 1", purged, err)
+	}
+	if _, err := repo.RestoreSubscription(expired, "test"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore of a purged subscription = %v, want ErrNotFound", err)
+	}
+	for _, id := range []int{recent, live} {
+		var n int
+		if err := repo.db.QueryRow(`SELECT count(*) FROM subscriptions WHERE id = $1`, id).Scan(&n); err != nil || n != 1 {
+			t.Errorf("subscription %d was purged too: %d, %v", id, n, err)
+		}
+	}
+
+	history, err := repo.GetSubscriptionHistory(expired)
+	if err != nil {
+		t.Fatalf("GetSubscriptionHistory: %v", err)
+	}
+	last := history[len(history)-1]
+	if last.Operation != models.HistoryPurge || last.Actor != models.SystemActor || string(last.After) != "null" {
+		t.Fatalf("last entry = %+v", last)
+	}
+	var before struct {
+		ID            int               `json:"id"`
+		DeletedAt     *string           `json:"deleted_at"`
+		PriceSchedule []json.RawMessage `json:"price_schedule"`
+		Members       []models.Member   `json:"members"`
+	}
+	if err := json.Unmarshal(last.Before, &before); err != nil {
+		t.Fatalf("invalid before state: %v", err)
+	}
+	// The members are deleted with the subscription but kept in its
+	// last state.
+	if before.ID != expired || before.DeletedAt == nil || before.PriceSchedule == nil ||
+		len(before.Members) != 1 || before.Members[0].Amount != 100 {
+		t.Errorf("before state = %s", last.Before)
+	}
+
+	var events int
+	if err := repo.db.QueryRow(`SELECT count(*) FROM webhook_events WHERE event_type = $1 AND subscription_id = $2`,
+		models.EventSubscriptionPurged, expired).Scan(&events); err != nil || events != 1 {
+		t.Errorf("purge events = %d, %v, want 1", events, err)
+	}
+}
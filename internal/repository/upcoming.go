@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"testtask/internal/billing"
+	"testtask/internal/models"
+)
+
+// Upcoming lists the renewals and expirations of live subscriptions
+// matching filter between from and to.
+func (r *SubscriptionRepository) Upcoming(filter models.SubscriptionFilter, from, to time.Time) (*models.UpcomingResponse, error) {
+	first, last := models.MonthOf(from), models.MonthOf(to)
+	filter.ActiveFrom, filter.ActiveTo = &first, &last
+	query, args := models.NewListQueryBuilder().
+		WithFilter(filter).
+		BuildQuery()
+
+	resp := &models.UpcomingResponse{
+		From:   billing.Day(from).Format(models.DayLayout),
+		To:     billing.Day(to).Format(models.DayLayout),
+		Events: []models.UpcomingEvent{},
+	}
+	if err := r.eachPricedSubscription(query, args, filter.AsOf, func(s *models.Subscription) error {
+		resp.Events = append(resp.Events, billing.UpcomingEvents(s, from, to)...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list upcoming events: %w", err)
+	}
+	billing.SortEvents(resp.Events)
+	return resp, nil
+}
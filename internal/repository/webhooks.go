@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"testtask/internal/models"
+)
+
+const webhookColumns = "id, url, secret, events, active, created_at, updated_at"
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var events pq.StringArray
+	if err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	w.Events = []string(events)
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	return &w, nil
+}
+
+func (r *SubscriptionRepository) CreateWebhook(w *models.Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`
+
+	if err := r.db.QueryRow(query, w.URL, w.Secret, pq.Array(w.Events), w.Active).
+		Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return wrapError("failed to create webhook", err)
+	}
+	r.logger.WithField("webhook_id", w.ID).Info("Webhook created")
+	return nil
+}
+
+func (r *SubscriptionRepository) GetWebhook(id int) (*models.Webhook, error) {
+	w, err := scanWebhook(r.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("webhook %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to get webhook", err)
+	}
+	return w, nil
+}
+
+func (r *SubscriptionRepository) ListWebhooks() ([]*models.Webhook, error) {
+	rows, err := r.db.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, wrapError("failed to list webhooks", err)
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, wrapError("failed to scan webhook", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list webhooks", err)
+	}
+	return webhooks, nil
+}
+
+func (r *SubscriptionRepository) UpdateWebhook(w *models.Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $2, secret = $3, events = $4, active = $5, updated_at = now()
+		WHERE id = $1
+		RETURNING created_at, updated_at`
+
+	if err := r.db.QueryRow(query, w.ID, w.URL, w.Secret, pq.Array(w.Events), w.Active).
+		Scan(&w.CreatedAt, &w.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("webhook %d: %w", w.ID, ErrNotFound)
+		}
+		return wrapError("failed to update webhook", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook together with its deliveries.
+func (r *SubscriptionRepository) DeleteWebhook(id int) error {
+	result, err := r.db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return wrapError("failed to delete webhook", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return wrapError("failed to get rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("webhook %d: %w", id, ErrNotFound)
+	}
+	r.logger.WithField("webhook_id", id).Info("Webhook deleted")
+	return nil
+}
+
+// QueueExpiringEvents queues a subscription.expiring event for every live
+// subscription whose last day, the end of its end_date month as in
+// billing.LastDay, is at most within days away. Each subscription and end
+// date is queued once.
+func (r *SubscriptionRepository) QueueExpiringEvents(within int) (int64, error) {
+	query := `
+		WITH expiring AS (
+			SELECT s.*, (s.end_date + interval '1 month' - interval '1 day')::date AS last_day
+			FROM subscriptions s
+			WHERE s.deleted_at IS NULL AND s.end_date IS NOT NULL
+		)
+		INSERT INTO webhook_events (event_type, subscription_id, payload, dedup_key)
+		SELECT $1::text, e.id,
+			jsonb_build_object('last_day', e.last_day, 'subscription', to_jsonb(e) - 'last_day'),
+			$1::text || ':' || e.id || ':' || e.end_date
+		FROM expiring e
+		WHERE e.last_day BETWEEN current_date AND current_date + $2::int
+		ON CONFLICT (dedup_key) DO NOTHING`
+
+	result, err := r.db.Exec(query, models.EventSubscriptionExpiring, within)
+	if err != nil {
+		return 0, wrapError("failed to queue expiring events", err)
+	}
+	queued, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapError("failed to get rows affected", err)
+	}
+	return queued, nil
+}
+
+// FanOutWebhookEvents creates a delivery of each undispatched event for
+// every active webhook subscribed to it, at most limit events at a time.
+func (r *SubscriptionRepository) FanOutWebhookEvents(limit int) (int64, error) {
+	query := `
+		WITH events AS (
+			UPDATE webhook_events SET dispatched_at = now()
+			WHERE id IN (
+				SELECT id FROM webhook_events
+				WHERE dispatched_at IS NULL
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, event_type
+		)
+		INSERT INTO webhook_deliveries (event_id, webhook_id)
+		SELECT e.id, w.id
+		FROM events e
+		JOIN webhooks w ON w.active AND (cardinality(w.events) = 0 OR e.event_type = ANY (w.events))
+		ON CONFLICT (event_id, webhook_id) DO NOTHING`
+
+	result, err := r.db.Exec(query, limit)
+	if err != nil {
+		return 0, wrapError("failed to fan out webhook events", err)
+	}
+	created, err := result.RowsAffected()
+	if err != nil {
+		return 0, wrapError("failed to get rows affected", err)
+	}
+	return created, nil
+}
+
+// ClaimWebhookDeliveries picks up to limit due deliveries and counts an
+// attempt for each. They are not due again until lease has passed, so a
+// dispatcher that dies while sending them only delays them.
+func (r *SubscriptionRepository) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.OutgoingDelivery, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries
+			SET attempts = attempts + 1, next_attempt_at = now() + make_interval(secs => $2)
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= now()
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, event_id, webhook_id, attempts
+		)
+		SELECT c.id, c.attempts, w.url, w.secret, e.id, e.event_type, e.subscription_id, e.created_at, e.payload
+		FROM claimed c
+		JOIN webhooks w ON w.id = c.webhook_id
+		JOIN webhook_events e ON e.id = c.event_id
+		ORDER BY c.id`
+
+	rows, err := r.db.Query(query, limit, lease.Seconds())
+	if err != nil {
+		return nil, wrapError("failed to claim webhook deliveries", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.OutgoingDelivery
+	for rows.Next() {
+		var d models.OutgoingDelivery
+		if err := rows.Scan(&d.ID, &d.Attempts, &d.URL, &d.Secret,
+			&d.Event.ID, &d.Event.Type, &d.Event.SubscriptionID, &d.Event.CreatedAt, &d.Event.Data); err != nil {
+			return nil, wrapError("failed to scan webhook delivery", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to claim webhook deliveries", err)
+	}
+	return deliveries, nil
+}
+
+// CompleteWebhookDelivery marks a delivery as delivered.
+func (r *SubscriptionRepository) CompleteWebhookDelivery(id int64, status int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', last_status = $2, last_error = NULL, delivered_at = now()
+		WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id, status); err != nil {
+		return wrapError("failed to complete webhook delivery", err)
+	}
+	return nil
+}
+
+// FailWebhookDelivery records a failed attempt. The delivery is retried
+// at retryAt, or moved to the dead-letter list when retryAt is nil.
+// status is the HTTP status of the response, zero when there was none.
+func (r *SubscriptionRepository) FailWebhookDelivery(id int64, status int, msg string, retryAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $4::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+			next_attempt_at = COALESCE($4::timestamptz, next_attempt_at),
+			last_status = NULLIF($2, 0), last_error = $3
+		WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id, status, msg, retryAt); err != nil {
+		return wrapError("failed to record webhook delivery failure", err)
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event_id, e.event_type, d.status, d.attempts, d.next_attempt_at,
+	d.last_status, COALESCE(d.last_error, ''), d.delivered_at, d.created_at`
+
+func scanDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var lastStatus sql.NullInt64
+	if err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&lastStatus, &d.LastError, &d.DeliveredAt, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	if lastStatus.Valid {
+		status := int(lastStatus.Int64)
+		d.LastStatus = &status
+	}
+	return &d, nil
+}
+
+// ListDeadDeliveries returns the dead-letter list, newest first. A
+// webhookID of zero lists the dead deliveries of every webhook.
+func (r *SubscriptionRepository) ListDeadDeliveries(webhookID, limit int) ([]*models.WebhookDelivery, error) {
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhook_events e ON e.id = d.event_id
+		WHERE d.status = 'dead' AND ($1 = 0 OR d.webhook_id = $1)
+		ORDER BY d.id DESC
+		LIMIT $2`
+
+	rows, err := r.db.Query(query, webhookID, limit)
+	if err != nil {
+		return nil, wrapError("failed to list dead deliveries", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, wrapError("failed to scan webhook delivery", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("failed to list dead deliveries", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhookDelivery queues a delivery to be sent again right away
+// with a fresh attempt count, whatever its state.
+func (r *SubscriptionRepository) RedeliverWebhookDelivery(id int64) (*models.WebhookDelivery, error) {
+	query := `
+		WITH d AS (
+			UPDATE webhook_deliveries
+			SET status = 'pending', attempts = 0, next_attempt_at = now(), delivered_at = NULL
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT ` + deliveryColumns + `
+		FROM d
+		JOIN webhook_events e ON e.id = d.event_id`
+
+	d, err := scanDelivery(r.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("webhook delivery %d: %w", id, ErrNotFound)
+		}
+		return nil, wrapError("failed to redeliver webhook delivery", err)
+	}
+	r.logger.WithField("delivery_id", id).Info("Webhook delivery queued for redelivery")
+	return d, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"testtask/internal/models"
+)
+
+func TestWebhookOutbox(t *testing.T) {
+	repo := newTestRepository(t, nil)
+	webhooks := map[string]*models.Webhook{
+		"all":     {URL: "https://example.com/all", Secret: "secret-secret-secret", Events: []string{}, Active: true},
+		"deleted": {URL: "https://example.com/deleted", Secret: "secret-secret-secret", Events: []string{models.EventSubscriptionDeleted}, Active: true},
+		"paused":  {URL: "https://example.com/paused", Secret: "secret-secret-secret", Events: []string{}, Active: false},
+	}
+	for name, w := range webhooks {
+		if err := repo.CreateWebhook(w); err != nil {
+			t.Fatalf("CreateWebhook(%s): %v", name, err)
+		}
+	}
+	id := insertTestSubscription(t, repo, owner, "Netflix", 400, "01-2025")
+	if err := repo.DeleteSubscription(id, nil, "test"); err != nil {
+		t.Fatalf("DeleteSubscription: %v", err)
+	}
+
+	// Both events go to the webhook without a filter, the delete also to
+	// the one subscribed to it and nothing to the inactive one.
+	if created, err := repo.FanOutWebhookEvents(100); err != nil || created != 3 {
+		t.Fatalf("FanOutWebhookEvents = %d, %v, want 3", created, err)
+	}
+	if created, err := repo.FanOutWebhookEvents(100); err != nil || created != 0 {
+		t.Fatalf("second FanOutWebhookEvents = %d, %v, want 0", created, err)
+	}
+
+	deliveries, err := repo.ClaimWebhookDeliveries(100, time.Minute)
+	if err != nil || len(deliveries) != 3 {
+		t.Fatalf("ClaimWebhookDeliveries = %+v, %v", deliveries, err)
+	}
+	sent := map[string][]string{}
+	for _, d := range deliveries {
+		if d.Attempts != 1 || d.Event.SubscriptionID != id || len(d.Event.Data) == 0 {
+			t.Errorf("delivery = %+v", d)
+		}
+		sent[d.URL] = append(sent[d.URL], d.Event.Type)
+	}
+	if got := sent[webhooks["all"].URL]; len(got) != 2 || got[0] == got[1] {
+		t.Errorf("events sent to all = %v", got)
+	}
+	if got := sent[webhooks["deleted"].URL]; len(got) != 1 || got[0] != models.EventSubscriptionDeleted {
+		t.Errorf("events sent to deleted = %v", got)
+	}
+	if claimed, err := repo.ClaimWebhookDeliveries(100, time.Minute); err != nil || len(claimed) != 0 {
+		t.Fatalf("deliveries claimed twice within the lease: %+v, %v", claimed, err)
+	}
+
+	if err := repo.CompleteWebhookDelivery(deliveries[0].ID, 200); err != nil {
+		t.Fatalf("CompleteWebhookDelivery: %v", err)
+	}
+	retryAt := time.Now().Add(-time.Second)
+	if err := repo.FailWebhookDelivery(deliveries[1].ID, 500, "boom", &retryAt); err != nil {
+		t.Fatalf("FailWebhookDelivery: %v", err)
+	}
+	if err := repo.FailWebhookDelivery(deliveries[2].ID, 0, "refused", nil); err != nil {
+		t.Fatalf("FailWebhookDelivery: %v", err)
+	}
+	retried, err := repo.ClaimWebhookDeliveries(100, time.Minute)
+	if err != nil || len(retried) != 1 || retried[0].ID != deliveries[1].ID || retried[0].Attempts != 2 {
+		t.Errorf("retried deliveries = %+v, %v", retried, err)
+	}
+	dead, err := repo.ListDeadDeliveries(0, 10)
+	if err != nil || len(dead) != 1 || dead[0].ID != deliveries[2].ID || dead[0].LastError != "refused" || dead[0].LastStatus != nil {
+		t.Errorf("dead deliveries = %+v, %v", dead, err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package validation checks request models against their `binding` tags.
+//
+// Supported rules: required, omitempty, min=N, max=N (value for numbers,
+// length in characters for strings), oneof=a b c, uuid, currency (an ISO
+// 4217 code) and gtefield=Field.
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"testtask/internal/models"
+)
+
+// Errors lists every field that failed validation.
+type Errors []models.FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+type zeroer interface {
+	IsZero() bool
+}
+
+// Struct validates v, a struct or a pointer to one. It returns nil or a
+// non-empty Errors.
+func Struct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: %T is not a struct", v)
+	}
+	rt := rv.Type()
+
+	var errs Errors
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("binding")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := jsonName(field)
+		if msg := checkField(rv, rv.Field(i), strings.Split(tag, ",")); msg != "" {
+			errs = append(errs, models.FieldError{Field: name, Message: msg})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// checkField applies rules to value and returns the first violation.
+func checkField(parent, value reflect.Value, rules []string) string {
+	empty := isEmpty(value)
+	for _, rule := range rules {
+		name, _, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			if empty {
+				return "is required"
+			}
+		case "omitempty":
+			if empty {
+				return ""
+			}
+		}
+	}
+	if empty {
+		return ""
+	}
+
+	value = reflect.Indirect(value)
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				continue
+			}
+			if msg := checkBound(value, name, limit); msg != "" {
+				return msg
+			}
+		case "oneof":
+			if value.Kind() == reflect.String && !oneOf(value.String(), strings.Fields(arg)) {
+				return "must be one of: " + strings.Join(strings.Fields(arg), ", ")
+			}
+		case "currency":
+			if value.Kind() == reflect.String && !IsCurrencyCode(value.String()) {
+				return "must be a 3-letter ISO 4217 currency code"
+			}
+		case "uuid":
+			if value.Kind() == reflect.String {
+				if _, err := uuid.Parse(value.String()); err != nil {
+					return "must be a valid UUID"
+				}
+			}
+		case "gtefield":
+			other := reflect.Indirect(parent.FieldByName(arg))
+			if !other.IsValid() || isEmpty(other) {
+				continue
+			}
+			a, okA := timeOf(value)
+			b, okB := timeOf(other)
+			if okA && okB && a.Before(b) {
+				otherField, _ := parent.Type().FieldByName(arg)
+				return "must not be before " + jsonName(otherField)
+			}
+		}
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, rule string, limit int64) string {
+	var n int64
+	unit := ""
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.String:
+		n = int64(utf8.RuneCountInString(value.String()))
+		unit = " characters"
+	default:
+		return ""
+	}
+	if rule == "min" && n < limit {
+		if unit != "" {
+			return fmt.Sprintf("must be at least %d%s long", limit, unit)
+		}
+		return fmt.Sprintf("must be at least %d", limit)
+	}
+	if rule == "max" && n > limit {
+		if unit != "" {
+			return fmt.Sprintf("must be at most %d%s long", limit, unit)
+		}
+		return fmt.Sprintf("must be at most %d", limit)
+	}
+	return ""
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCurrencyCode reports whether s looks like an ISO 4217 code.
+func IsCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		return value.IsNil()
+	}
+	if z, ok := value.Interface().(zeroer); ok {
+		return z.IsZero()
+	}
+	return value.IsZero()
+}
+
+func timeOf(value reflect.Value) (time.Time, bool) {
+	switch v := value.Interface().(type) {
+	case models.MonthDate:
+		return v.Time, true
+	case time.Time:
+		return v, true
+	}
+	return time.Time{}, false
+}
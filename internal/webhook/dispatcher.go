@@ -0,0 +1,211 @@
+// Package webhook delivers queued subscription events to registered
+// webhooks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"testtask/internal/config"
+	"testtask/internal/models"
+)
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook secret.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Store is the part of the repository the dispatcher works on.
+type Store interface {
+	QueueExpiringEvents(within int) (int64, error)
+	FanOutWebhookEvents(limit int) (int64, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.OutgoingDelivery, error)
+	CompleteWebhookDelivery(id int64, status int) error
+	FailWebhookDelivery(id int64, status int, msg string, retryAt *time.Time) error
+}
+
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    config.WebhookConfig
+	logger *logrus.Logger
+}
+
+// NewDispatcher fills in defaults for unset settings.
+func NewDispatcher(store Store, cfg config.WebhookConfig, logger *logrus.Logger) *Dispatcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = 30 * time.Second
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = 6 * time.Hour
+	}
+	return &Dispatcher{
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout},
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Run dispatches events every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	lastExpiring := time.Time{}
+	for {
+		if time.Since(lastExpiring) >= time.Hour {
+			d.queueExpiring()
+			lastExpiring = time.Now()
+		}
+		d.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) queueExpiring() {
+	if d.cfg.ExpiringWithinDays <= 0 {
+		return
+	}
+	queued, err := d.store.QueueExpiringEvents(d.cfg.ExpiringWithinDays)
+	if err != nil {
+		d.logger.WithError(err).Error("failed to queue expiring subscription events")
+	} else if queued > 0 {
+		d.logger.Infof("Queued %d expiring subscription events", queued)
+	}
+}
+
+// RunOnce fans out new events and sends the deliveries that are due.
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	if _, err := d.store.FanOutWebhookEvents(d.cfg.BatchSize); err != nil {
+		d.logger.WithError(err).Error("failed to fan out webhook events")
+		return
+	}
+	deliveries, err := d.store.ClaimWebhookDeliveries(d.cfg.BatchSize, d.lease())
+	if err != nil {
+		d.logger.WithError(err).Error("failed to claim webhook deliveries")
+		return
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, delivery)
+	}
+}
+
+// lease is how long a batch stays claimed. The deliveries are sent one
+// after the other and each may take up to the timeout, so the lease covers
+// a whole batch, with one timeout to spare for recording the results.
+// Otherwise another dispatcher could claim and send them again while they
+// are still waiting their turn.
+func (d *Dispatcher) lease() time.Duration {
+	return time.Duration(d.cfg.BatchSize+1) * d.cfg.Timeout
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.OutgoingDelivery) {
+	entry := d.logger.WithField("delivery_id", delivery.ID).WithField("attempt", delivery.Attempts)
+	status, err := d.send(ctx, delivery)
+	if err == nil {
+		if err := d.store.CompleteWebhookDelivery(delivery.ID, status); err != nil {
+			entry.WithError(err).Error("failed to mark webhook delivery as delivered")
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if delivery.Attempts < d.cfg.MaxAttempts {
+		at := time.Now().Add(Backoff(delivery.Attempts, d.cfg.BackoffBase, d.cfg.BackoffMax))
+		retryAt = &at
+		entry.WithError(err).Warn("webhook delivery failed, will retry")
+	} else {
+		entry.WithError(err).Error("webhook delivery failed, moved to dead letters")
+	}
+	if err := d.store.FailWebhookDelivery(delivery.ID, status, err.Error(), retryAt); err != nil {
+		entry.WithError(err).Error("failed to record webhook delivery failure")
+	}
+}
+
+// send POSTs the event and returns the response status. Any status other
+// than 2xx is an error.
+func (d *Dispatcher) send(ctx context.Context, delivery models.OutgoingDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, "sha256="+Sign(delivery.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a delivery body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay before the retry after attempt: base doubled
+// for every earlier attempt, capped at max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"testtask/internal/config"
+	"testtask/internal/models"
+)
+
+// fakeStore hands out the deliveries it holds on the first claim and
+// records the results.
+type fakeStore struct {
+	deliveries []models.OutgoingDelivery
+	lease      time.Duration
+	completed  []int64
+	failed     []int64
+}
+
+func (s *fakeStore) QueueExpiringEvents(int) (int64, error) { return 0, nil }
+func (s *fakeStore) FanOutWebhookEvents(int) (int64, error) { return 0, nil }
+
+func (s *fakeStore) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.OutgoingDelivery, error) {
+	s.lease = lease
+	claimed := s.deliveries
+	s.deliveries = nil
+	return claimed, nil
+}
+
+func (s *fakeStore) CompleteWebhookDelivery(id int64, status int) error {
+	s.completed = append(s.completed, id)
+	return nil
+}
+
+func (s *fakeStore) FailWebhookDelivery(id int64, status int, msg string, retryAt *time.Time) error {
+	s.failed = append(s.failed, id)
+	return nil
+}
+
+func TestRunOnce(t *testing.T) {
+	const secret = "secret-secret-secret"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != "sha256="+Sign(secret, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event models.WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil || event.Type != r.Header.Get(HeaderEvent) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	event := models.WebhookEvent{ID: 1, Type: models.EventSubscriptionCreated, SubscriptionID: 7, Data: json.RawMessage(`{}`)}
+	store := &fakeStore{deliveries: []models.OutgoingDelivery{
+		{ID: 1, Attempts: 1, URL: server.URL + "/up", Secret: secret, Event: event},
+		{ID: 2, Attempts: 1, URL: server.URL + "/down", Secret: secret, Event: event},
+		{ID: 3, Attempts: 1, URL: server.URL + "/up", Secret: "wrong-secret-wrong", Event: event},
+	}}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.WebhookConfig{BatchSize: 50, Timeout: 2 * time.Second}
+	NewDispatcher(store, cfg, logger).RunOnce(context.Background())
+
+	if batch := time.Duration(cfg.BatchSize) * cfg.Timeout; store.lease < batch {
+		t.Errorf("lease = %s, shorter than sending a whole batch (%s)", store.lease, batch)
+	}
+	if len(store.completed) != 1 || store.completed[0] != 1 {
+		t.Errorf("completed = %v, want [1]", store.completed)
+	}
+	if len(store.failed) != 2 || store.failed[0] != 2 || store.failed[1] != 3 {
+		t.Errorf("failed = %v, want [2 3]", store.failed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, ceiling := 30*time.Second, 5*time.Minute
+	for attempt, want := range map[int]time.Duration{1: base, 2: time.Minute, 4: 4 * time.Minute, 5: ceiling, 20: ceiling} {
+		if got := Backoff(attempt, base, ceiling); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS subscription_history (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    before JSONB,
+    after JSONB,
+    actor VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS subscription_history_subscription_idx
+    ON subscription_history (subscription_id, changed_at);
+
+-- The history is append-only.
+CREATE OR REPLACE FUNCTION subscription_history_readonly() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'subscription_history is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS subscription_history_readonly ON subscription_history;
+CREATE TRIGGER subscription_history_readonly
+    BEFORE UPDATE OR DELETE ON subscription_history
+    FOR EACH ROW EXECUTE FUNCTION subscription_history_readonly();
+
+-- Subscriptions created before the history existed start with a create entry.
+INSERT INTO subscription_history (subscription_id, operation, before, after, actor, changed_at)
+SELECT s.id, 'create', NULL, to_jsonb(s), 'migration', s.updated_at
+FROM subscriptions s
+WHERE NOT EXISTS (SELECT 1 FROM subscription_history h WHERE h.subscription_id = s.id);
@@ -0,0 +1,6 @@
+-- There is no default currency in the schema: the application writes the
+-- currency of every row, using the configured default when a request has
+-- none.
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL
+        CHECK (currency ~ '^[A-Z]{3}$');
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS services (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    category VARCHAR(100),
+    default_price INTEGER CHECK (default_price > 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Lookup keys of the canonical names and aliases, lower-cased with white
+-- space collapsed by the application. alias is the spelling of an alias
+-- as entered and NULL for the canonical name.
+CREATE TABLE IF NOT EXISTS service_names (
+    key VARCHAR(100) PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES services (id) ON DELETE CASCADE,
+    alias VARCHAR(100)
+);
+
+CREATE INDEX IF NOT EXISTS service_names_service_id_idx ON service_names (service_id);
+
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS service_id INTEGER REFERENCES services (id);
+
+CREATE INDEX IF NOT EXISTS subscriptions_service_id_idx ON subscriptions (service_id);
+
+ALTER TABLE budgets
+    ADD COLUMN IF NOT EXISTS category VARCHAR(100);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'budgets_scope_check') THEN
+        ALTER TABLE budgets
+            ADD CONSTRAINT budgets_scope_check CHECK (service_name IS NULL OR category IS NULL);
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS budgets_scope_idx;
+CREATE UNIQUE INDEX budgets_scope_idx
+    ON budgets (user_id, COALESCE(service_name, ''), COALESCE(category, ''));
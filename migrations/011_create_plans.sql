@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id SERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES services (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    price INTEGER NOT NULL CHECK (price > 0),
+    currency CHAR(3) NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    billing_period VARCHAR(16) NOT NULL DEFAULT 'month'
+        CHECK (billing_period IN ('week', 'month', 'quarter', 'year')),
+    interval_count INTEGER NOT NULL DEFAULT 1 CHECK (interval_count BETWEEN 1 AND 100),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (service_id, name)
+);
+
+-- price keeps the price charged. Unless price_overridden is set it is the
+-- list price of the plan and follows it when the plan changes.
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS plan_id INTEGER REFERENCES plans (id),
+    ADD COLUMN IF NOT EXISTS price_overridden BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS subscriptions_plan_id_idx ON subscriptions (plan_id);
@@ -0,0 +1,14 @@
+-- Scheduled prices. An entry sets the price from effective_from, the first
+-- day of a month, on: for one subscription, or for every subscription on
+-- a plan that does not override the plan price.
+CREATE TABLE IF NOT EXISTS subscription_prices (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER REFERENCES subscriptions (id) ON DELETE CASCADE,
+    plan_id INTEGER REFERENCES plans (id) ON DELETE CASCADE,
+    price INTEGER NOT NULL CHECK (price > 0),
+    effective_from DATE NOT NULL CHECK (EXTRACT(DAY FROM effective_from) = 1),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((subscription_id IS NULL) <> (plan_id IS NULL)),
+    UNIQUE (subscription_id, effective_from),
+    UNIQUE (plan_id, effective_from)
+);
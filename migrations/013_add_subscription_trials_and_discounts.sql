@@ -0,0 +1,6 @@
+-- trial_end is the last free day. discounts is a JSON array of
+-- {"kind": "percent" | "fixed", "value": n, "months": n, "from": "MM-YYYY"}.
+ALTER TABLE subscriptions
+    ADD COLUMN IF NOT EXISTS trial_end DATE,
+    ADD COLUMN IF NOT EXISTS discounts JSONB NOT NULL DEFAULT '[]'
+        CHECK (jsonb_typeof(discounts) = 'array');
@@ -0,0 +1,14 @@
+-- Users sharing the cost of a subscription paid by its owner, the user_id
+-- of the subscription. A member pays either a fixed amount of every charge
+-- or a weighted part of what is left after fixed amounts; the owner keeps
+-- weight 1 unless listed.
+CREATE TABLE IF NOT EXISTS subscription_members (
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    weight INTEGER CHECK (weight > 0),
+    amount INTEGER CHECK (amount > 0),
+    PRIMARY KEY (subscription_id, user_id),
+    CHECK ((weight IS NULL) <> (amount IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS subscription_members_user_idx ON subscription_members (user_id);
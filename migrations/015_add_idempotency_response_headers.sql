@@ -0,0 +1,3 @@
+-- Headers sent with a stored response, such as ETag, replayed with it.
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS response_headers JSONB;
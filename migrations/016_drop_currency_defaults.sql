@@ -0,0 +1,25 @@
+-- Databases set up before the currency defaults were removed from 007, 009
+-- and 011 keep them until now.
+ALTER TABLE subscriptions ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE budgets ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE plans ALTER COLUMN currency DROP DEFAULT;
+
+-- History written before subscriptions had a currency gets the one the
+-- subscription was given, so that as_of reads need no default. Purged
+-- subscriptions keep theirs in the before state of the purge.
+WITH currencies AS (
+    SELECT id AS subscription_id, currency::text AS currency FROM subscriptions
+    UNION ALL
+    SELECT subscription_id, before->>'currency'
+    FROM subscription_history
+    WHERE operation = 'purge' AND before ? 'currency'
+)
+UPDATE subscription_history h
+SET before = CASE WHEN h.before IS NULL OR h.before ? 'currency' THEN h.before
+        ELSE h.before || jsonb_build_object('currency', c.currency) END,
+    after = CASE WHEN h.after IS NULL OR h.after ? 'currency' THEN h.after
+        ELSE h.after || jsonb_build_object('currency', c.currency) END
+FROM currencies c
+WHERE c.subscription_id = h.subscription_id
+    AND ((h.before IS NOT NULL AND NOT h.before ? 'currency')
+        OR (h.after IS NOT NULL AND NOT h.after ? 'currency'));